@@ -0,0 +1,2296 @@
+package secrun
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// LogEntry represents a structured log entry
+type LogEntry struct {
+	Timestamp string      `json:"timestamp"`
+	Level     string      `json:"level"`
+	Message   string      `json:"message"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// Logger defines the interface for logging
+type Logger interface {
+	Log(level, message string, data interface{})
+}
+
+// JSONLogger implements Logger with JSON format output
+type JSONLogger struct {
+	Output io.Writer
+	// DisableHTMLEscape writes <, > and & in strings as-is instead of as \u escapes
+	DisableHTMLEscape bool
+	// Level is the minimum level written; entries below it are dropped. Empty means info
+	Level string
+	// UpperCaseLevel writes the level field in upper case, e.g. INFO
+	UpperCaseLevel bool
+
+	out bufferedOutput
+}
+
+// bufferedOutput writes log lines directly or, once enabled, through a buffer
+type bufferedOutput struct {
+	mu  sync.Mutex
+	buf *bufio.Writer
+}
+
+// enable starts buffering lines written to w
+func (o *bufferedOutput) enable(w io.Writer) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.buf == nil {
+		o.buf = bufio.NewWriter(w)
+	}
+}
+
+// flush writes any buffered lines
+func (o *bufferedOutput) flush() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.buf == nil {
+		return nil
+	}
+	return o.buf.Flush()
+}
+
+// writeLine writes line and a newline to w, or to the buffer when enabled
+func (o *bufferedOutput) writeLine(w io.Writer, line []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.buf != nil {
+		o.buf.Write(line)
+		o.buf.WriteByte('\n')
+		return
+	}
+	fmt.Fprintln(w, string(line))
+}
+
+// Flusher is implemented by loggers that buffer their output
+type Flusher interface {
+	Flush() error
+}
+
+// EnableBuffering buffers log output until Flush is called or the buffer fills up
+func (l *JSONLogger) EnableBuffering() {
+	l.out.enable(l.Output)
+}
+
+// Flush writes any buffered log entries to the output
+func (l *JSONLogger) Flush() error {
+	return l.out.flush()
+}
+
+// logLevels orders the log levels from most to least verbose
+var logLevels = map[string]int{
+	"debug": 0,
+	"info":  1,
+	"warn":  2,
+	"error": 3,
+}
+
+// DefaultLogLevel is the threshold used when none is configured
+const DefaultLogLevel = "info"
+
+// levelEnabled reports whether entries at level pass the threshold; empty means info
+func levelEnabled(threshold, level string) bool {
+	if threshold == "" {
+		threshold = DefaultLogLevel
+	}
+	rank, ok := logLevels[level]
+	if !ok {
+		// Unknown levels are never dropped
+		return true
+	}
+	return rank >= logLevels[threshold]
+}
+
+// enabled reports whether entries at level pass the logger's threshold
+func (l *JSONLogger) enabled(level string) bool {
+	return levelEnabled(l.Level, level)
+}
+
+// Log outputs a structured log entry in JSON format
+func (l *JSONLogger) Log(level, message string, data interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+
+	if l.UpperCaseLevel {
+		level = strings.ToUpper(level)
+	}
+	entry := LogEntry{
+		Timestamp: time.Now().Format(time.RFC3339),
+		Level:     level,
+		Message:   message,
+		Data:      data,
+	}
+
+	var encoded bytes.Buffer
+	enc := json.NewEncoder(&encoded)
+	enc.SetEscapeHTML(!l.DisableHTMLEscape)
+	if err := enc.Encode(entry); err != nil {
+		// Fallback to plain text if JSON marshaling fails
+		fmt.Fprintf(os.Stderr, "Error marshaling log: %v\n", err)
+		return
+	}
+	jsonBytes := bytes.TrimSuffix(encoded.Bytes(), []byte("\n"))
+	l.out.writeLine(l.Output, jsonBytes)
+}
+
+// NewJSONLogger creates a new JSON logger writing to stderr, apart from the command's stdout
+func NewJSONLogger() *JSONLogger {
+	return &JSONLogger{
+		Output: os.Stderr,
+	}
+}
+
+// printKeys writes the sorted key names, one per line, never their values
+func printKeys(w io.Writer, envVars map[string]string) error {
+	keys := make([]string, 0, len(envVars))
+	for k := range envVars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, err := fmt.Fprintln(w, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logOutputOf returns the output of the loggers that write entries themselves
+func logOutputOf(logger Logger) *io.Writer {
+	switch l := logger.(type) {
+	case *JSONLogger:
+		return &l.Output
+	case *TextLogger:
+		return &l.Output
+	}
+	return nil
+}
+
+// openLogOutput resolves a --log-output destination: stdout, stderr or a file appended to
+func openLogOutput(dest string) (io.Writer, func() error, error) {
+	switch dest {
+	case "stdout":
+		return os.Stdout, func() error { return nil }, nil
+	case "stderr":
+		return os.Stderr, func() error { return nil }, nil
+	}
+
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open log output: %w", err)
+	}
+	return f, f.Close, nil
+}
+
+// SecretManager defines the interface for retrieving secrets
+type SecretManager interface {
+	GetSecret(secretName string) (string, error)
+}
+
+// SecretValue is a fetched secret together with the details of its version
+type SecretValue struct {
+	// String is the secret as injected: its secret string, or its binary payload
+	// rendered according to the binary mode
+	String string
+	// Binary is the raw payload of a binary secret; nil for a string secret
+	Binary []byte
+	// VersionID and Stages identify the version returned, where the backend versions secrets
+	VersionID string
+	Stages    []string
+	// Source is the backend that served the secret
+	Source string
+}
+
+// SecretFetcher is implemented by secret managers that return a fetched secret with
+// its version details rather than only its string; a zero version fetches the current one
+type SecretFetcher interface {
+	FetchSecret(secretName string, version SecretVersion) (*SecretValue, error)
+}
+
+// fetchSecret fetches a secret through SecretFetcher when sm implements it, and
+// otherwise wraps the string returned by GetSecret or GetSecretVersion
+func fetchSecret(sm SecretManager, secretName string, version SecretVersion) (*SecretValue, error) {
+	if fetcher, ok := sm.(SecretFetcher); ok {
+		value, err := fetcher.FetchSecret(secretName, version)
+		if err == nil && value.Source == "" {
+			value.Source = sourceOf(sm)
+		}
+		return value, err
+	}
+
+	var secretString string
+	var err error
+	if version != (SecretVersion{}) {
+		versioned, ok := sm.(VersionedSecretManager)
+		if !ok {
+			return nil, fmt.Errorf("version selection is not supported")
+		}
+		secretString, err = versioned.GetSecretVersion(secretName, version)
+	} else {
+		secretString, err = sm.GetSecret(secretName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &SecretValue{String: secretString, Source: sourceOf(sm)}, nil
+}
+
+// SecretMetadata describes the version of a secret returned by the last fetch
+type SecretMetadata struct {
+	CreatedDate time.Time `json:"createdDate"`
+	// RequestID is the AWS request id of the fetch, for correlating with AWS support
+	RequestID string `json:"requestId,omitempty"`
+	// LatencyMs is how long the fetch took, including retries
+	LatencyMs int64 `json:"latencyMs,omitempty"`
+}
+
+// SecretMetadataProvider is implemented by secret managers that record metadata of fetched secrets
+type SecretMetadataProvider interface {
+	SecretMetadata(secretName string) (SecretMetadata, bool)
+}
+
+// SecretVersion selects a version of a secret by id or by staging label
+type SecretVersion struct {
+	ID    string `json:"id,omitempty"`
+	Stage string `json:"stage,omitempty"`
+}
+
+// VersionedSecretManager is implemented by secret managers that can fetch a specific version
+type VersionedSecretManager interface {
+	GetSecretVersion(secretName string, version SecretVersion) (string, error)
+}
+
+// ContextSetter is implemented by secret managers whose requests can be bound to a context
+type ContextSetter interface {
+	SetContext(ctx context.Context)
+}
+
+// EncryptionContextSetter is implemented by secret managers that decrypt with a
+// caller-supplied KMS encryption context. No built-in backend implements it:
+// Secrets Manager and Parameter Store bind their own context to each value, and
+// the others do not decrypt with KMS.
+type EncryptionContextSetter interface {
+	SetEncryptionContext(encryptionContext map[string]string)
+}
+
+// AWSConfigProvider is implemented by secret managers that load an AWS config
+// once and share it between their requests
+type AWSConfigProvider interface {
+	LoadConfig() (aws.Config, error)
+}
+
+// usesAWSConfig reports whether the run fetches from a backend that uses the
+// AWS config of the default secret manager
+func usesAWSConfig(opts *Options) bool {
+	if opts.CommandFromSecret != "" {
+		return true
+	}
+	for _, spec := range opts.Secrets {
+		switch spec.Source {
+		case "", SourceSecretsManager, SourceSSM, SourceAppConfig:
+			return true
+		}
+	}
+	return false
+}
+
+// Cleaner is implemented by secret managers that leave resources behind for the command
+type Cleaner interface {
+	Cleanup() error
+}
+
+// SourceNamer is implemented by secret managers that report which backend they read from
+type SourceNamer interface {
+	Source() string
+}
+
+// Secret sources selectable per flag on the command line
+const (
+	SourceSecretsManager = "aws-sm"
+	SourceAppConfig      = "appconfig"
+	SourceSSM            = "ssm"
+	SourceVault          = "vault"
+	SourceGCP            = "gcp"
+	SourceAzure          = "azure"
+)
+
+// sourceNames holds human-readable names of the secret sources for logging
+var sourceNames = map[string]string{
+	SourceSecretsManager: "AWS Secrets Manager",
+	SourceAppConfig:      "AWS AppConfig",
+	SourceSSM:            "SSM Parameter Store",
+	SourceVault:          "HashiCorp Vault",
+	SourceGCP:            "Google Cloud Secret Manager",
+	SourceAzure:          "Azure Key Vault",
+}
+
+// configLoader loads the AWS configuration, matching config.LoadDefaultConfig
+type configLoader func(ctx context.Context, optFns ...func(*config.LoadOptions) error) (aws.Config, error)
+
+// AWSSecretManager implements SecretManager using AWS SecretsManager
+type AWSSecretManager struct {
+	ctx context.Context
+	// Logger, when set, receives diagnostics about secret fetches
+	Logger Logger
+
+	region          string
+	configFiles     []string
+	credentialFiles []string
+	// noSharedConfig skips the shared config and credentials files entirely
+	noSharedConfig bool
+	// profile selects a named profile from the shared files, taking precedence over AWS_PROFILE
+	profile    string
+	loadConfig configLoader
+	// endpointURL replaces the default endpoint of every AWS client built from the configuration
+	endpointURL string
+
+	// roleARN, when set, is assumed through STS on top of the default credentials
+	roleARN      string
+	externalID   string
+	newSTSClient func(cfg aws.Config) stscreds.AssumeRoleAPIClient
+
+	// Transient errors are retried up to maxRetries times with exponential backoff.
+	// sleep, when set, replaces the wait between retries, which otherwise ends early
+	// once ctx is done.
+	maxRetries     int
+	retryBaseDelay time.Duration
+	sleep          func(time.Duration)
+
+	// The configuration and client are built once on first use and then reused
+	configOnce sync.Once
+	cfg        aws.Config
+	cfgErr     error
+	clientOnce sync.Once
+	client     secretsManagerAPI
+
+	// binaryMode selects how SecretBinary payloads are returned
+	binaryMode string
+
+	mu        sync.Mutex
+	metadata  map[string]SecretMetadata
+	tempFiles []string
+	// prefetched holds secrets returned by Prefetch until GetSecret takes them
+	prefetched map[string]types.SecretValueEntry
+	// retries counts the GetSecretValue requests retried
+	retries int
+}
+
+// secretsManagerAPI is the subset of the Secrets Manager client used by AWSSecretManager
+type secretsManagerAPI interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+	DescribeSecret(ctx context.Context, params *secretsmanager.DescribeSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error)
+	BatchGetSecretValue(ctx context.Context, params *secretsmanager.BatchGetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.BatchGetSecretValueOutput, error)
+}
+
+// AWSOption configures an AWSSecretManager
+type AWSOption func(*AWSSecretManager)
+
+// WithRegion overrides the region resolved by the default configuration chain
+func WithRegion(region string) AWSOption {
+	return func(sm *AWSSecretManager) {
+		sm.region = region
+	}
+}
+
+// WithSharedConfigFiles reads the shared AWS config from the given files instead of ~/.aws/config
+func WithSharedConfigFiles(files ...string) AWSOption {
+	return func(sm *AWSSecretManager) {
+		sm.configFiles = files
+	}
+}
+
+// WithSharedCredentialsFiles reads the shared AWS credentials from the given files instead of ~/.aws/credentials
+func WithSharedCredentialsFiles(files ...string) AWSOption {
+	return func(sm *AWSSecretManager) {
+		sm.credentialFiles = files
+	}
+}
+
+// WithoutSharedConfig ignores ~/.aws/config and ~/.aws/credentials, leaving only
+// explicit options and the environment to configure the client
+func WithoutSharedConfig() AWSOption {
+	return func(sm *AWSSecretManager) {
+		sm.noSharedConfig = true
+	}
+}
+
+// WithProfile selects the named profile of the shared config and credentials files
+func WithProfile(profile string) AWSOption {
+	return func(sm *AWSSecretManager) {
+		sm.profile = profile
+	}
+}
+
+// WithEndpointURL sends requests to a custom endpoint such as LocalStack. The
+// configuration is shared, so the SSM and AppConfig backends use it as well.
+func WithEndpointURL(endpointURL string) AWSOption {
+	return func(sm *AWSSecretManager) {
+		sm.endpointURL = endpointURL
+	}
+}
+
+// Modes for returning SecretBinary payloads
+const (
+	BinaryModeBase64 = "base64"
+	BinaryModeFile   = "file"
+)
+
+// WithBinaryMode selects whether binary secrets are returned base64-encoded or as a file path
+func WithBinaryMode(mode string) AWSOption {
+	return func(sm *AWSSecretManager) {
+		sm.binaryMode = mode
+	}
+}
+
+// WithAssumeRole assumes roleARN, optionally with an external ID, for all requests
+func WithAssumeRole(roleARN, externalID string) AWSOption {
+	return func(sm *AWSSecretManager) {
+		sm.roleARN = roleARN
+		sm.externalID = externalID
+	}
+}
+
+// WithRetries sets how often transient errors are retried and the initial backoff delay
+func WithRetries(maxRetries int, baseDelay time.Duration) AWSOption {
+	return func(sm *AWSSecretManager) {
+		sm.maxRetries = maxRetries
+		sm.retryBaseDelay = baseDelay
+	}
+}
+
+// NewAWSSecretManager creates a new AWSSecretManager
+func NewAWSSecretManager(opts ...AWSOption) *AWSSecretManager {
+	sm := &AWSSecretManager{
+		ctx:        context.Background(),
+		loadConfig: config.LoadDefaultConfig,
+		metadata:   map[string]SecretMetadata{},
+		newSTSClient: func(cfg aws.Config) stscreds.AssumeRoleAPIClient {
+			return sts.NewFromConfig(cfg)
+		},
+
+		maxRetries:     DefaultMaxRetries,
+		retryBaseDelay: DefaultRetryBaseDelay,
+	}
+	for _, opt := range opts {
+		opt(sm)
+	}
+	return sm
+}
+
+// log writes to the manager's logger if one is set
+func (sm *AWSSecretManager) log(level, message string, data interface{}) {
+	if sm.Logger != nil {
+		sm.Logger.Log(level, message, data)
+	}
+}
+
+// LoadConfig loads the AWS configuration with the manager's options applied.
+// The configuration is loaded on the first call and reused afterwards.
+func (sm *AWSSecretManager) LoadConfig() (aws.Config, error) {
+	sm.configOnce.Do(func() {
+		var optFns []func(*config.LoadOptions) error
+		if sm.region != "" {
+			optFns = append(optFns, config.WithRegion(sm.region))
+		}
+		if len(sm.configFiles) > 0 {
+			optFns = append(optFns, config.WithSharedConfigFiles(sm.configFiles))
+		}
+		if len(sm.credentialFiles) > 0 {
+			optFns = append(optFns, config.WithSharedCredentialsFiles(sm.credentialFiles))
+		}
+		if sm.profile != "" {
+			if env := os.Getenv("AWS_PROFILE"); env != "" && env != sm.profile {
+				sm.log("debug", "--profile overrides AWS_PROFILE", map[string]string{
+					"profile":    sm.profile,
+					"envProfile": env,
+				})
+			}
+			optFns = append(optFns, config.WithSharedConfigProfile(sm.profile))
+		}
+		if sm.endpointURL != "" {
+			optFns = append(optFns, config.WithBaseEndpoint(sm.endpointURL))
+		}
+		if sm.noSharedConfig {
+			// Empty, non-nil file lists stop the SDK from falling back to the default paths
+			optFns = append(optFns,
+				config.WithSharedConfigFiles([]string{}),
+				config.WithSharedCredentialsFiles([]string{}),
+			)
+		}
+
+		sm.cfg, sm.cfgErr = sm.loadConfig(sm.ctx, optFns...)
+		if sm.cfgErr != nil {
+			sm.cfgErr = fmt.Errorf("failed to load AWS config: %w", sm.cfgErr)
+			return
+		}
+
+		if sm.roleARN != "" {
+			// The base credentials from the default chain are used to assume the role,
+			// and the assumed credentials are cached until they expire
+			provider := stscreds.NewAssumeRoleProvider(sm.newSTSClient(sm.cfg), sm.roleARN, func(o *stscreds.AssumeRoleOptions) {
+				if sm.externalID != "" {
+					o.ExternalID = aws.String(sm.externalID)
+				}
+			})
+			sm.cfg.Credentials = aws.NewCredentialsCache(provider)
+		}
+	})
+	return sm.cfg, sm.cfgErr
+}
+
+// getClient returns the Secrets Manager client, creating it on first use
+func (sm *AWSSecretManager) getClient(cfg aws.Config) secretsManagerAPI {
+	sm.clientOnce.Do(func() {
+		if sm.client == nil {
+			sm.client = secretsmanager.NewFromConfig(cfg)
+		}
+	})
+	return sm.client
+}
+
+// SetContext sets the context used for subsequent requests
+func (sm *AWSSecretManager) SetContext(ctx context.Context) {
+	sm.ctx = ctx
+}
+
+// Source reports the backend label of AWSSecretManager
+func (sm *AWSSecretManager) Source() string {
+	return SourceSecretsManager
+}
+
+// SecretMetadata returns the metadata recorded when the secret was last fetched
+func (sm *AWSSecretManager) SecretMetadata(secretName string) (SecretMetadata, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	meta, ok := sm.metadata[secretName]
+	return meta, ok
+}
+
+// SecretTags returns the tags attached to a secret in AWS Secrets Manager
+func (sm *AWSSecretManager) SecretTags(secretName string) (map[string]string, error) {
+	cfg, err := sm.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := sm.getClient(cfg).DescribeSecret(sm.ctx, &secretsmanager.DescribeSecretInput{
+		SecretId: aws.String(secretName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe secret: %w", err)
+	}
+
+	tags := make(map[string]string, len(result.Tags))
+	for _, tag := range result.Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags, nil
+}
+
+// GetSecret retrieves the current version of a secret from AWS Secrets Manager
+func (sm *AWSSecretManager) GetSecret(secretName string) (string, error) {
+	return sm.GetSecretVersion(secretName, SecretVersion{})
+}
+
+// GetSecretVersion retrieves a specific version of a secret from AWS Secrets Manager
+func (sm *AWSSecretManager) GetSecretVersion(secretName string, version SecretVersion) (string, error) {
+	value, err := sm.FetchSecret(secretName, version)
+	if err != nil {
+		return "", err
+	}
+	return value.String, nil
+}
+
+// FetchSecret retrieves a version of a secret from AWS Secrets Manager with its version id and stages
+func (sm *AWSSecretManager) FetchSecret(secretName string, version SecretVersion) (*SecretValue, error) {
+	if version.ID != "" && version.Stage != "" {
+		return nil, fmt.Errorf("version id and version stage are mutually exclusive")
+	}
+	if version == (SecretVersion{}) {
+		if entry, ok := sm.takePrefetched(secretName); ok {
+			return sm.secretValue(secretName, entry.SecretString, entry.SecretBinary, entry.VersionId, entry.VersionStages)
+		}
+	}
+
+	// Load AWS configuration
+	cfg, err := sm.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	sm.log("info", "Using AWS region", map[string]string{"secretName": secretName, "region": cfg.Region})
+
+	// Reuse the Secrets Manager client across secrets
+	svc := sm.getClient(cfg)
+
+	// Get the secret value
+	input := &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(secretName),
+	}
+	if version.ID != "" {
+		input.VersionId = aws.String(version.ID)
+	}
+	if version.Stage != "" {
+		input.VersionStage = aws.String(version.Stage)
+	}
+
+	start := time.Now()
+	result, err := sm.getSecretValue(svc, input)
+	latency := time.Since(start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret value: %w", err)
+	}
+
+	requestID, _ := awsmiddleware.GetRequestIDMetadata(result.ResultMetadata)
+	sm.mu.Lock()
+	sm.metadata[secretName] = SecretMetadata{
+		CreatedDate: aws.ToTime(result.CreatedDate),
+		RequestID:   requestID,
+		LatencyMs:   latency.Milliseconds(),
+	}
+	sm.mu.Unlock()
+
+	return sm.secretValue(secretName, result.SecretString, result.SecretBinary, result.VersionId, result.VersionStages)
+}
+
+// secretValue builds the SecretValue of a fetched version, rendering a SecretBinary by binary mode
+func (sm *AWSSecretManager) secretValue(secretName string, secretString *string, binary []byte, versionID *string, stages []string) (*SecretValue, error) {
+	value := &SecretValue{
+		String:    aws.ToString(secretString),
+		VersionID: aws.ToString(versionID),
+		Stages:    stages,
+		Source:    SourceSecretsManager,
+	}
+	// Binary secrets are passed on encoded or as a file, as env vars cannot hold arbitrary bytes
+	if secretString == nil && binary != nil {
+		rendered, err := sm.binarySecret(secretName, binary)
+		if err != nil {
+			return nil, err
+		}
+		value.String = rendered
+		value.Binary = binary
+	}
+	return value, nil
+}
+
+// binarySecret renders a SecretBinary payload according to the manager's binary mode
+func (sm *AWSSecretManager) binarySecret(secretName string, payload []byte) (string, error) {
+	if sm.binaryMode != BinaryModeFile {
+		return base64.StdEncoding.EncodeToString(payload), nil
+	}
+
+	// CreateTemp creates the file with mode 0600
+	f, err := os.CreateTemp("", "awsecrun-secret-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create file for binary secret: %w", err)
+	}
+	sm.mu.Lock()
+	sm.tempFiles = append(sm.tempFiles, f.Name())
+	sm.mu.Unlock()
+
+	if _, err := f.Write(payload); err != nil {
+		f.Close()
+		return "", fmt.Errorf("failed to write binary secret: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("failed to write binary secret: %w", err)
+	}
+	sm.log("info", "Wrote binary secret to file", map[string]string{"secretName": secretName, "path": f.Name()})
+	return f.Name(), nil
+}
+
+// Cleanup removes the files written for binary secrets
+func (sm *AWSSecretManager) Cleanup() error {
+	sm.mu.Lock()
+	files := sm.tempFiles
+	sm.tempFiles = nil
+	sm.mu.Unlock()
+
+	var errs []error
+	for _, name := range files {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// getSecretValue calls GetSecretValue, retrying throttling and transient errors with backoff
+func (sm *AWSSecretManager) getSecretValue(svc secretsManagerAPI, input *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	for attempt := 1; ; attempt++ {
+		// This loop does the retrying, so the SDK's own retryer would only multiply the attempts
+		result, err := svc.GetSecretValue(sm.ctx, input, withoutSDKRetries)
+		if err == nil || attempt > sm.maxRetries || !isRetryable(err) {
+			return result, err
+		}
+
+		sm.mu.Lock()
+		sm.retries++
+		sm.mu.Unlock()
+		delay := backoffDelay(sm.retryBaseDelay, attempt)
+		sm.log("warn", "Retrying secret fetch after transient error", map[string]interface{}{
+			"secretName": aws.ToString(input.SecretId),
+			"attempt":    attempt,
+			"delay":      delay.String(),
+			"error":      err.Error(),
+		})
+		if err := sm.wait(delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// withoutSDKRetries disables the SDK's retryer for a single operation
+func withoutSDKRetries(o *secretsmanager.Options) {
+	o.Retryer = aws.NopRetryer{}
+}
+
+// wait pauses for d between retries, returning the context's error if ctx is done first
+func (sm *AWSSecretManager) wait(d time.Duration) error {
+	if sm.sleep != nil {
+		sm.sleep(d)
+		return sm.ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-sm.ctx.Done():
+		return sm.ctx.Err()
+	}
+}
+
+// CommandRunner defines the interface for running commands
+type CommandRunner interface {
+	Run(commandPath string, args []string, env []string) error
+}
+
+// DefaultCommandRunner implements CommandRunner using os/exec
+type DefaultCommandRunner struct {
+	Stdout io.Writer
+	Stderr io.Writer
+	Stdin  io.Reader
+	// Logger receives captured output and runner diagnostics
+	Logger Logger
+
+	// Chroot, when set, runs the command with this directory as its root (Unix only)
+	Chroot string
+	// Dir, when set, is the command's working directory
+	Dir string
+	// Argv0, when set, replaces the argv[0] seen by the command
+	Argv0 string
+
+	// Detach starts the command in its own session and returns without waiting
+	Detach bool
+	// DetachOutput is the file a detached command's stdout and stderr are appended to
+	DetachOutput string
+	// PIDFile, when set, receives the PID of a detached command
+	PIDFile string
+	// PID is the process ID of the last detached command
+	PID int
+
+	// CaptureOutput logs the command's output line by line instead of streaming it
+	CaptureOutput bool
+	// OutputLimit caps the number of captured output bytes; zero means unlimited
+	OutputLimit int64
+	// OutputJSON, when set, captures stdout and stderr and writes them with the
+	// exit code as a JSON CommandResult to this file, or to stdout for -
+	OutputJSON string
+	// FailIfEmptyStdout treats a successful command that wrote nothing to stdout as failed
+	FailIfEmptyStdout bool
+	// ScrubSecrets are masked with ScrubMask wherever they appear in the command's output
+	ScrubSecrets []string
+	ScrubMask    Mask
+
+	// Signals, when set, replaces the process signal subscription forwarded to the command
+	Signals <-chan os.Signal
+	// KillTimeout is how long a signalled command may run before it is killed; zero waits indefinitely
+	KillTimeout time.Duration
+	// Context, when set, terminates the command once cancelled: it is sent SIGTERM
+	// and killed after KillTimeout, as for a forwarded signal
+	Context context.Context
+	// NoInheritPath fails to resolve a bare command name when the command's environment
+	// has no PATH, instead of searching our own PATH
+	NoInheritPath bool
+}
+
+// NewCommandRunner creates a new DefaultCommandRunner
+func NewCommandRunner() *DefaultCommandRunner {
+	return &DefaultCommandRunner{
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		Stdin:  os.Stdin,
+	}
+}
+
+// command builds the exec.Cmd for the given invocation
+func (cr *DefaultCommandRunner) command(commandPath string, args []string, env []string) (*exec.Cmd, error) {
+	path, err := cr.resolveCommand(commandPath, env)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(path, args...)
+	// The command still sees the name it was given, not the resolved path
+	cmd.Args[0] = commandPath
+	if cr.Argv0 != "" {
+		cmd.Args[0] = cr.Argv0
+	}
+	cmd.Stdout = cr.Stdout
+	cmd.Stderr = cr.Stderr
+	cmd.Stdin = cr.Stdin
+	cmd.Env = env
+	cmd.Dir = cr.Dir
+
+	if err := cr.configureProcAttr(cmd); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// resolveCommand finds a bare command name in the PATH of env, the environment the
+// command runs with, rather than in our own. Without a PATH in env our own PATH is
+// searched unless NoInheritPath is set. Paths, and names under --chroot, are left as is.
+func (cr *DefaultCommandRunner) resolveCommand(commandPath string, env []string) (string, error) {
+	if cr.Chroot != "" || commandPath == "" || filepath.Base(commandPath) != commandPath {
+		return commandPath, nil
+	}
+
+	pathList, ok := lookupEnv(env, "PATH")
+	if !ok {
+		if cr.NoInheritPath {
+			return "", fmt.Errorf("command %s not found: the command's environment has no PATH and --no-inherit-path is set", commandPath)
+		}
+		pathList = os.Getenv("PATH")
+	}
+	for _, dir := range filepath.SplitList(pathList) {
+		// Like exec.LookPath, never resolve against the working directory
+		if !filepath.IsAbs(dir) {
+			continue
+		}
+		if path, ok := findExecutable(dir, commandPath); ok {
+			if cr.Logger != nil {
+				cr.Logger.Log("debug", "Resolved command path", map[string]string{"command": commandPath, "path": path})
+			}
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("command %s not found in PATH", commandPath)
+}
+
+// Run executes a command with the given args and environment
+func (cr *DefaultCommandRunner) Run(commandPath string, args []string, env []string) error {
+	cmd, err := cr.command(commandPath, args, env)
+	if err != nil {
+		return err
+	}
+
+	if cr.Detach {
+		return cr.start(cmd)
+	}
+
+	if cr.CaptureOutput {
+		capture := newCaptureWriter(cr.Logger, cr.OutputLimit)
+		defer capture.Flush()
+		cmd.Stdout = capture.Stream("stdout")
+		cmd.Stderr = capture.Stream("stderr")
+	}
+	var stdout, stderr *limitedBuffer
+	if cr.OutputJSON != "" {
+		stdout = &limitedBuffer{limit: cr.OutputLimit}
+		stderr = &limitedBuffer{limit: cr.OutputLimit}
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+	}
+
+	var scrubbers []*scrubWriter
+	if len(cr.ScrubSecrets) > 0 {
+		stdoutScrub := newScrubWriter(cmd.Stdout, cr.ScrubSecrets, cr.ScrubMask)
+		stderrScrub := newScrubWriter(cmd.Stderr, cr.ScrubSecrets, cr.ScrubMask)
+		cmd.Stdout, cmd.Stderr = stdoutScrub, stderrScrub
+		scrubbers = append(scrubbers, stdoutScrub, stderrScrub)
+	}
+
+	var stdoutCount *countingWriter
+	if cr.FailIfEmptyStdout {
+		stdoutCount = &countingWriter{w: cmd.Stdout}
+		cmd.Stdout = stdoutCount
+	}
+
+	sigCh := cr.Signals
+	if sigCh == nil {
+		ch, stop := notifySignals()
+		defer stop()
+		sigCh = ch
+	}
+
+	if cr.Context != nil && cr.Context.Err() != nil {
+		return fmt.Errorf("command cancelled: %w", context.Cause(cr.Context))
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// Relay signals to the command for as long as it runs
+	done := make(chan struct{})
+	if cr.Context != nil {
+		sigCh = contextSignals(cr.Context, sigCh, done, cr.Logger)
+	}
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		forwardSignals(cr.signalTarget(cmd), sigCh, cr.KillTimeout, done, cr.Logger)
+	}()
+
+	err = cmd.Wait()
+	close(done)
+	<-forwarded
+	if cr.Context != nil && cr.Context.Err() != nil {
+		// The exit status is kept so the exit code reflects how the command ended
+		err = fmt.Errorf("command cancelled: %w", errors.Join(context.Cause(cr.Context), err))
+	}
+
+	// Output held back as a possible partial secret is written once the streams close
+	for _, sw := range scrubbers {
+		if flushErr := sw.Flush(); flushErr != nil && err == nil {
+			err = flushErr
+		}
+	}
+
+	if cr.OutputJSON != "" {
+		result := CommandResult{
+			Stdout:    stdout.String(),
+			Stderr:    stderr.String(),
+			Truncated: stdout.Truncated() || stderr.Truncated(),
+		}
+		if err != nil {
+			result.ExitCode = ExitCode(err)
+		}
+		if writeErr := writeCommandResult(cr.OutputJSON, result); writeErr != nil && err == nil {
+			return writeErr
+		}
+	}
+	if err == nil && stdoutCount != nil && stdoutCount.Count() == 0 {
+		return fmt.Errorf("command exited successfully but wrote nothing to stdout (--fail-if-empty-stdout)")
+	}
+	return err
+}
+
+// start launches a detached command with its stdio redirected away from the terminal
+func (cr *DefaultCommandRunner) start(cmd *exec.Cmd) error {
+	// A nil stdio stream is connected to the null device
+	cmd.Stdin = nil
+	cmd.Stdout = nil
+	cmd.Stderr = nil
+
+	if cr.DetachOutput != "" {
+		out, err := os.OpenFile(cr.DetachOutput, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open detach output: %w", err)
+		}
+		defer out.Close()
+		cmd.Stdout = out
+		cmd.Stderr = out
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	cr.PID = cmd.Process.Pid
+
+	if cr.PIDFile != "" {
+		if err := os.WriteFile(cr.PIDFile, []byte(strconv.Itoa(cr.PID)+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to write PID file: %w", err)
+		}
+	}
+
+	return cmd.Process.Release()
+}
+
+// Application contains all dependencies
+type Application struct {
+	Logger        Logger
+	SecretManager SecretManager
+	CommandRunner CommandRunner
+	Args          []string
+	// Options, when set, is used instead of parsing Args
+	Options *Options
+
+	// Backends holds the secret managers for sources other than Secrets Manager
+	Backends map[string]SecretManager
+
+	// Prompter asks for confirmation before running against production secrets
+	Prompter *Prompter
+
+	// Now is the clock used to time the phases of a run; nil uses time.Now
+	Now func() time.Time
+	// Sleep waits between command retries; nil uses time.Sleep
+	Sleep func(time.Duration)
+	// Rand jitters the delay between command retries; nil uses a generator seeded from the clock
+	Rand *rand.Rand
+
+	// ctx is the context of the current RunContext call
+	ctx context.Context
+}
+
+// NewApplication creates a new Application with default implementations
+func NewApplication(args []string) *Application {
+	secretManager := NewAWSSecretManager()
+
+	// Other AWS backends share the Secrets Manager configuration, such as --region
+	appConfigManager := NewAppConfigManager()
+	appConfigManager.loadConfig = secretManager.LoadConfig
+	ssmManager := NewSSMSecretManager()
+	ssmManager.loadConfig = secretManager.LoadConfig
+
+	return &Application{
+		Logger:        NewJSONLogger(),
+		SecretManager: secretManager,
+		CommandRunner: NewCommandRunner(),
+		Args:          args,
+		Backends: map[string]SecretManager{
+			SourceAppConfig: appConfigManager,
+			SourceSSM:       ssmManager,
+			SourceVault:     NewVaultSecretManager(),
+			SourceGCP:       NewGCPSecretManager(),
+			SourceAzure:     NewAzureSecretManager(),
+		},
+		Prompter: NewPrompter(),
+	}
+}
+
+// secretManager returns the SecretManager that serves the given source
+func (app *Application) secretManager(source string) (SecretManager, error) {
+	if source == "" || source == SourceSecretsManager {
+		return app.SecretManager, nil
+	}
+	if sm, ok := app.Backends[source]; ok {
+		return sm, nil
+	}
+	return nil, fmt.Errorf("no secret manager configured for source %s", source)
+}
+
+// parseSecretJSON parses a JSON secret string and returns a map of key-value pairs
+func parseSecretJSON(secretString string) (map[string]string, error) {
+	secretMap := make(map[string]string)
+
+	// Try to parse as JSON first
+	err := json.Unmarshal([]byte(secretString), &secretMap)
+	if err != nil {
+		// If not JSON, just use the raw string as the value
+		return map[string]string{"secret": secretString}, nil
+	}
+
+	return secretMap, nil
+}
+
+// verifySecretHash checks a secret's raw string against an expected SHA-256 hex digest
+func verifySecretHash(secretString, expected string) error {
+	sum := sha256.Sum256([]byte(secretString))
+	actual := hex.EncodeToString(sum[:])
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("hash mismatch: expected %s, got %s", expected, actual)
+	}
+	return nil
+}
+
+// loadedSecret is a fetched secret expanded into env var key-value pairs
+type loadedSecret struct {
+	Spec *SecretSpec
+	// Source is the backend that served the secret
+	Source string
+	Values map[string]string
+	// Size is the length in bytes of the secret as returned by the backend
+	Size int
+	// Production is set when the secret is tagged as a production secret
+	Production bool
+	// Document is the secret decoded as a JSON object, kept only for --merge-json
+	Document map[string]interface{}
+	// Metadata is what the backend recorded about the fetch, if it records any
+	Metadata SecretMetadata
+	// Value is the secret as fetched, before it was expanded
+	Value *SecretValue
+}
+
+// sourceOf returns the backend label reported by a SecretManager
+func sourceOf(sm SecretManager) string {
+	if namer, ok := sm.(SourceNamer); ok {
+		return namer.Source()
+	}
+	return "unknown"
+}
+
+// loadSecret fetches a secret and expands it into env var key-value pairs
+func (app *Application) loadSecret(opts *Options, spec *SecretSpec) (*loadedSecret, error) {
+	sm, err := app.secretManager(spec.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	source := spec.Source
+	if source == "" {
+		source = SourceSecretsManager
+	}
+	_, versioned := sm.(VersionedSecretManager)
+	if _, ok := sm.(SecretFetcher); ok {
+		versioned = true
+	}
+	if opts.CacheDir != "" {
+		sm = &CachingSecretManager{
+			Inner:      sm,
+			Backend:    source,
+			Dir:        opts.CacheDir,
+			TTL:        opts.CacheTTL,
+			Passphrase: os.Getenv(cachePassphraseEnv),
+			Logger:     app.Logger,
+			Now:        app.Now,
+		}
+	}
+	app.Logger.Log("info", "Fetching secret from "+sourceNames[source], map[string]string{"secretName": spec.Name})
+
+	if spec.Version != (SecretVersion{}) && !versioned {
+		return nil, fmt.Errorf("secret %s: %s does not support version selection", spec.Name, sourceNames[source])
+	}
+	value, err := fetchSecret(sm, spec.Name, spec.Version)
+	if err != nil && opts.FallbackStage != "" && isNotFound(err) && (spec.Version == SecretVersion{} || spec.Version.Stage == "AWSCURRENT") && versioned {
+		// During rotation the current version may be missing while the fallback still resolves
+		app.Logger.Log("warn", "Current secret version not found, fetching fallback stage", map[string]string{
+			"secretName": spec.Name,
+			"stage":      opts.FallbackStage,
+			"error":      err.Error(),
+		})
+		value, err = fetchSecret(sm, spec.Name, SecretVersion{Stage: opts.FallbackStage})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s: %w", spec.Name, err)
+	}
+	secretString := value.String
+	size := len(secretString)
+
+	if expected, ok := opts.ExpectedHashes[spec.Name]; ok {
+		if err := verifySecretHash(secretString, expected); err != nil {
+			return nil, fmt.Errorf("secret %s failed integrity check: %w", spec.Name, err)
+		}
+		app.Logger.Log("info", "Verified secret hash", map[string]string{"secretName": spec.Name})
+	}
+
+	if schemaPath, ok := opts.Schemas[spec.Name]; ok {
+		if err := validateSecretSchema(secretString, schemaPath); err != nil {
+			return nil, fmt.Errorf("secret %s failed schema validation: %w", spec.Name, err)
+		}
+	}
+
+	if spec.Index != nil {
+		secretString, err = selectArrayElement(secretString, spec.Index)
+		if err != nil {
+			return nil, fmt.Errorf("failed to select from secret %s: %w", spec.Name, err)
+		}
+	}
+
+	var document map[string]interface{}
+	if opts.MergeJSON != "" {
+		doc, err := decodeJSON(secretString)
+		obj, ok := doc.(map[string]interface{})
+		if err != nil || !ok {
+			return nil, fmt.Errorf("secret %s is not a JSON object and cannot be merged into --merge-json", spec.Name)
+		}
+		document = obj
+	}
+
+	var secretMap map[string]string
+	if len(spec.Extracts) > 0 {
+		secretMap, err = extractSecret(secretString, spec.Extracts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract from secret %s: %w", spec.Name, err)
+		}
+	} else if spec.Select != "" {
+		value, err := selectSecretField(secretString, spec.Select)
+		if err != nil {
+			return nil, fmt.Errorf("failed to select from secret %s: %w", spec.Name, err)
+		}
+		name := spec.As
+		if name == "" {
+			name = spec.Select[strings.LastIndex(spec.Select, ".")+1:]
+		}
+		secretMap = map[string]string{name: value}
+	} else {
+		name := spec.As
+		if name == "" {
+			name = DefaultArrayName
+		}
+		var isArray bool
+		secretMap, isArray, err = expandArrayRoot(secretString, opts.ArrayRoot, name, opts.JoinSep)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand JSON array secret %s: %w", spec.Name, err)
+		}
+		if !isArray && spec.As != "" {
+			return nil, fmt.Errorf("secret %s is not a JSON array; --as without --select names the elements of an array secret", spec.Name)
+		}
+		flattened := false
+		if !isArray && opts.Flatten {
+			secretMap, flattened = flattenSecret(secretString, opts.FlattenSep)
+		}
+		if !isArray && !flattened {
+			secretMap, err = parseSecretJSON(secretString)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse secret as JSON: %w", err)
+			}
+		}
+	}
+
+	if len(secretMap) == 0 {
+		// An empty JSON object, unlike a non-JSON value, injects nothing at all
+		if opts.FailOnEmpty {
+			return nil, fmt.Errorf("secret %s expands to no env vars", spec.Name)
+		}
+		app.Logger.Log("warn", "Secret expands to no env vars", map[string]string{"secretName": spec.Name})
+	}
+
+	for from, to := range spec.Renames {
+		v, ok := secretMap[from]
+		if !ok {
+			return nil, fmt.Errorf("secret %s has no key %s to rename", spec.Name, from)
+		}
+		delete(secretMap, from)
+		secretMap[to] = v
+	}
+
+	if len(spec.Include) > 0 || len(spec.Exclude) > 0 {
+		secretMap = filterKeys(secretMap, spec.Include, spec.Exclude)
+	}
+
+	if opts.NormalizeKeys {
+		normalized, collisions := normalizeEnvKeys(secretMap)
+		for _, c := range collisions {
+			app.Logger.Log("warn", "Secret keys collide after normalization", map[string]string{
+				"secretName": spec.Name,
+				"key":        c.Key,
+				"kept":       c.Kept,
+				"dropped":    c.Dropped,
+			})
+		}
+		secretMap = normalized
+	}
+
+	if spec.Prefix != "" {
+		prefixed := make(map[string]string, len(secretMap))
+		for k, v := range secretMap {
+			prefixed[spec.Prefix+k] = v
+		}
+		secretMap = prefixed
+	}
+
+	if spec.InjectDate != "" {
+		meta, ok := SecretMetadata{}, false
+		if provider, isProvider := sm.(SecretMetadataProvider); isProvider {
+			meta, ok = provider.SecretMetadata(spec.Name)
+		}
+		if !ok || meta.CreatedDate.IsZero() {
+			return nil, fmt.Errorf("secret %s does not provide a creation date", spec.Name)
+		}
+		secretMap[spec.InjectDate] = meta.CreatedDate.UTC().Format(time.RFC3339)
+	}
+
+	loaded := &loadedSecret{Spec: spec, Source: value.Source, Values: secretMap, Size: size, Document: document, Value: value}
+	if provider, ok := sm.(SecretMetadataProvider); ok {
+		loaded.Metadata, _ = provider.SecretMetadata(spec.Name)
+	}
+	if provider, ok := sm.(SecretTagProvider); ok {
+		tags, err := provider.SecretTags(spec.Name)
+		if err != nil {
+			app.Logger.Log("warn", "Failed to read secret tags", map[string]string{
+				"secretName": spec.Name,
+				"error":      err.Error(),
+			})
+		}
+		loaded.Production = isProductionTagged(tags)
+	}
+
+	return loaded, nil
+}
+
+// CommandInvocation is logged by --print-command: what is about to be executed,
+// with the names of the env vars but never their values
+type CommandInvocation struct {
+	CommandPath string `json:"commandPath"`
+	// ResolvedPath is the executable the command path resolves to, as far as it can be determined
+	ResolvedPath string   `json:"resolvedPath"`
+	Args         []string `json:"args"`
+	EnvNames     []string `json:"envNames"`
+}
+
+// commandInvocation describes the invocation of commandPath for --print-command
+func (app *Application) commandInvocation(commandPath string, args []string, env []string) CommandInvocation {
+	resolved := commandPath
+	if runner, ok := app.CommandRunner.(*DefaultCommandRunner); ok {
+		// A resolution error is left for the runner to report
+		if path, err := runner.resolveCommand(commandPath, env); err == nil {
+			resolved = path
+		}
+	}
+
+	names := make([]string, 0, len(env))
+	for _, entry := range env {
+		key, _, _ := strings.Cut(entry, "=")
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	return CommandInvocation{CommandPath: commandPath, ResolvedPath: resolved, Args: args, EnvNames: names}
+}
+
+// logDryRun logs the command and the environment it would run with
+func (app *Application) logDryRun(opts *Options, commandPath string, args []string, env []string) {
+	data := map[string]interface{}{
+		"commandPath": commandPath,
+		"args":        args,
+	}
+	if opts.ShowValues {
+		data["env"] = environMap(env)
+	} else {
+		keys := make([]string, 0, len(env))
+		for _, entry := range env {
+			key, _, _ := strings.Cut(entry, "=")
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		data["env"] = keys
+	}
+	app.Logger.Log("info", "Dry run: resolved environment", data)
+}
+
+// confirmProduction asks the user to confirm a run that uses production secrets
+func (app *Application) confirmProduction(commandPath string, secrets []string) error {
+	app.Logger.Log("info", "Production secrets detected", map[string]interface{}{"secretNames": secrets})
+
+	if app.Prompter == nil {
+		return fmt.Errorf("production secrets %s require confirmation; pass --yes to proceed", strings.Join(secrets, ", "))
+	}
+	// Emit buffered logs so they precede the prompt
+	if flusher, ok := app.Logger.(Flusher); ok {
+		flusher.Flush()
+	}
+	ok, err := app.Prompter.Confirm(fmt.Sprintf("Run %s with production secrets %s?", commandPath, strings.Join(secrets, ", ")))
+	if err != nil {
+		return fmt.Errorf("production secrets %s require confirmation; pass --yes to proceed: %w", strings.Join(secrets, ", "), err)
+	}
+	if !ok {
+		return fmt.Errorf("aborted: run with production secrets was not confirmed")
+	}
+	return nil
+}
+
+// bindContext binds the requests of every secret manager to ctx
+func (app *Application) bindContext(ctx context.Context) {
+	if setter, ok := app.SecretManager.(ContextSetter); ok {
+		setter.SetContext(ctx)
+	}
+	for _, sm := range app.Backends {
+		if setter, ok := sm.(ContextSetter); ok {
+			setter.SetContext(ctx)
+		}
+	}
+}
+
+// configureSecretManager applies the parsed options to the AWS and Azure secret managers
+func (app *Application) configureSecretManager(opts *Options) {
+	if az, ok := app.Backends[SourceAzure].(*AzureSecretManager); ok && opts.AzureVaultURL != "" {
+		az.VaultURL = opts.AzureVaultURL
+	}
+
+	sm, ok := app.SecretManager.(*AWSSecretManager)
+	if !ok {
+		return
+	}
+	sm.Logger = app.Logger
+	if opts.Region != "" {
+		WithRegion(opts.Region)(sm)
+	}
+	WithRetries(opts.MaxRetries, opts.RetryBaseDelay)(sm)
+	WithBinaryMode(opts.BinaryMode)(sm)
+	if opts.AssumeRoleARN != "" {
+		WithAssumeRole(opts.AssumeRoleARN, opts.ExternalID)(sm)
+	}
+	if opts.AWSConfigFile != "" {
+		WithSharedConfigFiles(opts.AWSConfigFile)(sm)
+	}
+	if opts.AWSCredentialsFile != "" {
+		WithSharedCredentialsFiles(opts.AWSCredentialsFile)(sm)
+	}
+	if opts.NoSharedConfig {
+		WithoutSharedConfig()(sm)
+	}
+	if opts.Profile != "" {
+		WithProfile(opts.Profile)(sm)
+	}
+	if opts.EndpointURL != "" {
+		WithEndpointURL(opts.EndpointURL)(sm)
+	}
+}
+
+// configureEncryptionContext passes --encryption-context to the secret managers of
+// the requested sources that accept one
+func (app *Application) configureEncryptionContext(opts *Options) {
+	if len(opts.EncryptionContext) == 0 {
+		return
+	}
+	seen := map[string]bool{}
+	for _, spec := range opts.Secrets {
+		source := spec.Source
+		if source == "" {
+			source = SourceSecretsManager
+		}
+		if seen[source] {
+			continue
+		}
+		seen[source] = true
+
+		sm, err := app.secretManager(source)
+		if err != nil {
+			// Reported when the secret is fetched
+			continue
+		}
+		if setter, ok := sm.(EncryptionContextSetter); ok {
+			setter.SetEncryptionContext(opts.EncryptionContext)
+			continue
+		}
+		app.Logger.Log("debug", "Secret backend ignores encryption context", map[string]string{"source": source})
+	}
+}
+
+// configureRunner applies the parsed options to the default command runner
+func (app *Application) configureRunner(opts *Options) {
+	runner, ok := app.CommandRunner.(*DefaultCommandRunner)
+	if !ok {
+		return
+	}
+	runner.Logger = app.Logger
+	runner.Chroot = opts.Chroot
+	runner.Dir = opts.Workdir
+	runner.Argv0 = opts.Argv0
+	runner.Detach = opts.Detach
+	runner.DetachOutput = opts.DetachOutput
+	runner.PIDFile = opts.PIDFile
+	runner.CaptureOutput = opts.CaptureOutput
+	runner.OutputLimit = opts.OutputLimit
+	runner.OutputJSON = opts.OutputJSON
+	runner.FailIfEmptyStdout = opts.FailIfEmptyStdout
+	runner.KillTimeout = opts.KillTimeout
+	runner.NoInheritPath = opts.NoInheritPath
+	runner.Context = app.ctx
+}
+
+// context returns the context of the current run
+func (app *Application) context() context.Context {
+	if app.ctx != nil {
+		return app.ctx
+	}
+	return context.Background()
+}
+
+// now returns the current time of the application's clock
+func (app *Application) now() time.Time {
+	if app.Now != nil {
+		return app.Now()
+	}
+	return time.Now()
+}
+
+// New creates an Application with default implementations that runs with opts instead of parsing argv
+func New(opts *Options) *Application {
+	app := NewApplication(nil)
+	app.Options = opts
+	return app
+}
+
+// options returns the validated Options, or parses them from Args
+func (app *Application) options() (*Options, error) {
+	if app.Options == nil {
+		return parseArgs(app.Args)
+	}
+	if err := app.Options.Validate(); err != nil {
+		return nil, err
+	}
+	return app.Options, nil
+}
+
+// Prepared is a command and the environment assembled for it by Prepare
+type Prepared struct {
+	CommandPath string
+	Args        []string
+	Env         []string
+
+	cleaner Cleaner
+	files   []string
+	// secrets holds the injected secret values, for masking them
+	secrets []string
+}
+
+// Cleanup removes the files written for the command, such as binary secrets
+// with --binary-mode file; call it once the command has exited
+func (p *Prepared) Cleanup() error {
+	var errs []error
+	if p.cleaner != nil {
+		errs = append(errs, p.cleaner.Cleanup())
+	}
+	for _, name := range p.files {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Prepare fetches the secrets and assembles the command's environment without
+// running it, so the caller can start the command itself
+func (app *Application) Prepare() (*Prepared, error) {
+	opts, err := app.options()
+	if err != nil {
+		return nil, err
+	}
+	if opts.DumpArgs || opts.PrintKeys || opts.Check || opts.Export || opts.DryRun || opts.EnvFile != "" {
+		return nil, fmt.Errorf("Prepare does not support dump-args, print-keys, check, export, dry-run or write-env-file")
+	}
+
+	prepared := &Prepared{}
+	if err := app.run(opts, prepared); err != nil {
+		return nil, err
+	}
+	return prepared, nil
+}
+
+// Run executes the command with arguments and environment variables
+func (app *Application) Run() error {
+	return app.RunContext(context.Background())
+}
+
+// RunContext is Run bound to ctx: cancelling it aborts fetching secrets, or
+// terminates the running command with SIGTERM, followed by SIGKILL after
+// --kill-timeout, and makes RunContext return an error wrapping context.Canceled
+func (app *Application) RunContext(ctx context.Context) error {
+	app.ctx = ctx
+	defer func() { app.ctx = nil }()
+
+	opts, err := app.options()
+	if err != nil {
+		return err
+	}
+	return app.run(opts, nil)
+}
+
+// run carries out a run; with prepared set it stops before the command would
+// start and records the command and its environment in prepared instead
+func (app *Application) run(opts *Options, prepared *Prepared) (err error) {
+	timer := newPhaseTimer(app.Now)
+
+	if opts.DumpArgs {
+		return dumpArgs(os.Stdout, opts)
+	}
+
+	if opts.LogFormat == LogFormatText {
+		if jl, ok := app.Logger.(*JSONLogger); ok {
+			defer func(logger Logger) { app.Logger = logger }(app.Logger)
+			tl := NewTextLogger()
+			tl.Output = jl.Output
+			app.Logger = tl
+		}
+	}
+
+	if opts.LogOutput != "" {
+		if out := logOutputOf(app.Logger); out != nil {
+			output, closeOutput, err := openLogOutput(opts.LogOutput)
+			if err != nil {
+				return err
+			}
+			defer closeOutput()
+			defer func(w io.Writer) { *out = w }(*out)
+			*out = output
+		}
+	}
+
+	// Buffered output is flushed however the run ends
+	if opts.BufferedLogs {
+		switch l := app.Logger.(type) {
+		case *JSONLogger:
+			l.EnableBuffering()
+		case *TextLogger:
+			l.EnableBuffering()
+		}
+	}
+	switch l := app.Logger.(type) {
+	case *JSONLogger:
+		l.Level = opts.LogLevel
+		l.UpperCaseLevel = opts.LogLevelCase == LogLevelCaseUpper
+		if opts.DisableHTMLEscape {
+			l.DisableHTMLEscape = true
+		}
+	case *TextLogger:
+		l.Level = opts.LogLevel
+	}
+	if flusher, ok := app.Logger.(Flusher); ok {
+		defer flusher.Flush()
+	}
+
+	// Loggers wrapped for this run are unwound when it finishes
+	defer func(logger Logger) { app.Logger = logger }(app.Logger)
+	var redactor *RedactingLogger
+	if opts.RedactLogs {
+		redactor = NewRedactingLogger(app.Logger, nil)
+		redactor.Mask = opts.Mask
+		app.Logger = redactor
+	}
+	if opts.LogSample > 1 {
+		app.Logger = NewSamplingLogger(app.Logger, opts.LogSample)
+	}
+	var warnings *WarningCounter
+	if opts.AbortOnWarning {
+		warnings = NewWarningCounter(app.Logger)
+		app.Logger = warnings
+	}
+	app.configureSecretManager(opts)
+	app.configureEncryptionContext(opts)
+	app.configureRunner(opts)
+
+	if opts.Workdir != "" {
+		// Under --chroot the directory is resolved inside the new root
+		dir := filepath.Join(opts.Chroot, opts.Workdir)
+		if info, err := os.Stat(dir); err != nil {
+			return fmt.Errorf("invalid --workdir: %w", err)
+		} else if !info.IsDir() {
+			return fmt.Errorf("invalid --workdir: %s is not a directory", opts.Workdir)
+		}
+	}
+
+	if opts.CacheDir != "" && os.Getenv(cachePassphraseEnv) == "" {
+		return fmt.Errorf("--cache-dir requires $%s to be set", cachePassphraseEnv)
+	}
+
+	var stdinFile *os.File
+	if opts.StdinFile != "" {
+		f, err := os.Open(opts.StdinFile)
+		if err != nil {
+			return fmt.Errorf("failed to open --stdin-from-file: %w", err)
+		}
+		defer f.Close()
+		stdinFile = f
+		if runner, ok := app.CommandRunner.(*DefaultCommandRunner); ok {
+			defer func(r io.Reader) { runner.Stdin = r }(runner.Stdin)
+			runner.Stdin = f
+		}
+	}
+
+	// Files written for the command are removed once it exits; a detached command still needs them,
+	// and a prepared command leaves that to the caller
+	if cleaner, ok := app.SecretManager.(Cleaner); ok && !opts.Detach {
+		defer func() {
+			if prepared != nil && err == nil {
+				prepared.cleaner = cleaner
+				return
+			}
+			if err := cleaner.Cleanup(); err != nil {
+				app.Logger.Log("warn", "Failed to remove secret files", map[string]string{"error": err.Error()})
+			}
+		}()
+	}
+
+	commandPath := opts.CommandPath
+	args := opts.Args
+	envVars := map[string]string{}
+
+	// Resolve templated and referenced secret names against the current environment,
+	// into copies of the specs so that Options passed to New are left as they were
+	resolved := *opts
+	resolved.Secrets = make([]*SecretSpec, len(opts.Secrets))
+	for i, spec := range opts.Secrets {
+		copied := *spec
+		resolved.Secrets[i] = &copied
+	}
+	opts = &resolved
+	for _, spec := range opts.Secrets {
+		if spec.NameEnv != "" {
+			name := os.Getenv(spec.NameEnv)
+			if name == "" {
+				return fmt.Errorf("--key @%s: environment variable %s is not set", spec.NameEnv, spec.NameEnv)
+			}
+			spec.Name = name
+			continue
+		}
+		if spec.NameTemplate == "" {
+			continue
+		}
+		name, err := renderTemplate(spec.NameTemplate, environMap(os.Environ()))
+		if err != nil {
+			return fmt.Errorf("failed to resolve secret name: %w", err)
+		}
+		spec.Name = name
+	}
+
+	if opts.Check {
+		return app.checkAccess(os.Stdout, opts)
+	}
+
+	// Refuse checks for secrets that are never fetched, as a typo would skip the check
+	for name := range opts.ExpectedHashes {
+		if !opts.requested(name) {
+			return fmt.Errorf("--expect-hash given for secret %s which is not requested", name)
+		}
+	}
+	for name := range opts.Schemas {
+		if !opts.requested(name) {
+			return fmt.Errorf("--schema given for secret %s which is not requested", name)
+		}
+	}
+
+	// Serialize runs sharing a lock file from the fetch until the command exits. The
+	// lock is taken before the --timeout deadline starts, so waiting for it does not
+	// count against the fetch.
+	if opts.LockFile != "" {
+		timer.End(PhaseSetup)
+		lock, err := acquireLock(app.context(), opts.LockFile, opts.LockNonblocking, app.Logger)
+		if err != nil {
+			return err
+		}
+		timer.End(PhaseLockWait)
+		defer func() {
+			if err := lock.Release(); err != nil {
+				app.Logger.Log("warn", "Failed to release lock file", map[string]string{"error": err.Error()})
+			}
+		}()
+	}
+
+	fetched := 0
+	var production []string
+	var report []FetchReportEntry
+	var audit []AuditEntry
+	var scrub []string
+	var values []string
+	merged := map[string]interface{}{}
+	// owners records which secret supplied the final value of each key
+	owners := map[string]string{}
+	// Bound the whole fetch phase by a single deadline
+	fetchCtx := app.context()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		fetchCtx, cancel = context.WithTimeout(fetchCtx, opts.Timeout)
+		defer cancel()
+	}
+	if fetchCtx.Done() != nil {
+		app.bindContext(fetchCtx)
+		defer app.bindContext(context.Background())
+	}
+
+	timer.End(PhaseSetup)
+	// Load the AWS config up front so config and credential resolution is timed apart from the fetches
+	if provider, ok := app.SecretManager.(AWSConfigProvider); ok && usesAWSConfig(opts) {
+		// A failure is reported by each fetch that needs the config
+		provider.LoadConfig()
+	}
+	timer.End(PhaseConfigLoad)
+	var metrics FetchMetrics
+	if opts.MetricsFile != "" {
+		counter, _ := app.SecretManager.(RetryCounter)
+		retriesBefore := 0
+		if counter != nil {
+			retriesBefore = counter.Retries()
+		}
+		// Written once the run ends, whether or not it succeeded
+		defer func() {
+			if counter != nil {
+				metrics.Retries = counter.Retries() - retriesBefore
+			}
+			if err := writeMetrics(opts.MetricsFile, metrics); err != nil {
+				app.Logger.Log("warn", "Failed to write metrics file", map[string]string{"error": err.Error()})
+			}
+		}()
+	}
+	// Several Secrets Manager secrets are fetched in batches up front; with --cache-dir
+	// the cache is consulted per secret instead
+	if prefetcher, ok := app.SecretManager.(SecretPrefetcher); ok && opts.CacheDir == "" {
+		if names := batchableSecrets(opts); len(names) > 1 {
+			begin := time.Now()
+			prefetcher.Prefetch(names)
+			metrics.Latency += time.Since(begin)
+		}
+	}
+	for _, spec := range opts.Secrets {
+		begin := time.Now()
+		secret, err := app.loadSecret(opts, spec)
+		metrics.Latency += time.Since(begin)
+		if err != nil {
+			metrics.Errors++
+			if errors.Is(fetchCtx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("timed out fetching secret %s: secret fetch exceeded --timeout %s: %w", spec.Name, opts.Timeout, err)
+			}
+			return err
+		}
+		fetched++
+		metrics.Fetched++
+		report = append(report, FetchReportEntry{
+			Name:   spec.Name,
+			Source: secret.Source,
+			Keys:   len(secret.Values),
+			Bytes:  secret.Size,
+		})
+		if redactor != nil {
+			for _, v := range secret.Values {
+				redactor.AddSecrets(v)
+			}
+		}
+		for _, v := range secret.Values {
+			values = append(values, v)
+		}
+		if opts.ScrubOutput {
+			for _, v := range secret.Values {
+				scrub = append(scrub, v)
+			}
+		}
+		if secret.Production {
+			production = append(production, spec.Name)
+		}
+		if secret.Document != nil {
+			// Later secrets override earlier ones on conflicting keys
+			deepMergeJSON(merged, secret.Document)
+		}
+
+		// Add all key-value pairs from the secret to environment variables
+		secretKeys := make([]string, 0, len(secret.Values))
+		for k := range secret.Values {
+			secretKeys = append(secretKeys, k)
+		}
+		sort.Strings(secretKeys)
+		for _, k := range secretKeys {
+			if owner, ok := owners[k]; ok && owner != spec.Name {
+				switch opts.OnConflict {
+				case ConflictError:
+					return fmt.Errorf("env var %s is defined by both secret %s and secret %s; use --on-conflict last or first to pick one", k, owner, spec.Name)
+				case ConflictFirst:
+					continue
+				}
+			}
+			envVars[k] = secret.Values[k]
+			owners[k] = spec.Name
+		}
+		// Keys are filled in from the final environment once it is assembled
+		audit = append(audit, AuditEntry{Name: spec.Name, Source: secret.Source})
+		retrieved := map[string]interface{}{
+			"keys":   secretKeys,
+			"source": secret.Source,
+		}
+		if secret.Metadata.RequestID != "" {
+			retrieved["requestId"] = secret.Metadata.RequestID
+			retrieved["latencyMs"] = secret.Metadata.LatencyMs
+		}
+		if secret.Value.VersionID != "" {
+			retrieved["versionId"] = secret.Value.VersionID
+			retrieved["stages"] = secret.Value.Stages
+		}
+		if secret.Value.Binary != nil {
+			retrieved["binary"] = true
+		}
+		app.Logger.Log("info", "Retrieved secret keys", retrieved)
+	}
+
+	if opts.CommandFromSecret != "" {
+		commandPath, args, err = app.loadCommand(opts.CommandFromSecret)
+		if err != nil {
+			return err
+		}
+		app.Logger.Log("info", "Loaded command from secret", map[string]string{
+			"secretName":  opts.CommandFromSecret,
+			"commandPath": commandPath,
+		})
+	}
+
+	timer.End(PhaseFetch)
+
+	if opts.PrintKeys {
+		return printKeys(os.Stdout, envVars)
+	}
+
+	if opts.FetchReport != "" {
+		if err := writeFetchReport(opts.FetchReport, report); err != nil {
+			return err
+		}
+	}
+
+	if opts.RequireSecretCount >= 0 && fetched != opts.RequireSecretCount {
+		return fmt.Errorf("fetched %d secrets, but --require-secret-count expects %d", fetched, opts.RequireSecretCount)
+	}
+
+	if err := applyJoins(envVars, opts.Joins); err != nil {
+		return err
+	}
+
+	inherited := os.Environ()
+	if opts.CleanEnv {
+		// Unless PATH is passed, the command path is still resolved with our own PATH
+		inherited = passEnv(inherited, opts.PassEnv)
+	}
+	if err := applySets(envVars, inherited, opts.Sets); err != nil {
+		return err
+	}
+
+	var valueFiles []string
+	// Like binary secret files, these are removed once the command exits unless it is detached
+	if !opts.Detach {
+		defer func() {
+			if prepared != nil && err == nil {
+				prepared.files = valueFiles
+				return
+			}
+			for _, name := range valueFiles {
+				if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+					app.Logger.Log("warn", "Failed to remove secret files", map[string]string{"error": err.Error()})
+				}
+			}
+		}()
+	}
+	if opts.FileThreshold > 0 {
+		moved, files, err := routeLargeValues(envVars, opts.FileThreshold, opts.FileSuffix)
+		valueFiles = append(valueFiles, files...)
+		if err != nil {
+			return err
+		}
+		for _, k := range moved {
+			if owner, ok := owners[k]; ok {
+				delete(owners, k)
+				owners[k+opts.FileSuffix] = owner
+			}
+			app.Logger.Log("info", "Wrote large value to file", map[string]string{
+				"key":  k + opts.FileSuffix,
+				"path": envVars[k+opts.FileSuffix],
+			})
+		}
+	}
+	nulFiles, err := routeNulValues(envVars, opts.OnNulByte)
+	valueFiles = append(valueFiles, nulFiles...)
+	if err != nil {
+		return err
+	}
+	for _, name := range nulFiles {
+		app.Logger.Log("info", "Wrote value containing NUL bytes to file", map[string]string{"path": name})
+	}
+
+	// Add or override environment variables from the parent process with secrets
+	env, counts := assembleEnv(inherited, envVars)
+	app.Logger.Log("info", "Assembled environment", counts)
+
+	if len(opts.EnvAllow) > 0 {
+		filtered, err := filterEnv(env, opts.EnvAllow)
+		if err != nil {
+			return fmt.Errorf("failed to apply --child-env-allow: %w", err)
+		}
+		app.Logger.Log("info", "Filtered environment by allowlist", map[string]int{
+			"kept":    len(filtered),
+			"dropped": len(env) - len(filtered),
+		})
+		env = filtered
+	}
+
+	audit = auditKeys(audit, owners, env, opts.Sets)
+
+	if opts.Verbose {
+		for _, source := range envKeySources(env, inherited, envVars, owners, opts.Sets) {
+			app.Logger.Log("debug", "Setting env var", source)
+		}
+	}
+
+	if missing := missingKeys(env, opts.RequiredKeys); len(missing) > 0 {
+		return fmt.Errorf("required env vars missing: %s", strings.Join(missing, ", "))
+	}
+
+	if opts.WarnUnusedSecrets {
+		names := make([]string, 0, len(opts.Secrets))
+		for _, spec := range opts.Secrets {
+			names = append(names, spec.Name)
+		}
+		if unused := unusedSecrets(names, owners, env); len(unused) > 0 {
+			app.Logger.Log("warn", "Fetched secrets have no keys in the command environment", map[string]interface{}{
+				"secrets": unused,
+			})
+		}
+	}
+
+	if warnings != nil && warnings.Count() > 0 {
+		return fmt.Errorf("aborting due to %d warning(s) with --abort-on-warning", warnings.Count())
+	}
+
+	if opts.EnvFile != "" {
+		if err := writeEnvFile(opts.EnvFile, envVars); err != nil {
+			return err
+		}
+		app.Logger.Log("info", "Wrote env file", map[string]interface{}{
+			"path":  opts.EnvFile,
+			"count": len(envVars),
+		})
+		return nil
+	}
+
+	if opts.Export {
+		out, err := formatExport(envVars)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(os.Stdout, out)
+		return err
+	}
+
+	if opts.DryRun {
+		app.logDryRun(opts, commandPath, args, env)
+		return nil
+	}
+
+	if len(production) > 0 && !opts.AssumeYes {
+		if err := app.confirmProduction(commandPath, production); err != nil {
+			return err
+		}
+	}
+
+	if opts.MergeJSON != "" {
+		if err := writeMergedJSON(opts.MergeJSON, merged); err != nil {
+			return err
+		}
+		app.Logger.Log("info", "Wrote merged JSON", map[string]interface{}{
+			"path":    opts.MergeJSON,
+			"secrets": fetched,
+		})
+	}
+
+	if opts.SystemdCreds {
+		dir := os.Getenv(credentialsDirEnv)
+		if dir == "" {
+			return fmt.Errorf("--systemd-creds requires $%s to be set", credentialsDirEnv)
+		}
+		if err := writeCredentials(dir, envVars); err != nil {
+			return err
+		}
+		app.Logger.Log("info", "Wrote systemd credentials", map[string]interface{}{
+			"directory": dir,
+			"count":     len(envVars),
+		})
+	}
+
+	if prepared != nil {
+		timer.End(PhaseEnvAssembly)
+		prepared.CommandPath = commandPath
+		prepared.Args = args
+		prepared.Env = env
+		prepared.secrets = values
+		app.Logger.Log("info", "Prepared command environment", map[string]interface{}{
+			"commandPath": commandPath,
+			"durationsMs": timer.Milliseconds(),
+		})
+		return nil
+	}
+
+	if opts.PrintCommand {
+		app.Logger.Log("info", "Command invocation", app.commandInvocation(commandPath, args, env))
+	}
+	app.Logger.Log("info", "Executing command", map[string]interface{}{
+		"commandPath": commandPath,
+		"args":        args,
+	})
+	// Emit buffered logs before the command starts writing to the same streams
+	if flusher, ok := app.Logger.(Flusher); ok {
+		flusher.Flush()
+	}
+
+	timer.End(PhaseEnvAssembly)
+	if runner, ok := app.CommandRunner.(*DefaultCommandRunner); ok && opts.ScrubOutput {
+		runner.ScrubSecrets = scrub
+		runner.ScrubMask = opts.Mask
+	}
+	retry := commandRetry{
+		retries:  opts.CommandRetries,
+		delay:    opts.CommandRetryDelay,
+		maxTotal: opts.MaxTotalRuntime,
+		now:      app.now,
+		jitter:   opts.CommandRetryJitter,
+		sleep:    time.Sleep,
+	}
+	if app.Sleep != nil {
+		retry.sleep = app.Sleep
+	}
+	if opts.CommandRetryJitter > 0 {
+		rng := app.Rand
+		if rng == nil {
+			rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+		retry.random = rng.Float64
+	}
+	// Every attempt reads --stdin-from-file from the start
+	if stdinFile != nil {
+		retry.before = func() error {
+			_, err := stdinFile.Seek(0, io.SeekStart)
+			return err
+		}
+	}
+	if opts.RefetchOnRestart {
+		var refetched []*Prepared
+		defer func() {
+			for _, p := range refetched {
+				if err := p.Cleanup(); err != nil {
+					app.Logger.Log("warn", "Failed to remove secret files", map[string]string{"error": err.Error()})
+				}
+			}
+		}()
+		before := retry.before
+		retry.before = func() error {
+			if before != nil {
+				if err := before(); err != nil {
+					return err
+				}
+			}
+			app.Logger.Log("info", "Fetching secrets again before restarting the command", nil)
+			p, err := app.refetch(opts)
+			if err != nil {
+				return fmt.Errorf("failed to fetch secrets again before restarting the command: %w", err)
+			}
+			refetched = append(refetched, p)
+			commandPath, args, env = p.CommandPath, p.Args, p.Env
+			if redactor != nil {
+				redactor.AddSecrets(p.secrets...)
+			}
+			if runner, ok := app.CommandRunner.(*DefaultCommandRunner); ok && opts.ScrubOutput {
+				runner.ScrubSecrets = append(runner.ScrubSecrets, p.secrets...)
+			}
+			return nil
+		}
+	}
+	err = retry.run(app.Logger, func() error {
+		return app.CommandRunner.Run(commandPath, args, env)
+	})
+	timer.End(PhaseCommand)
+	if sig, ok := terminatingSignal(err); ok && sig == syscall.SIGTERM && opts.SigtermExit >= 0 {
+		app.Logger.Log("info", "Command terminated by SIGTERM", map[string]int{"exitCode": opts.SigtermExit})
+		if opts.SigtermExit == 0 {
+			return nil
+		}
+		return &ExitCodeError{Code: opts.SigtermExit, Err: fmt.Errorf("Command execution error: %w", err)}
+	}
+	if err != nil {
+		app.Logger.Log("error", "Command execution failed", map[string]interface{}{
+			"error":       err.Error(),
+			"exitCode":    ExitCode(err),
+			"durationsMs": timer.Milliseconds(),
+		})
+		return fmt.Errorf("Command execution error: %w", err)
+	}
+
+	if opts.AuditFile != "" {
+		record := AuditRecord{Timestamp: app.now().UTC(), Command: commandPath, Secrets: audit}
+		if err := writeAuditRecord(opts.AuditFile, record); err != nil {
+			return err
+		}
+	}
+
+	if runner, ok := app.CommandRunner.(*DefaultCommandRunner); ok && runner.Detach {
+		app.Logger.Log("info", "Command started in background", map[string]int{"pid": runner.PID})
+		return nil
+	}
+
+	app.Logger.Log("info", "Command executed successfully", map[string]interface{}{
+		"durationsMs": timer.Milliseconds(),
+	})
+
+	if warnings != nil && warnings.Count() > 0 {
+		return fmt.Errorf("command emitted %d warning(s) with --abort-on-warning", warnings.Count())
+	}
+	return nil
+}
+
+// refetch fetches the secrets again and assembles a new environment for the
+// command, as Prepare does, for --refetch-on-restart
+func (app *Application) refetch(opts *Options) (*Prepared, error) {
+	again := *opts
+	// This run still holds the lock and has confirmed production use, a cached value
+	// may be the one the restart is meant to replace, and metrics cover the whole run
+	again.LockFile = ""
+	again.AssumeYes = true
+	again.CacheDir = ""
+	again.MetricsFile = ""
+	if runner, ok := app.CommandRunner.(*DefaultCommandRunner); ok {
+		defer func(logger Logger) { runner.Logger = logger }(runner.Logger)
+	}
+
+	prepared := &Prepared{}
+	if err := app.run(&again, prepared); err != nil {
+		return nil, err
+	}
+	return prepared, nil
+}
+
+// ExitCodeError carries an explicit exit code for the error it wraps
+type ExitCodeError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitCodeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitCodeError) Unwrap() error {
+	return e.Err
+}
+
+// terminatingSignal returns the signal that killed the command, if any
+func terminatingSignal(err error) (syscall.Signal, bool) {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return status.Signal(), true
+		}
+	}
+	return 0, false
+}
+
+// ExitCode returns the code to exit with for an error returned by Run
+func ExitCode(err error) int {
+	var codeErr *ExitCodeError
+	if errors.As(err, &codeErr) {
+		return codeErr.Code
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// Follow the shell convention of 128+N for a command killed by signal N
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		if code := exitErr.ExitCode(); code >= 0 {
+			return code
+		}
+	}
+	return 1
+}
@@ -0,0 +1,1940 @@
+package secrun
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/aws/smithy-go"
+)
+
+// モック実装
+// ============
+
+// MockLogger はLogger interfaceのモック実装
+type MockLogger struct {
+	Logs []struct {
+		Level   string
+		Message string
+		Data    interface{}
+	}
+}
+
+// Log はログを記録するだけでOutputには書き込まない
+func (l *MockLogger) Log(level, message string, data interface{}) {
+	l.Logs = append(l.Logs, struct {
+		Level   string
+		Message string
+		Data    interface{}
+	}{level, message, data})
+}
+
+// MockSecretManager はSecretManager interfaceのモック実装
+type MockSecretManager struct {
+	Secrets map[string]string
+	Calls   []string
+	Error   error
+}
+
+// GetSecret はモックされたシークレットを返す
+func (m *MockSecretManager) GetSecret(secretName string) (string, error) {
+	m.Calls = append(m.Calls, secretName)
+	if m.Error != nil {
+		return "", m.Error
+	}
+	if secret, ok := m.Secrets[secretName]; ok {
+		return secret, nil
+	}
+	return "", fmt.Errorf("secret not found: %s", secretName)
+}
+
+// MockCommandRunner はCommandRunner interfaceのモック実装
+type MockCommandRunner struct {
+	ExecutedCommands []struct {
+		Path string
+		Args []string
+		Env  []string
+	}
+	ReturnError error
+	// RunFunc, when set, is called to observe the command while it "runs"
+	RunFunc func(commandPath string, args []string, env []string) error
+}
+
+// Run はコマンド実行をモックする
+func (r *MockCommandRunner) Run(commandPath string, args []string, env []string) error {
+	r.ExecutedCommands = append(r.ExecutedCommands, struct {
+		Path string
+		Args []string
+		Env  []string
+	}{commandPath, args, env})
+	if r.RunFunc != nil {
+		return r.RunFunc(commandPath, args, env)
+	}
+	return r.ReturnError
+}
+
+// 既存のテスト
+// ===========
+
+func TestParseSecretJSON(t *testing.T) {
+	tests := []struct {
+		name         string
+		secretString string
+		want         map[string]string
+		wantErr      bool
+	}{
+		{
+			name:         "Valid JSON",
+			secretString: `{"key1":"value1","key2":"value2"}`,
+			want:         map[string]string{"key1": "value1", "key2": "value2"},
+			wantErr:      false,
+		},
+		{
+			name:         "Non-JSON string",
+			secretString: "just a string",
+			want:         map[string]string{"secret": "just a string"},
+			wantErr:      false,
+		},
+		{
+			name:         "Empty string",
+			secretString: "",
+			want:         map[string]string{"secret": ""},
+			wantErr:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSecretJSON(tt.secretString)
+
+			// エラー確認
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseSecretJSON() error = nil, wantErr %v", tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("parseSecretJSON() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			// 結果確認
+			if len(got) != len(tt.want) {
+				t.Errorf("parseSecretJSON() got = %v, want %v", got, tt.want)
+				return
+			}
+
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("parseSecretJSON() got[%s] = %v, want %v", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+// モックを使った追加のテスト
+// ===================
+
+func TestApplication_Run_WithMocks(t *testing.T) {
+	// モックの準備
+	mockLogger := &MockLogger{}
+	mockSecretManager := &MockSecretManager{
+		Secrets: map[string]string{
+			"db-creds": `{"DB_USER":"admin","DB_PASSWORD":"secure123"}`,
+		},
+	}
+	mockRunner := &MockCommandRunner{}
+
+	// テスト用アプリケーション
+	app := &Application{
+		Logger:        mockLogger,
+		SecretManager: mockSecretManager,
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "db-creds"},
+	}
+
+	// 実行
+	err := app.Run()
+
+	// 検証
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// シークレットマネージャーの呼び出し確認
+	if len(mockSecretManager.Calls) != 1 || mockSecretManager.Calls[0] != "db-creds" {
+		t.Errorf("Expected call to GetSecret with 'db-creds', got: %v", mockSecretManager.Calls)
+	}
+
+	// コマンド実行確認
+	if len(mockRunner.ExecutedCommands) != 1 {
+		t.Fatalf("Expected 1 command execution, got: %d", len(mockRunner.ExecutedCommands))
+	}
+
+	cmd := mockRunner.ExecutedCommands[0]
+	if cmd.Path != "/usr/bin/env" {
+		t.Errorf("Expected command '/usr/bin/env', got: '%s'", cmd.Path)
+	}
+
+	// 環境変数の確認
+	foundDBUser := false
+	foundDBPassword := false
+	for _, env := range cmd.Env {
+		if env == "DB_USER=admin" {
+			foundDBUser = true
+		}
+		if env == "DB_PASSWORD=secure123" {
+			foundDBPassword = true
+		}
+	}
+
+	if !foundDBUser {
+		t.Error("Expected DB_USER environment variable")
+	}
+	if !foundDBPassword {
+		t.Error("Expected DB_PASSWORD environment variable")
+	}
+
+	// ログの確認
+	if len(mockLogger.Logs) < 3 {
+		t.Fatalf("Expected at least 3 log entries, got: %d", len(mockLogger.Logs))
+	}
+
+	// シークレット取得ログ
+	fetchLogFound := false
+	for _, log := range mockLogger.Logs {
+		if log.Level == "info" && strings.Contains(log.Message, "Fetching secret") {
+			fetchLogFound = true
+			break
+		}
+	}
+	if !fetchLogFound {
+		t.Error("Expected log about fetching secret")
+	}
+
+	// 成功ログ
+	successLogFound := false
+	for _, log := range mockLogger.Logs {
+		if log.Level == "info" && strings.Contains(log.Message, "Command executed successfully") {
+			successLogFound = true
+			break
+		}
+	}
+	if !successLogFound {
+		t.Error("Expected log about successful execution")
+	}
+}
+
+func TestApplication_Run_EmptySecret(t *testing.T) {
+	tests := []struct {
+		name     string
+		secret   string
+		wantWarn bool
+		wantEnv  string
+	}{
+		{name: "empty object", secret: `{}`, wantWarn: true},
+		{name: "non-JSON value", secret: "just a string", wantEnv: "secret=just a string"},
+		{name: "populated object", secret: `{"DB_USER":"admin"}`, wantEnv: "DB_USER=admin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockLogger := &MockLogger{}
+			mockRunner := &MockCommandRunner{}
+			app := &Application{
+				Logger:        mockLogger,
+				SecretManager: &MockSecretManager{Secrets: map[string]string{"db": tt.secret}},
+				CommandRunner: mockRunner,
+				Args:          []string{"program", "/usr/bin/env", "--key", "db"},
+			}
+
+			// 既定では警告のみでコマンドは実行される
+			if err := app.Run(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			warned := false
+			for _, log := range mockLogger.Logs {
+				if log.Level == "warn" && log.Message == "Secret expands to no env vars" {
+					warned = true
+				}
+			}
+			if warned != tt.wantWarn {
+				t.Errorf("warned = %v, want %v", warned, tt.wantWarn)
+			}
+			if tt.wantEnv != "" && !strings.Contains(strings.Join(mockRunner.ExecutedCommands[0].Env, "\n"), tt.wantEnv) {
+				t.Errorf("Expected %s in env", tt.wantEnv)
+			}
+
+			// --fail-on-empty では空のシークレットだけがエラーになる
+			app.Args = append(app.Args, "--fail-on-empty")
+			err := app.Run()
+			if tt.wantWarn {
+				if err == nil || !strings.Contains(err.Error(), "secret db expands to no env vars") {
+					t.Errorf("Expected error naming the secret, got: %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("Unexpected error with --fail-on-empty: %v", err)
+			}
+		})
+	}
+}
+
+func TestApplication_Run_SecretManagerError(t *testing.T) {
+	// モックの準備
+	mockLogger := &MockLogger{}
+	mockSecretManager := &MockSecretManager{
+		Error: fmt.Errorf("connection error"),
+	}
+	mockRunner := &MockCommandRunner{}
+
+	// テスト用アプリケーション
+	app := &Application{
+		Logger:        mockLogger,
+		SecretManager: mockSecretManager,
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/bin/ls", "--key", "some-secret"},
+	}
+
+	// 実行
+	err := app.Run()
+
+	// エラーが発生することを確認
+	if err == nil {
+		t.Fatal("Expected error from SecretManager, got nil")
+	}
+
+	// エラーメッセージの確認
+	if !strings.Contains(err.Error(), "failed to get secret") {
+		t.Errorf("Expected error about failing to get secret, got: %v", err)
+	}
+
+	// シークレットマネージャーの呼び出し確認
+	if len(mockSecretManager.Calls) != 1 || mockSecretManager.Calls[0] != "some-secret" {
+		t.Errorf("Expected call to GetSecret with 'some-secret', got: %v", mockSecretManager.Calls)
+	}
+
+	// コマンドが実行されていないことを確認
+	if len(mockRunner.ExecutedCommands) > 0 {
+		t.Errorf("Expected no command execution, got: %d", len(mockRunner.ExecutedCommands))
+	}
+}
+
+func TestApplication_Run_MultipleSecrets(t *testing.T) {
+	// モックの準備
+	mockLogger := &MockLogger{}
+	mockSecretManager := &MockSecretManager{
+		Secrets: map[string]string{
+			"api-keys":  `{"API_KEY":"xyz123","API_SECRET":"abc456"}`,
+			"db-config": `{"DB_HOST":"localhost","DB_PORT":"5432"}`,
+		},
+	}
+	mockRunner := &MockCommandRunner{}
+
+	// テスト用アプリケーション
+	app := &Application{
+		Logger:        mockLogger,
+		SecretManager: mockSecretManager,
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/bin/echo", "test", "--key", "api-keys", "--key", "db-config"},
+	}
+
+	// 実行
+	err := app.Run()
+
+	// 検証
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// シークレットマネージャーの呼び出し確認
+	if len(mockSecretManager.Calls) != 2 {
+		t.Fatalf("Expected 2 calls to GetSecret, got: %d", len(mockSecretManager.Calls))
+	}
+
+	// 呼び出し順序の確認
+	if mockSecretManager.Calls[0] != "api-keys" || mockSecretManager.Calls[1] != "db-config" {
+		t.Errorf("Expected calls to 'api-keys' then 'db-config', got: %v", mockSecretManager.Calls)
+	}
+
+	// コマンドの確認
+	if len(mockRunner.ExecutedCommands) != 1 {
+		t.Fatalf("Expected 1 command execution, got: %d", len(mockRunner.ExecutedCommands))
+	}
+
+	cmd := mockRunner.ExecutedCommands[0]
+	if cmd.Path != "/bin/echo" || len(cmd.Args) != 1 || cmd.Args[0] != "test" {
+		t.Errorf("Expected command '/bin/echo test', got: '%s %v'", cmd.Path, cmd.Args)
+	}
+
+	// すべての環境変数が設定されたか確認
+	envVars := []string{"API_KEY", "API_SECRET", "DB_HOST", "DB_PORT"}
+	for _, envVar := range envVars {
+		found := false
+		for _, env := range cmd.Env {
+			if strings.HasPrefix(env, envVar+"=") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Expected environment variable %s to be set", envVar)
+		}
+	}
+}
+
+func TestApplication_Run_Prefix(t *testing.T) {
+	mockSecretManager := &MockSecretManager{
+		Secrets: map[string]string{
+			"db":    `{"HOST":"db.local","PASSWORD":"secure123"}`,
+			"cache": `{"HOST":"redis.local"}`,
+		},
+	}
+	mockRunner := &MockCommandRunner{}
+
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: mockSecretManager,
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--prefix", "DB_", "--key", "cache"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cmd := mockRunner.ExecutedCommands[0]
+	has := func(want string) bool {
+		for _, env := range cmd.Env {
+			if env == want {
+				return true
+			}
+		}
+		return false
+	}
+
+	// 直前の--keyのすべてのキーにプレフィックスが付く
+	for _, want := range []string{"DB_HOST=db.local", "DB_PASSWORD=secure123"} {
+		if !has(want) {
+			t.Errorf("Expected environment variable %s", want)
+		}
+	}
+	// 後続の--keyにはプレフィックスが付かない
+	if !has("HOST=redis.local") {
+		t.Error("Expected HOST=redis.local without prefix")
+	}
+	if has("DB_HOST=redis.local") {
+		t.Error("Prefix leaked into the following --key")
+	}
+
+	// --prefix は --key の後にのみ指定できる
+	app.Args = []string{"program", "/usr/bin/env", "--prefix", "DB_"}
+	if err := app.Run(); err == nil {
+		t.Error("Expected error for --prefix without --key, got nil")
+	}
+}
+
+func TestApplication_Run_Rename(t *testing.T) {
+	mockSecretManager := &MockSecretManager{
+		Secrets: map[string]string{"db": `{"db_password":"secure123","db_user":"admin","DB_HOST":"db.local"}`},
+	}
+	mockRunner := &MockCommandRunner{}
+
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: mockSecretManager,
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--rename", "db_password=DATABASE_PASSWORD", "--rename", "db_user=DATABASE_USER"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	env := strings.Join(mockRunner.ExecutedCommands[0].Env, "\n")
+	// リネームしたキーは新しい名前で設定され、他のキーはそのまま
+	for _, want := range []string{"DATABASE_PASSWORD=secure123", "DATABASE_USER=admin", "DB_HOST=db.local"} {
+		if !strings.Contains(env, want) {
+			t.Errorf("Expected environment variable %s", want)
+		}
+	}
+	// 元のキーは削除される
+	if strings.Contains(env, "db_password=") || strings.Contains(env, "db_user=") {
+		t.Errorf("Expected original keys to be dropped, got: %s", env)
+	}
+
+	// = のない指定はエラー
+	app.Args = []string{"program", "/usr/bin/env", "--key", "db", "--rename", "db_password"}
+	if err := app.Run(); err == nil || !strings.Contains(err.Error(), "FROM=TO") {
+		t.Errorf("Expected FROM=TO error, got: %v", err)
+	}
+
+	// 存在しないキーのリネームはエラー
+	app.Args = []string{"program", "/usr/bin/env", "--key", "db", "--rename", "missing=OTHER"}
+	if err := app.Run(); err == nil {
+		t.Error("Expected error for renaming a missing key, got nil")
+	}
+}
+
+func TestApplication_Run_DryRun(t *testing.T) {
+	mockLogger := &MockLogger{}
+	mockSecretManager := &MockSecretManager{Secrets: map[string]string{"db": `{"DB_PASSWORD":"secure123"}`}}
+	mockRunner := &MockCommandRunner{}
+
+	app := &Application{
+		Logger:        mockLogger,
+		SecretManager: mockSecretManager,
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--dry-run"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// dry-runではコマンドを実行しない
+	if len(mockRunner.ExecutedCommands) != 0 {
+		t.Errorf("Expected no command execution, got: %d", len(mockRunner.ExecutedCommands))
+	}
+	// シークレットは取得する
+	if len(mockSecretManager.Calls) != 1 {
+		t.Errorf("Expected 1 call to GetSecret, got: %d", len(mockSecretManager.Calls))
+	}
+
+	dryRunLog := func() string {
+		for _, log := range mockLogger.Logs {
+			if log.Message == "Dry run: resolved environment" {
+				b, _ := json.Marshal(log.Data)
+				return string(b)
+			}
+		}
+		t.Fatal("Expected a dry-run log entry")
+		return ""
+	}
+
+	// デフォルトではキーのみ出力する
+	if got := dryRunLog(); !strings.Contains(got, `"DB_PASSWORD"`) || strings.Contains(got, "secure123") {
+		t.Errorf("Expected keys only, got: %s", got)
+	}
+
+	// --show-values で値も出力する
+	mockLogger.Logs = nil
+	app.Args = []string{"program", "/usr/bin/env", "--key", "db", "--dry-run", "--show-values"}
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := dryRunLog(); !strings.Contains(got, `"DB_PASSWORD":"secure123"`) {
+		t.Errorf("Expected values to be shown, got: %s", got)
+	}
+
+	// 存在しないシークレットはdry-runでもエラー
+	app.Args = []string{"program", "/usr/bin/env", "--key", "missing", "--dry-run"}
+	if err := app.Run(); err == nil {
+		t.Error("Expected error for missing secret in dry-run, got nil")
+	}
+	if len(mockRunner.ExecutedCommands) != 0 {
+		t.Errorf("Expected no command execution, got: %d", len(mockRunner.ExecutedCommands))
+	}
+}
+
+func TestApplication_Run_PrintCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a Unix command")
+	}
+	t.Setenv("AWSECRUN_TEST_INHERITED", "inherited-value")
+
+	mockLogger := &MockLogger{}
+	runner := NewCommandRunner()
+	runner.Stdout = &strings.Builder{}
+	app := &Application{
+		Logger:        mockLogger,
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_PASSWORD":"secure123"}`}},
+		CommandRunner: runner,
+		Args:          []string{"program", "true", "--print-command", "--key", "db", "first", "second"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 実行前にコマンドの解決済みパス、引数、環境変数名だけをログに出す
+	for _, log := range mockLogger.Logs {
+		if log.Message != "Command invocation" {
+			continue
+		}
+		if log.Level != "info" {
+			t.Errorf("level = %q, want info", log.Level)
+		}
+		b, _ := json.Marshal(log.Data)
+		var got map[string]interface{}
+		json.Unmarshal(b, &got)
+		for _, field := range []string{"commandPath", "resolvedPath", "args", "envNames"} {
+			if _, ok := got[field]; !ok {
+				t.Errorf("log entry %s has no %s", b, field)
+			}
+		}
+		invocation := log.Data.(CommandInvocation)
+		if invocation.CommandPath != "true" || !filepath.IsAbs(invocation.ResolvedPath) {
+			t.Errorf("commandPath = %q, resolvedPath = %q", invocation.CommandPath, invocation.ResolvedPath)
+		}
+		if strings.Join(invocation.Args, " ") != "first second" {
+			t.Errorf("args = %v, want [first second]", invocation.Args)
+		}
+		names := strings.Join(invocation.EnvNames, ",")
+		if !strings.Contains(names, "DB_PASSWORD") || !strings.Contains(names, "AWSECRUN_TEST_INHERITED") {
+			t.Errorf("envNames = %v", invocation.EnvNames)
+		}
+		if strings.Contains(string(b), "secure123") || strings.Contains(string(b), "inherited-value") {
+			t.Errorf("log entry leaks env values: %s", b)
+		}
+		return
+	}
+	t.Error("Expected a Command invocation log entry")
+}
+
+func TestApplication_Run_CommandError(t *testing.T) {
+	// モックの準備
+	mockLogger := &MockLogger{}
+	mockSecretManager := &MockSecretManager{}
+	mockRunner := &MockCommandRunner{
+		ReturnError: fmt.Errorf("command execution failed"),
+	}
+
+	// テスト用アプリケーション
+	app := &Application{
+		Logger:        mockLogger,
+		SecretManager: mockSecretManager,
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/bin/false"},
+	}
+
+	// 実行
+	err := app.Run()
+
+	// エラーが発生することを確認
+	if err == nil {
+		t.Fatal("Expected error from CommandRunner, got nil")
+	}
+
+	// エラーメッセージの確認
+	if !strings.Contains(err.Error(), "Command execution error") {
+		t.Errorf("Expected error about command execution, got: %v", err)
+	}
+
+	// エラーログの確認
+	errorLogFound := false
+	for _, log := range mockLogger.Logs {
+		if log.Level == "error" && strings.Contains(log.Message, "Command execution failed") {
+			errorLogFound = true
+			break
+		}
+	}
+	if !errorLogFound {
+		t.Error("Expected error log about command execution")
+	}
+}
+
+func TestApplication_Run_ExpectHash(t *testing.T) {
+	secret := `{"DB_USER":"admin"}`
+	sum := sha256.Sum256([]byte(secret))
+	digest := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name    string
+		hash    string
+		wantErr string
+	}{
+		{name: "一致するハッシュ", hash: digest},
+		{name: "大文字のハッシュ", hash: strings.ToUpper(digest)},
+		{name: "一致しないハッシュ", hash: strings.Repeat("0", 64), wantErr: "integrity check"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRunner := &MockCommandRunner{}
+			app := &Application{
+				Logger:        &MockLogger{},
+				SecretManager: &MockSecretManager{Secrets: map[string]string{"db-creds": secret}},
+				CommandRunner: mockRunner,
+				Args:          []string{"program", "/usr/bin/env", "--key", "db-creds", "--expect-hash", "db-creds=" + tt.hash},
+			}
+
+			err := app.Run()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if len(mockRunner.ExecutedCommands) != 1 {
+					t.Errorf("Expected 1 command execution, got: %d", len(mockRunner.ExecutedCommands))
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Expected error containing %q, got: %v", tt.wantErr, err)
+			}
+			// ハッシュが一致しない場合はコマンドを実行しない
+			if len(mockRunner.ExecutedCommands) > 0 {
+				t.Errorf("Expected no command execution, got: %d", len(mockRunner.ExecutedCommands))
+			}
+		})
+	}
+
+	// 取得しないシークレットへのハッシュ指定はエラー
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"db-creds": secret}},
+		CommandRunner: &MockCommandRunner{},
+		Args:          []string{"program", "/usr/bin/env", "--key", "db-creds", "--expect-hash", "db-cred=" + digest},
+	}
+	if err := app.Run(); err == nil {
+		t.Error("Expected error for hash of a secret that is not requested, got nil")
+	}
+}
+
+func TestApplication_Run_RequireSecretCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "件数が一致", args: []string{"--key", "a", "--key", "b", "--require-secret-count", "2"}},
+		{name: "件数が不一致", args: []string{"--key", "a", "--require-secret-count", "2"}, wantErr: true},
+		// 末尾の--keyは値がないためコマンドの引数として扱われる
+		{name: "末尾の--key", args: []string{"--key", "a", "--require-secret-count", "2", "--key"}, wantErr: true},
+		{name: "ゼロ件", args: []string{"--require-secret-count", "0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRunner := &MockCommandRunner{}
+			app := &Application{
+				Logger:        &MockLogger{},
+				SecretManager: &MockSecretManager{Secrets: map[string]string{"a": `{"A":"1"}`, "b": `{"B":"2"}`}},
+				CommandRunner: mockRunner,
+				Args:          append([]string{"program", "/usr/bin/env"}, tt.args...),
+			}
+
+			err := app.Run()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && len(mockRunner.ExecutedCommands) > 0 {
+				t.Errorf("Expected no command execution, got: %d", len(mockRunner.ExecutedCommands))
+			}
+		})
+	}
+}
+
+// MockMetadataSecretManager はメタデータを返すSecretManagerのモック実装
+type MockMetadataSecretManager struct {
+	MockSecretManager
+	Metadata map[string]SecretMetadata
+}
+
+// SecretMetadata はモックされたメタデータを返す
+func (m *MockMetadataSecretManager) SecretMetadata(secretName string) (SecretMetadata, bool) {
+	meta, ok := m.Metadata[secretName]
+	return meta, ok
+}
+
+func TestApplication_Run_InjectSecretDate(t *testing.T) {
+	created := time.Date(2024, 3, 15, 9, 30, 0, 0, time.FixedZone("JST", 9*60*60))
+	mockSecretManager := &MockMetadataSecretManager{
+		MockSecretManager: MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}},
+		Metadata:          map[string]SecretMetadata{"db": {CreatedDate: created}},
+	}
+	mockRunner := &MockCommandRunner{}
+
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: mockSecretManager,
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--inject-secret-date", "DB_SECRET_DATE"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 作成日時がUTCのRFC3339形式で設定される
+	found := false
+	for _, env := range mockRunner.ExecutedCommands[0].Env {
+		if env == "DB_SECRET_DATE=2024-03-15T00:30:00Z" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected DB_SECRET_DATE=2024-03-15T00:30:00Z, got: %v", mockRunner.ExecutedCommands[0].Env)
+	}
+
+	// メタデータを返さないSecretManagerではエラー
+	app.SecretManager = &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}}
+	if err := app.Run(); err == nil {
+		t.Error("Expected error without a creation date, got nil")
+	}
+}
+
+func TestExitCode_OwnErrors(t *testing.T) {
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Error: fmt.Errorf("connection error")},
+		CommandRunner: &MockCommandRunner{},
+		Args:          []string{"program", "/bin/ls", "--key", "some-secret"},
+	}
+
+	// シークレット取得の失敗など自身のエラーは終了コード1
+	err := app.Run()
+	if err == nil {
+		t.Fatal("Expected error from SecretManager, got nil")
+	}
+	if got := ExitCode(err); got != 1 {
+		t.Errorf("exitCode() = %d, want 1", got)
+	}
+}
+
+func TestApplication_Run_LogsSecretSource(t *testing.T) {
+	ssmManager := NewSSMSecretManager()
+	ssmManager.client = newMockSSMClient()
+	appConfigManager := NewAppConfigManager()
+	appConfigManager.client = &MockAppConfigDataClient{Content: map[string]string{"myapp/prod/flags": `{"FEATURE_X":"on"}`}}
+
+	mockLogger := &MockLogger{}
+	app := &Application{
+		Logger:        mockLogger,
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"api": `{"API_KEY":"xyz"}`}},
+		CommandRunner: &MockCommandRunner{},
+		Args:          []string{"program", "/usr/bin/env", "--param", "/app/db", "--appconfig", "myapp/prod/flags", "--key", "api"},
+		Backends: map[string]SecretManager{
+			SourceSSM:       ssmManager,
+			SourceAppConfig: appConfigManager,
+		},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 取得ログのsourceがバックエンドと一致することを確認
+	var sources []string
+	for _, log := range mockLogger.Logs {
+		if log.Message == "Retrieved secret keys" {
+			sources = append(sources, log.Data.(map[string]interface{})["source"].(string))
+		}
+	}
+	want := []string{SourceSSM, SourceAppConfig, "unknown"}
+	if strings.Join(sources, ",") != strings.Join(want, ",") {
+		t.Errorf("sources = %v, want %v", sources, want)
+	}
+	if got := sourceOf(NewAWSSecretManager()); got != SourceSecretsManager {
+		t.Errorf("sourceOf(AWSSecretManager) = %q, want %q", got, SourceSecretsManager)
+	}
+}
+
+// fakeConfigLoader は渡されたオプションをLoadOptionsに適用して記録する設定ローダー
+func fakeConfigLoader(captured *config.LoadOptions, calls *int) configLoader {
+	return func(ctx context.Context, optFns ...func(*config.LoadOptions) error) (aws.Config, error) {
+		*calls++
+		for _, fn := range optFns {
+			if err := fn(captured); err != nil {
+				return aws.Config{}, err
+			}
+		}
+		return aws.Config{Region: captured.Region}, nil
+	}
+}
+
+func TestAWSSecretManager_WithRegion(t *testing.T) {
+	var captured config.LoadOptions
+	calls := 0
+	sm := NewAWSSecretManager(WithRegion("ap-northeast-1"))
+	sm.loadConfig = fakeConfigLoader(&captured, &calls)
+
+	cfg, err := sm.LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	// リージョンが設定ローダーに渡される
+	if captured.Region != "ap-northeast-1" || cfg.Region != "ap-northeast-1" {
+		t.Errorf("Region = %q (config %q), want ap-northeast-1", captured.Region, cfg.Region)
+	}
+
+	// 指定がない場合はデフォルトチェーンに任せる
+	captured = config.LoadOptions{}
+	sm = NewAWSSecretManager()
+	sm.loadConfig = fakeConfigLoader(&captured, &calls)
+	if _, err := sm.LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if captured.Region != "" {
+		t.Errorf("Expected no region override, got: %q", captured.Region)
+	}
+}
+
+func TestApplication_ConfigureSecretManager_Region(t *testing.T) {
+	var captured config.LoadOptions
+	calls := 0
+	sm := NewAWSSecretManager()
+	sm.loadConfig = fakeConfigLoader(&captured, &calls)
+
+	app := &Application{Logger: &MockLogger{}, SecretManager: sm}
+	opts, err := parseArgs([]string{"program", "/usr/bin/env", "--region", "eu-west-1"})
+	if err != nil {
+		t.Fatalf("parseArgs() error = %v", err)
+	}
+	app.configureSecretManager(opts)
+
+	// --regionが設定ローダーまで届くことを確認
+	if _, err := sm.LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if captured.Region != "eu-west-1" {
+		t.Errorf("Region = %q, want eu-west-1", captured.Region)
+	}
+
+	// SSMのバックエンドも同じ設定を使う
+	captured = config.LoadOptions{}
+	defaultApp := NewApplication([]string{"program"})
+	defaultSM := defaultApp.SecretManager.(*AWSSecretManager)
+	defaultSM.loadConfig = fakeConfigLoader(&captured, &calls)
+	WithRegion("us-west-2")(defaultSM)
+	ssmManager := defaultApp.Backends[SourceSSM].(*SSMSecretManager)
+	if _, err := ssmManager.getClient(); err != nil {
+		t.Fatalf("getClient() error = %v", err)
+	}
+	if captured.Region != "us-west-2" {
+		t.Errorf("SSM region = %q, want us-west-2", captured.Region)
+	}
+}
+
+func TestApplication_ConfigureSecretManager_SharedFiles(t *testing.T) {
+	var captured config.LoadOptions
+	calls := 0
+	sm := NewAWSSecretManager()
+	sm.loadConfig = fakeConfigLoader(&captured, &calls)
+
+	app := &Application{Logger: &MockLogger{}, SecretManager: sm}
+	opts, err := parseArgs([]string{"program", "/usr/bin/env", "--aws-config-file", "/sandbox/aws/config", "--aws-credentials-file", "/sandbox/aws/credentials"})
+	if err != nil {
+		t.Fatalf("parseArgs() error = %v", err)
+	}
+	app.configureSecretManager(opts)
+
+	if _, err := sm.LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	// 指定したファイルパスが設定ローダーに渡される
+	if len(captured.SharedConfigFiles) != 1 || captured.SharedConfigFiles[0] != "/sandbox/aws/config" {
+		t.Errorf("SharedConfigFiles = %v, want [/sandbox/aws/config]", captured.SharedConfigFiles)
+	}
+	if len(captured.SharedCredentialsFiles) != 1 || captured.SharedCredentialsFiles[0] != "/sandbox/aws/credentials" {
+		t.Errorf("SharedCredentialsFiles = %v, want [/sandbox/aws/credentials]", captured.SharedCredentialsFiles)
+	}
+
+	// 指定がない場合はデフォルトのパスに任せる
+	captured = config.LoadOptions{}
+	sm = NewAWSSecretManager()
+	sm.loadConfig = fakeConfigLoader(&captured, &calls)
+	if _, err := sm.LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if captured.SharedConfigFiles != nil || captured.SharedCredentialsFiles != nil {
+		t.Errorf("Expected default shared files, got: %v %v", captured.SharedConfigFiles, captured.SharedCredentialsFiles)
+	}
+}
+
+func TestApplication_ConfigureSecretManager_SharedConfigDisabled(t *testing.T) {
+	var captured config.LoadOptions
+	calls := 0
+	sm := NewAWSSecretManager()
+	sm.loadConfig = fakeConfigLoader(&captured, &calls)
+
+	app := &Application{Logger: &MockLogger{}, SecretManager: sm}
+	opts, err := parseArgs([]string{"program", "/usr/bin/env", "--aws-shared-config-disable"})
+	if err != nil {
+		t.Fatalf("parseArgs() error = %v", err)
+	}
+	app.configureSecretManager(opts)
+
+	if _, err := sm.LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	// 空のファイル一覧が渡され、デフォルトの~/.awsは読まれない
+	if captured.SharedConfigFiles == nil || len(captured.SharedConfigFiles) != 0 {
+		t.Errorf("SharedConfigFiles = %#v, want an empty non-nil slice", captured.SharedConfigFiles)
+	}
+	if captured.SharedCredentialsFiles == nil || len(captured.SharedCredentialsFiles) != 0 {
+		t.Errorf("SharedCredentialsFiles = %#v, want an empty non-nil slice", captured.SharedCredentialsFiles)
+	}
+
+	// ファイル指定との併用はエラー
+	if _, err := parseArgs([]string{"program", "/usr/bin/env", "--aws-shared-config-disable", "--aws-config-file", "/tmp/config"}); err == nil {
+		t.Error("Expected error combining --aws-shared-config-disable with --aws-config-file, got nil")
+	}
+}
+
+func TestApplication_ConfigureSecretManager_Profile(t *testing.T) {
+	t.Setenv("AWS_PROFILE", "from-env")
+	var captured config.LoadOptions
+	calls := 0
+	sm := NewAWSSecretManager()
+	sm.loadConfig = fakeConfigLoader(&captured, &calls)
+	logger := &MockLogger{}
+
+	app := &Application{Logger: logger, SecretManager: sm}
+	opts, err := parseArgs([]string{"program", "/usr/bin/env", "--profile", "staging"})
+	if err != nil {
+		t.Fatalf("parseArgs() error = %v", err)
+	}
+	app.configureSecretManager(opts)
+
+	if _, err := sm.LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	// --profileがAWS_PROFILEより優先して設定ローダーに渡される
+	if captured.SharedConfigProfile != "staging" {
+		t.Errorf("SharedConfigProfile = %q, want staging", captured.SharedConfigProfile)
+	}
+	overridden := false
+	for _, log := range logger.Logs {
+		if log.Level == "debug" && strings.Contains(log.Message, "AWS_PROFILE") {
+			overridden = true
+		}
+	}
+	if !overridden {
+		t.Errorf("Expected a debug log about overriding AWS_PROFILE, got: %+v", logger.Logs)
+	}
+
+	if _, err := parseArgs([]string{"program", "/usr/bin/env", "--profile", "staging", "--aws-shared-config-disable"}); err == nil {
+		t.Error("Expected error combining --profile with --aws-shared-config-disable, got nil")
+	}
+}
+
+func TestApplication_Run_EndpointURL(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	// LocalStackを模したGetSecretValueのエンドポイント
+	var targets []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targets = append(targets, r.Header.Get("X-Amz-Target"))
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		fmt.Fprint(w, `{"ARN":"arn:aws:secretsmanager:us-east-1:000000000000:secret:db","Name":"db","SecretString":"{\"DB_USER\":\"admin\"}"}`)
+	}))
+	defer srv.Close()
+
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: NewAWSSecretManager(),
+		CommandRunner: mockRunner,
+		Args: []string{"program", "/usr/bin/env", "--key", "db",
+			"--endpoint-url", srv.URL, "--region", "us-east-1", "--aws-shared-config-disable"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// リクエストが指定したエンドポイントに送られることを確認
+	if len(targets) == 0 || targets[0] != "secretsmanager.GetSecretValue" {
+		t.Errorf("requests = %v, want secretsmanager.GetSecretValue first", targets)
+	}
+	if env := strings.Join(mockRunner.ExecutedCommands[0].Env, "\n"); !strings.Contains(env, "DB_USER=admin") {
+		t.Error("Expected DB_USER=admin in env")
+	}
+
+	if _, err := parseArgs([]string{"program", "/usr/bin/env", "--endpoint-url", "localhost:4566"}); err == nil {
+		t.Error("Expected error for an --endpoint-url without scheme, got nil")
+	}
+}
+
+func TestAWSSecretManager_WithoutSharedConfigIgnoresProfile(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config")
+	os.WriteFile(configFile, []byte("[default]\nregion = eu-central-1\n"), 0600)
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	t.Setenv("AWS_PROFILE", "")
+
+	// 共有設定を読むとプロファイルのリージョンが使われる
+	cfg, err := NewAWSSecretManager().LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.Region != "eu-central-1" {
+		t.Fatalf("Region = %q, want eu-central-1 from the shared config", cfg.Region)
+	}
+
+	// 無効にすると共有設定ファイルの内容は使われない
+	cfg, err = NewAWSSecretManager(WithoutSharedConfig()).LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.Region != "" {
+		t.Errorf("Region = %q, want none with shared config disabled", cfg.Region)
+	}
+}
+
+// fakeAssumeRoleClient はAssumeRoleの入力を記録するSTSクライアントのモック
+type fakeAssumeRoleClient struct {
+	Inputs []sts.AssumeRoleInput
+}
+
+// AssumeRole は固定の一時認証情報を返す
+func (c *fakeAssumeRoleClient) AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	c.Inputs = append(c.Inputs, *params)
+	return &sts.AssumeRoleOutput{
+		Credentials: &ststypes.Credentials{
+			AccessKeyId:     aws.String("ASSUMED"),
+			SecretAccessKey: aws.String("secret"),
+			SessionToken:    aws.String("token"),
+			Expiration:      aws.Time(time.Now().Add(time.Hour)),
+		},
+	}, nil
+}
+
+func TestApplication_ConfigureSecretManager_AssumeRole(t *testing.T) {
+	var captured config.LoadOptions
+	calls := 0
+	stsClient := &fakeAssumeRoleClient{}
+	sm := NewAWSSecretManager()
+	sm.loadConfig = fakeConfigLoader(&captured, &calls)
+	sm.newSTSClient = func(cfg aws.Config) stscreds.AssumeRoleAPIClient { return stsClient }
+
+	app := &Application{Logger: &MockLogger{}, SecretManager: sm}
+	opts, err := parseArgs([]string{"program", "/usr/bin/env", "--assume-role-arn", "arn:aws:iam::123456789012:role/reader", "--external-id", "ext-1"})
+	if err != nil {
+		t.Fatalf("parseArgs() error = %v", err)
+	}
+	app.configureSecretManager(opts)
+
+	cfg, err := sm.LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	// 取得した認証情報はキャッシュされ、STSは一度だけ呼ばれる
+	for i := 0; i < 3; i++ {
+		creds, err := cfg.Credentials.Retrieve(context.Background())
+		if err != nil {
+			t.Fatalf("Retrieve() error = %v", err)
+		}
+		if creds.AccessKeyID != "ASSUMED" {
+			t.Errorf("AccessKeyID = %q, want ASSUMED", creds.AccessKeyID)
+		}
+	}
+	if len(stsClient.Inputs) != 1 {
+		t.Fatalf("AssumeRole calls = %d, want 1", len(stsClient.Inputs))
+	}
+
+	// ロールARNと外部IDがそのまま渡される
+	input := stsClient.Inputs[0]
+	if aws.ToString(input.RoleArn) != "arn:aws:iam::123456789012:role/reader" {
+		t.Errorf("RoleArn = %q", aws.ToString(input.RoleArn))
+	}
+	if aws.ToString(input.ExternalId) != "ext-1" {
+		t.Errorf("ExternalId = %q, want ext-1", aws.ToString(input.ExternalId))
+	}
+
+	// ロール指定なしの外部IDはエラー
+	if _, err := parseArgs([]string{"program", "/usr/bin/env", "--external-id", "ext-1"}); err == nil {
+		t.Error("Expected error for --external-id without --assume-role-arn")
+	}
+}
+
+// MockSecretsManagerClient はSecrets Manager APIクライアントのモック実装
+type MockSecretsManagerClient struct {
+	Secrets  map[string]string
+	Binaries map[string][]byte
+	Tags     map[string]map[string]string
+	Inputs   []secretsmanager.GetSecretValueInput
+	// DescribeErrors はDescribeSecretがシークレットごとに返すエラー
+	DescribeErrors map[string]error
+	// RequestIDs はGetSecretValueのレスポンスメタデータに載せるリクエストID
+	RequestIDs map[string]string
+	// BatchInputs はBatchGetSecretValueに渡されたシークレットID、BatchErrorはその呼び出しが返すエラー
+	BatchInputs [][]string
+	BatchError  error
+}
+
+// mockVersionID はモックが返すシークレットのバージョンID
+func mockVersionID(secretName string) *string {
+	return aws.String("v-" + secretName)
+}
+
+// GetSecretValue はモックされたシークレットを返す
+func (c *MockSecretsManagerClient) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	c.Inputs = append(c.Inputs, *params)
+	stages := []string{"AWSCURRENT"}
+	if params.VersionStage != nil {
+		stages = []string{aws.ToString(params.VersionStage)}
+	}
+	if binary, ok := c.Binaries[aws.ToString(params.SecretId)]; ok {
+		return &secretsmanager.GetSecretValueOutput{Name: params.SecretId, SecretBinary: binary, VersionId: mockVersionID(aws.ToString(params.SecretId)), VersionStages: stages}, nil
+	}
+	secret, ok := c.Secrets[aws.ToString(params.SecretId)]
+	if !ok {
+		return nil, fmt.Errorf("ResourceNotFoundException: %s", aws.ToString(params.SecretId))
+	}
+	output := &secretsmanager.GetSecretValueOutput{
+		Name:          params.SecretId,
+		SecretString:  aws.String(secret),
+		VersionId:     mockVersionID(aws.ToString(params.SecretId)),
+		VersionStages: stages,
+	}
+	if id, ok := c.RequestIDs[aws.ToString(params.SecretId)]; ok {
+		awsmiddleware.SetRequestIDMetadata(&output.ResultMetadata, id)
+	}
+	return output, nil
+}
+
+// BatchGetSecretValue はモックされたシークレットをまとめて返し、ないものはErrorsに入れる
+func (c *MockSecretsManagerClient) BatchGetSecretValue(ctx context.Context, params *secretsmanager.BatchGetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.BatchGetSecretValueOutput, error) {
+	c.BatchInputs = append(c.BatchInputs, params.SecretIdList)
+	if c.BatchError != nil {
+		return nil, c.BatchError
+	}
+	output := &secretsmanager.BatchGetSecretValueOutput{}
+	for _, id := range params.SecretIdList {
+		secret, ok := c.Secrets[id]
+		if !ok {
+			output.Errors = append(output.Errors, types.APIErrorType{
+				SecretId:  aws.String(id),
+				ErrorCode: aws.String("ResourceNotFoundException"),
+			})
+			continue
+		}
+		output.SecretValues = append(output.SecretValues, types.SecretValueEntry{
+			ARN:           aws.String("arn:aws:secretsmanager:us-east-1:123456789012:secret:" + id),
+			Name:          aws.String(id),
+			SecretString:  aws.String(secret),
+			VersionId:     mockVersionID(id),
+			VersionStages: []string{"AWSCURRENT"},
+		})
+	}
+	return output, nil
+}
+
+// DescribeSecret はモックされたタグを返す
+func (c *MockSecretsManagerClient) DescribeSecret(ctx context.Context, params *secretsmanager.DescribeSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error) {
+	if err, ok := c.DescribeErrors[aws.ToString(params.SecretId)]; ok {
+		return nil, err
+	}
+	output := &secretsmanager.DescribeSecretOutput{Name: params.SecretId}
+	for k, v := range c.Tags[aws.ToString(params.SecretId)] {
+		output.Tags = append(output.Tags, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	return output, nil
+}
+
+// newTestAWSSecretManager はモックのローダーとクライアントを使うAWSSecretManagerを返す
+func newTestAWSSecretManager(client secretsManagerAPI, loaderCalls *int) *AWSSecretManager {
+	var captured config.LoadOptions
+	sm := NewAWSSecretManager()
+	sm.loadConfig = fakeConfigLoader(&captured, loaderCalls)
+	sm.client = client
+	return sm
+}
+
+func TestAWSSecretManager_LoadsConfigOnce(t *testing.T) {
+	client := &MockSecretsManagerClient{Secrets: map[string]string{"a": "1", "b": "2", "c": "3"}}
+	calls := 0
+	sm := newTestAWSSecretManager(client, &calls)
+
+	for i := 0; i < 5; i++ {
+		for _, name := range []string{"a", "b", "c"} {
+			if _, err := sm.GetSecret(name); err != nil {
+				t.Fatalf("GetSecret(%s) error = %v", name, err)
+			}
+		}
+	}
+
+	// 設定の読み込みは最初の一度だけ
+	if calls != 1 {
+		t.Errorf("Expected config loader to be called once, got: %d", calls)
+	}
+	if len(client.Inputs) != 15 {
+		t.Errorf("Expected 15 GetSecretValue calls, got: %d", len(client.Inputs))
+	}
+}
+
+func BenchmarkApplication_Run_RepeatedKeys(b *testing.B) {
+	client := &MockSecretsManagerClient{Secrets: map[string]string{"a": `{"A":"1"}`, "b": `{"B":"2"}`, "c": `{"C":"3"}`}}
+	args := []string{"program", "/usr/bin/env", "--key", "a", "--key", "b", "--key", "c"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		calls := 0
+		app := &Application{
+			Logger:        &MockLogger{},
+			SecretManager: newTestAWSSecretManager(client, &calls),
+			CommandRunner: &MockCommandRunner{},
+			Args:          args,
+		}
+		if err := app.Run(); err != nil {
+			b.Fatal(err)
+		}
+		// 複数の--keyでも設定の読み込みは実行ごとに一度だけ
+		if calls != 1 {
+			b.Fatalf("Expected config loader to be called once, got: %d", calls)
+		}
+		client.Inputs = client.Inputs[:0]
+		client.BatchInputs = client.BatchInputs[:0]
+	}
+}
+
+func TestApplication_Run_LogsRequestIDAndLatency(t *testing.T) {
+	client := &MockSecretsManagerClient{
+		Secrets:    map[string]string{"db": `{"DB_USER":"admin"}`},
+		RequestIDs: map[string]string{"db": "a1b2c3d4-5678-90ab-cdef-EXAMPLE11111"},
+	}
+	calls := 0
+	mockLogger := &MockLogger{}
+	app := &Application{
+		Logger:        mockLogger,
+		SecretManager: newTestAWSSecretManager(client, &calls),
+		CommandRunner: &MockCommandRunner{},
+		Args:          []string{"program", "/usr/bin/env", "--key", "db"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// シークレットごとの取得ログにリクエストIDとレイテンシが含まれることを確認
+	for _, log := range mockLogger.Logs {
+		if log.Message != "Retrieved secret keys" {
+			continue
+		}
+		data := log.Data.(map[string]interface{})
+		if data["requestId"] != "a1b2c3d4-5678-90ab-cdef-EXAMPLE11111" {
+			t.Errorf("requestId = %v, want a1b2c3d4-5678-90ab-cdef-EXAMPLE11111", data["requestId"])
+		}
+		if latency, ok := data["latencyMs"].(int64); !ok || latency < 0 {
+			t.Errorf("latencyMs = %v, want a non-negative int64", data["latencyMs"])
+		}
+		return
+	}
+	t.Error("Expected a Retrieved secret keys log entry")
+}
+
+// StagedSecretsManagerClient はステージラベルごとの値を返し、ないステージはResourceNotFoundにする
+type StagedSecretsManagerClient struct {
+	MockSecretsManagerClient
+	Stages map[string]string
+}
+
+// GetSecretValue は要求されたステージの値を返す
+func (c *StagedSecretsManagerClient) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	c.Inputs = append(c.Inputs, *params)
+	stage := aws.ToString(params.VersionStage)
+	if stage == "" {
+		stage = "AWSCURRENT"
+	}
+	secret, ok := c.Stages[stage]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("Secrets Manager can't find the specified secret value for staging label: " + stage)}
+	}
+	return &secretsmanager.GetSecretValueOutput{Name: params.SecretId, SecretString: aws.String(secret)}, nil
+}
+
+func TestApplication_Run_FallbackStage(t *testing.T) {
+	client := &StagedSecretsManagerClient{Stages: map[string]string{"AWSPREVIOUS": `{"DB_PASSWORD":"previous"}`}}
+	calls := 0
+	mockLogger := &MockLogger{}
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        mockLogger,
+		SecretManager: newTestAWSSecretManager(client, &calls),
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--fallback-stage", "AWSPREVIOUS"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// AWSCURRENTがないときはAWSPREVIOUSの値を使い、警告を出す
+	if env := strings.Join(mockRunner.ExecutedCommands[0].Env, "\n"); !strings.Contains(env, "DB_PASSWORD=previous") {
+		t.Errorf("Expected DB_PASSWORD=previous in env")
+	}
+	if len(client.Inputs) != 2 || aws.ToString(client.Inputs[1].VersionStage) != "AWSPREVIOUS" {
+		t.Errorf("inputs = %+v, want current then AWSPREVIOUS", client.Inputs)
+	}
+	warned := false
+	for _, log := range mockLogger.Logs {
+		if log.Level == "warn" && strings.Contains(log.Message, "fallback stage") {
+			warned = true
+		}
+	}
+	if !warned {
+		t.Error("Expected a warning about the fallback stage")
+	}
+
+	// 指定しなければフォールバックしない
+	client.Inputs = nil
+	app.Args = []string{"program", "/usr/bin/env", "--key", "db"}
+	if err := app.Run(); err == nil {
+		t.Error("Expected error without --fallback-stage, got nil")
+	}
+	if len(client.Inputs) != 1 {
+		t.Errorf("GetSecretValue calls = %d, want 1", len(client.Inputs))
+	}
+}
+
+func TestApplication_Run_SecretVersion(t *testing.T) {
+	client := &MockSecretsManagerClient{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}}
+	calls := 0
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: newTestAWSSecretManager(client, &calls),
+		CommandRunner: &MockCommandRunner{},
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--version-stage", "AWSPREVIOUS", "--key", "db", "--version-id", "v-123"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 指定したバージョンがGetSecretValueInputに渡されることを確認
+	if len(client.Inputs) != 2 {
+		t.Fatalf("Expected 2 GetSecretValue calls, got: %d", len(client.Inputs))
+	}
+	if got := aws.ToString(client.Inputs[0].VersionStage); got != "AWSPREVIOUS" || client.Inputs[0].VersionId != nil {
+		t.Errorf("First input VersionStage = %q, VersionId = %v; want AWSPREVIOUS and nil", got, client.Inputs[0].VersionId)
+	}
+	if got := aws.ToString(client.Inputs[1].VersionId); got != "v-123" || client.Inputs[1].VersionStage != nil {
+		t.Errorf("Second input VersionId = %q, VersionStage = %v; want v-123 and nil", got, client.Inputs[1].VersionStage)
+	}
+
+	// idとstageの同時指定はエラー
+	app.Args = []string{"program", "/usr/bin/env", "--key", "db", "--version-id", "v-123", "--version-stage", "AWSCURRENT"}
+	err := app.Run()
+	if err == nil || !strings.Contains(err.Error(), "cannot both be set") {
+		t.Errorf("Expected error for both version id and stage, got: %v", err)
+	}
+	if _, err := newTestAWSSecretManager(client, &calls).GetSecretVersion("db", SecretVersion{ID: "v-123", Stage: "AWSCURRENT"}); err == nil {
+		t.Error("Expected GetSecretVersion error for both version id and stage, got nil")
+	}
+
+	// バージョン指定に対応しないバックエンドはエラー
+	app.SecretManager = &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}}
+	app.Args = []string{"program", "/usr/bin/env", "--key", "db", "--version-stage", "AWSPREVIOUS"}
+	if err := app.Run(); err == nil {
+		t.Error("Expected error for backend without version support, got nil")
+	}
+}
+
+// BlockingSecretsManagerClient はコンテキストが終了するまで応答しないクライアント
+type BlockingSecretsManagerClient struct {
+	MockSecretsManagerClient
+}
+
+// GetSecretValue はコンテキストの終了を待ってエラーを返す
+func (c *BlockingSecretsManagerClient) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	if _, ok := c.Secrets[aws.ToString(params.SecretId)]; ok {
+		return c.MockSecretsManagerClient.GetSecretValue(ctx, params, optFns...)
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestApplication_Run_FetchTimeout(t *testing.T) {
+	client := &BlockingSecretsManagerClient{MockSecretsManagerClient{Secrets: map[string]string{"fast": `{"A":"1"}`}}}
+	calls := 0
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: newTestAWSSecretManager(client, &calls),
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "fast", "--key", "hung", "--timeout", "50ms"},
+	}
+
+	start := time.Now()
+	err := app.Run()
+
+	// 応答しないシークレットはタイムアウトし、名前を含むエラーになる
+	if err == nil || !strings.Contains(err.Error(), "timed out") || !strings.Contains(err.Error(), "hung") {
+		t.Fatalf("Expected timeout error naming the secret, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run() took %s, expected it to stop at the timeout", elapsed)
+	}
+	if len(mockRunner.ExecutedCommands) != 0 {
+		t.Errorf("Expected no command execution, got: %d", len(mockRunner.ExecutedCommands))
+	}
+
+	// 実行後はタイムアウトのコンテキストが残らない
+	if _, err := app.SecretManager.GetSecret("fast"); err != nil {
+		t.Errorf("GetSecret() after Run error = %v", err)
+	}
+}
+
+// FlakySecretsManagerClient は先頭のエラーを順に返してから成功するクライアント
+type FlakySecretsManagerClient struct {
+	MockSecretsManagerClient
+	Errors []error
+	Calls  int
+}
+
+// GetSecretValue は残っているエラーを1つ返し、なくなれば成功する
+func (c *FlakySecretsManagerClient) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	c.Calls++
+	if len(c.Errors) > 0 {
+		err := c.Errors[0]
+		c.Errors = c.Errors[1:]
+		return nil, err
+	}
+	return c.MockSecretsManagerClient.GetSecretValue(ctx, params, optFns...)
+}
+
+func TestAWSSecretManager_RetriesTransientErrors(t *testing.T) {
+	client := &FlakySecretsManagerClient{
+		MockSecretsManagerClient: MockSecretsManagerClient{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}},
+		Errors: []error{
+			&smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"},
+			&smithy.GenericAPIError{Code: "InternalServiceError", Message: "try again"},
+		},
+	}
+	calls := 0
+	logger := &MockLogger{}
+	sm := newTestAWSSecretManager(client, &calls)
+	sm.Logger = logger
+	var delays []time.Duration
+	sm.sleep = func(d time.Duration) { delays = append(delays, d) }
+	WithRetries(3, 100*time.Millisecond)(sm)
+
+	got, err := sm.GetSecret("db")
+	if err != nil {
+		t.Fatalf("GetSecret() error = %v", err)
+	}
+	if got != `{"DB_USER":"admin"}` {
+		t.Errorf("GetSecret() = %q", got)
+	}
+
+	// 2回失敗した後に成功し、待ち時間は指数的に増える
+	if client.Calls != 3 {
+		t.Errorf("Expected 3 calls, got: %d", client.Calls)
+	}
+	if len(delays) != 2 || delays[0] != 100*time.Millisecond || delays[1] != 200*time.Millisecond {
+		t.Errorf("delays = %v, want [100ms 200ms]", delays)
+	}
+
+	// リトライごとに試行回数付きの警告を出す
+	warnings := 0
+	for _, log := range logger.Logs {
+		if log.Level == "warn" && log.Message == "Retrying secret fetch after transient error" {
+			warnings++
+			if data := log.Data.(map[string]interface{}); data["attempt"] != warnings {
+				t.Errorf("attempt = %v, want %d", data["attempt"], warnings)
+			}
+		}
+	}
+	if warnings != 2 {
+		t.Errorf("Expected 2 retry warnings, got: %d", warnings)
+	}
+}
+
+func TestAWSSecretManager_DoesNotRetryPermanentErrors(t *testing.T) {
+	for _, code := range []string{"AccessDeniedException", "ResourceNotFoundException"} {
+		t.Run(code, func(t *testing.T) {
+			client := &FlakySecretsManagerClient{
+				MockSecretsManagerClient: MockSecretsManagerClient{Secrets: map[string]string{"db": "{}"}},
+				Errors:                   []error{&smithy.GenericAPIError{Code: code}},
+			}
+			calls := 0
+			sm := newTestAWSSecretManager(client, &calls)
+			sm.sleep = func(time.Duration) { t.Error("Unexpected retry delay") }
+
+			// リトライせずに即座に失敗する
+			if _, err := sm.GetSecret("db"); err == nil {
+				t.Fatal("Expected error, got nil")
+			}
+			if client.Calls != 1 {
+				t.Errorf("Expected 1 call, got: %d", client.Calls)
+			}
+		})
+	}
+}
+
+func TestAWSSecretManager_RetriesExhausted(t *testing.T) {
+	throttled := &smithy.GenericAPIError{Code: "ThrottlingException"}
+	client := &FlakySecretsManagerClient{Errors: []error{throttled, throttled, throttled}}
+	calls := 0
+	sm := newTestAWSSecretManager(client, &calls)
+	sm.sleep = func(time.Duration) {}
+	WithRetries(2, time.Millisecond)(sm)
+
+	// 最大リトライ回数を超えたら最後のエラーを返す
+	if _, err := sm.GetSecret("db"); err == nil {
+		t.Fatal("Expected error, got nil")
+	}
+	if client.Calls != 3 {
+		t.Errorf("Expected 3 calls (1 + 2 retries), got: %d", client.Calls)
+	}
+}
+
+func TestApplication_Run_BinarySecret(t *testing.T) {
+	payload := []byte{0x30, 0x82, 0x00, 0xff, 0x0a}
+	client := &MockSecretsManagerClient{Binaries: map[string][]byte{"keystore": payload}}
+	calls := 0
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: newTestAWSSecretManager(client, &calls),
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "keystore", "--rename", "secret=KEYSTORE"},
+	}
+
+	// デフォルトではbase64で注入する
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := "KEYSTORE=" + base64.StdEncoding.EncodeToString(payload)
+	if env := strings.Join(mockRunner.ExecutedCommands[0].Env, "\n"); !strings.Contains(env, want) {
+		t.Errorf("Expected %s in env", want)
+	}
+
+	// fileモードでは0600の一時ファイルのパスを注入する
+	var path string
+	var mode os.FileMode
+	var content []byte
+	mockRunner.RunFunc = func(commandPath string, args []string, env []string) error {
+		for _, entry := range env {
+			if p, ok := strings.CutPrefix(entry, "KEYSTORE="); ok {
+				path = p
+			}
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		mode = info.Mode().Perm()
+		content, err = os.ReadFile(path)
+		return err
+	}
+	app.Args = []string{"program", "/usr/bin/env", "--key", "keystore", "--rename", "secret=KEYSTORE", "--binary-mode", "file"}
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(content, payload) {
+		t.Errorf("file content = %v, want %v", content, payload)
+	}
+	if runtime.GOOS != "windows" && mode != 0600 {
+		t.Errorf("file mode = %v, want 0600", mode)
+	}
+	// コマンド終了後にファイルは削除される
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed, got: %v", path, err)
+	}
+
+	// 不正なモードはエラー
+	app.Args = []string{"program", "/usr/bin/env", "--key", "keystore", "--binary-mode", "hex"}
+	if err := app.Run(); err == nil {
+		t.Error("Expected error for an invalid binary mode, got nil")
+	}
+}
+
+func BenchmarkAWSSecretManager_GetSecret(b *testing.B) {
+	client := &MockSecretsManagerClient{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}}
+	calls := 0
+	sm := newTestAWSSecretManager(client, &calls)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := sm.GetSecret("db"); err != nil {
+			b.Fatal(err)
+		}
+		// 呼び出し記録が増え続けないようにする
+		client.Inputs = client.Inputs[:0]
+	}
+}
+
+// DecryptingSecretManager は暗号化コンテキストが一致する場合だけ復号できるシークレットマネージャー
+type DecryptingSecretManager struct {
+	MockSecretManager
+	Required map[string]string
+	// Decrypts は復号呼び出しごとに渡された暗号化コンテキストを記録する
+	Decrypts []map[string]string
+	context  map[string]string
+}
+
+func (m *DecryptingSecretManager) SetEncryptionContext(encryptionContext map[string]string) {
+	m.context = encryptionContext
+}
+
+func (m *DecryptingSecretManager) GetSecret(secretName string) (string, error) {
+	m.Decrypts = append(m.Decrypts, m.context)
+	if !reflect.DeepEqual(m.context, m.Required) {
+		return "", errors.New("AccessDeniedException: the encryption context does not match")
+	}
+	return m.MockSecretManager.GetSecret(secretName)
+}
+
+func TestApplication_Run_EncryptionContext(t *testing.T) {
+	required := map[string]string{"app": "billing", "env": "prod"}
+	sm := &DecryptingSecretManager{
+		MockSecretManager: MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}},
+		Required:          required,
+	}
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: sm,
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--encryption-context", "app=billing", "--encryption-context", "env=prod"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// 指定した暗号化コンテキストが復号呼び出しに渡される
+	if len(sm.Decrypts) != 1 || !reflect.DeepEqual(sm.Decrypts[0], required) {
+		t.Errorf("decrypt contexts = %v, want [%v]", sm.Decrypts, required)
+	}
+	if !strings.Contains(strings.Join(mockRunner.ExecutedCommands[0].Env, "\n"), "DB_USER=admin") {
+		t.Error("Expected DB_USER=admin in env")
+	}
+
+	// コンテキストが不足すると復号できない
+	sm.Decrypts, sm.context = nil, nil
+	app.Args = []string{"program", "/usr/bin/env", "--key", "db", "--encryption-context", "app=billing"}
+	if err := app.Run(); err == nil {
+		t.Error("Expected error for a mismatched encryption context")
+	}
+
+	// 暗号化コンテキストに対応しないバックエンドではデバッグログを出す
+	logger := &MockLogger{}
+	app = &Application{
+		Logger:        logger,
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}},
+		CommandRunner: &MockCommandRunner{},
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--encryption-context", "app=billing", "--log-level", "debug"},
+	}
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	found := false
+	for _, log := range logger.Logs {
+		if log.Level == "debug" && log.Message == "Secret backend ignores encryption context" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected a debug log for a backend without encryption context support")
+	}
+
+	// KEY=VALUE形式でなければエラー
+	if _, err := parseArgs([]string{"program", "/usr/bin/env", "--encryption-context", "app"}); err == nil {
+		t.Error("Expected error for an encryption context without =")
+	}
+}
+
+func TestAWSSecretManager_FetchSecret(t *testing.T) {
+	client := &MockSecretsManagerClient{
+		Secrets:  map[string]string{"db": `{"DB_USER":"admin"}`},
+		Binaries: map[string][]byte{"keystore": {0x00, 0xff, 0x10}},
+	}
+	calls := 0
+	sm := newTestAWSSecretManager(client, &calls)
+
+	tests := []struct {
+		name    string
+		secret  string
+		version SecretVersion
+		want    SecretValue
+	}{
+		{
+			name:   "文字列",
+			secret: "db",
+			want:   SecretValue{String: `{"DB_USER":"admin"}`, VersionID: "v-db", Stages: []string{"AWSCURRENT"}, Source: SourceSecretsManager},
+		},
+		{
+			name:    "ステージ指定",
+			secret:  "db",
+			version: SecretVersion{Stage: "AWSPREVIOUS"},
+			want:    SecretValue{String: `{"DB_USER":"admin"}`, VersionID: "v-db", Stages: []string{"AWSPREVIOUS"}, Source: SourceSecretsManager},
+		},
+		{
+			// バイナリは生のバイト列と、binary modeで描画した文字列の両方を持つ
+			name:   "バイナリ",
+			secret: "keystore",
+			want:   SecretValue{String: "AP8Q", Binary: []byte{0x00, 0xff, 0x10}, VersionID: "v-keystore", Stages: []string{"AWSCURRENT"}, Source: SourceSecretsManager},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sm.FetchSecret(tt.secret, tt.version)
+			if err != nil {
+				t.Fatalf("FetchSecret() error = %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("FetchSecret() = %+v, want %+v", *got, tt.want)
+			}
+
+			// GetSecretVersionは同じ文字列を返す
+			s, err := sm.GetSecretVersion(tt.secret, tt.version)
+			if err != nil || s != tt.want.String {
+				t.Errorf("GetSecretVersion() = %q, %v; want %q", s, err, tt.want.String)
+			}
+		})
+	}
+
+	// 事前取得したシークレットもバージョン情報を持つ
+	sm.Prefetch([]string{"db"})
+	got, err := sm.FetchSecret("db", SecretVersion{})
+	if err != nil || got.VersionID != "v-db" || !reflect.DeepEqual(got.Stages, []string{"AWSCURRENT"}) {
+		t.Errorf("FetchSecret() after Prefetch = %+v, %v", got, err)
+	}
+}
+
+func TestFetchSecret_WrapsGetSecret(t *testing.T) {
+	sm := &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}}
+
+	// SecretFetcherを実装しないマネージャーは文字列だけを持つSecretValueになる
+	got, err := fetchSecret(sm, "db", SecretVersion{})
+	if err != nil {
+		t.Fatalf("fetchSecret() error = %v", err)
+	}
+	want := SecretValue{String: `{"DB_USER":"admin"}`, Source: sourceOf(sm)}
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("fetchSecret() = %+v, want %+v", *got, want)
+	}
+
+	// バージョン指定には対応しない
+	if _, err := fetchSecret(sm, "db", SecretVersion{ID: "v-1"}); err == nil {
+		t.Error("Expected error for a version on a manager without version support")
+	}
+}
+
+func TestApplication_Run_LogsSecretVersion(t *testing.T) {
+	client := &MockSecretsManagerClient{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}}
+	calls := 0
+	logger := &MockLogger{}
+	app := &Application{
+		Logger:        logger,
+		SecretManager: newTestAWSSecretManager(client, &calls),
+		CommandRunner: &MockCommandRunner{},
+		Args:          []string{"program", "/usr/bin/env", "--key", "db"},
+	}
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 取得したバージョンIDとステージがログに残る
+	for _, log := range logger.Logs {
+		if log.Message != "Retrieved secret keys" {
+			continue
+		}
+		data := log.Data.(map[string]interface{})
+		if data["versionId"] != "v-db" || !reflect.DeepEqual(data["stages"], []string{"AWSCURRENT"}) {
+			t.Errorf("Retrieved secret keys = %v, want versionId v-db and stages [AWSCURRENT]", data)
+		}
+		return
+	}
+	t.Error("Expected a Retrieved secret keys log entry")
+}
+
+func TestAWSSecretManager_RetriesOnlyInOwnLoop(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	tests := []struct {
+		name         string
+		maxRetries   string
+		wantRequests int
+	}{
+		{name: "リトライなし", maxRetries: "0", wantRequests: 1},
+		{name: "2回リトライ", maxRetries: "2", wantRequests: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 常に一時的なエラーを返すエンドポイント
+			var requests int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, `{"__type":"InternalServiceError","message":"try again"}`)
+			}))
+			defer srv.Close()
+
+			app := &Application{
+				Logger:        &MockLogger{},
+				SecretManager: NewAWSSecretManager(),
+				CommandRunner: &MockCommandRunner{},
+				Args: []string{"program", "/usr/bin/env", "--key", "db", "--max-retries", tt.maxRetries, "--retry-base-delay", "1ms",
+					"--endpoint-url", srv.URL, "--region", "us-east-1", "--aws-shared-config-disable"},
+			}
+			if err := app.Run(); err == nil {
+				t.Fatal("Expected error from a failing endpoint")
+			}
+
+			// SDKのリトライは重ならず、--max-retriesの回数だけ再送する
+			if got := atomic.LoadInt32(&requests); int(got) != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestAWSSecretManager_RetryDelayStopsOnCancel(t *testing.T) {
+	client := &FlakySecretsManagerClient{
+		MockSecretsManagerClient: MockSecretsManagerClient{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}},
+		Errors: []error{
+			&smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"},
+		},
+	}
+	calls := 0
+	sm := newTestAWSSecretManager(client, &calls)
+	WithRetries(3, time.Hour)(sm)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	sm.SetContext(ctx)
+
+	// 待機中にコンテキストが終了すると、バックオフを待たずに返る
+	start := time.Now()
+	_, err := sm.GetSecret("db")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("GetSecret() took %s, expected it to stop at the deadline", elapsed)
+	}
+	if client.Calls != 1 {
+		t.Errorf("Expected 1 call, got: %d", client.Calls)
+	}
+}
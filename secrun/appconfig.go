@@ -0,0 +1,101 @@
+package secrun
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/appconfigdata"
+)
+
+// appConfigDataAPI is the subset of the AppConfig Data client used by AppConfigManager
+type appConfigDataAPI interface {
+	StartConfigurationSession(ctx context.Context, params *appconfigdata.StartConfigurationSessionInput, optFns ...func(*appconfigdata.Options)) (*appconfigdata.StartConfigurationSessionOutput, error)
+	GetLatestConfiguration(ctx context.Context, params *appconfigdata.GetLatestConfigurationInput, optFns ...func(*appconfigdata.Options)) (*appconfigdata.GetLatestConfigurationOutput, error)
+}
+
+// AppConfigManager implements SecretManager by reading AWS AppConfig configuration profiles
+type AppConfigManager struct {
+	ctx context.Context
+	// loadConfig loads the AWS configuration the client is built from
+	loadConfig func() (aws.Config, error)
+
+	once   sync.Once
+	client appConfigDataAPI
+	err    error
+}
+
+// NewAppConfigManager creates a new AppConfigManager
+func NewAppConfigManager() *AppConfigManager {
+	m := &AppConfigManager{
+		ctx: context.Background(),
+	}
+	m.loadConfig = func() (aws.Config, error) {
+		cfg, err := config.LoadDefaultConfig(m.ctx)
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("failed to load AWS config: %w", err)
+		}
+		return cfg, nil
+	}
+	return m
+}
+
+// SetContext sets the context used for subsequent requests
+func (m *AppConfigManager) SetContext(ctx context.Context) {
+	m.ctx = ctx
+}
+
+// Source reports the backend label of AppConfigManager
+func (m *AppConfigManager) Source() string {
+	return SourceAppConfig
+}
+
+// getClient lazily creates the AppConfig Data client from the default AWS config
+func (m *AppConfigManager) getClient() (appConfigDataAPI, error) {
+	m.once.Do(func() {
+		if m.client != nil {
+			return
+		}
+		cfg, err := m.loadConfig()
+		if err != nil {
+			m.err = err
+			return
+		}
+		m.client = appconfigdata.NewFromConfig(cfg)
+	})
+	return m.client, m.err
+}
+
+// GetSecret retrieves the content of a configuration profile named application/environment/profile
+func (m *AppConfigManager) GetSecret(secretName string) (string, error) {
+	parts := strings.Split(secretName, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", fmt.Errorf("invalid AppConfig identifier %q: expected application/environment/profile", secretName)
+	}
+
+	client, err := m.getClient()
+	if err != nil {
+		return "", err
+	}
+
+	session, err := client.StartConfigurationSession(m.ctx, &appconfigdata.StartConfigurationSessionInput{
+		ApplicationIdentifier:          aws.String(parts[0]),
+		EnvironmentIdentifier:          aws.String(parts[1]),
+		ConfigurationProfileIdentifier: aws.String(parts[2]),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to start configuration session: %w", err)
+	}
+
+	result, err := client.GetLatestConfiguration(m.ctx, &appconfigdata.GetLatestConfigurationInput{
+		ConfigurationToken: session.InitialConfigurationToken,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get configuration: %w", err)
+	}
+
+	return string(result.Configuration), nil
+}
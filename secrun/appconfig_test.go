@@ -0,0 +1,95 @@
+package secrun
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/appconfigdata"
+)
+
+// MockAppConfigDataClient はAppConfig Dataクライアントのモック実装
+type MockAppConfigDataClient struct {
+	Content  map[string]string
+	Sessions []appconfigdata.StartConfigurationSessionInput
+}
+
+// StartConfigurationSession は識別子をトークンとして返す
+func (c *MockAppConfigDataClient) StartConfigurationSession(ctx context.Context, params *appconfigdata.StartConfigurationSessionInput, optFns ...func(*appconfigdata.Options)) (*appconfigdata.StartConfigurationSessionOutput, error) {
+	c.Sessions = append(c.Sessions, *params)
+	token := aws.ToString(params.ApplicationIdentifier) + "/" + aws.ToString(params.EnvironmentIdentifier) + "/" + aws.ToString(params.ConfigurationProfileIdentifier)
+	return &appconfigdata.StartConfigurationSessionOutput{InitialConfigurationToken: aws.String(token)}, nil
+}
+
+// GetLatestConfiguration はトークンに対応する内容を返す
+func (c *MockAppConfigDataClient) GetLatestConfiguration(ctx context.Context, params *appconfigdata.GetLatestConfigurationInput, optFns ...func(*appconfigdata.Options)) (*appconfigdata.GetLatestConfigurationOutput, error) {
+	return &appconfigdata.GetLatestConfigurationOutput{Configuration: []byte(c.Content[aws.ToString(params.ConfigurationToken)])}, nil
+}
+
+func TestAppConfigManager_GetSecret(t *testing.T) {
+	client := &MockAppConfigDataClient{
+		Content: map[string]string{"myapp/prod/flags": `{"FEATURE_X":"on"}`},
+	}
+	manager := NewAppConfigManager()
+	manager.client = client
+
+	got, err := manager.GetSecret("myapp/prod/flags")
+	if err != nil {
+		t.Fatalf("GetSecret() error = %v", err)
+	}
+	if got != `{"FEATURE_X":"on"}` {
+		t.Errorf("GetSecret() = %q, want the profile content", got)
+	}
+
+	// セッションに識別子が渡されていることを確認
+	session := client.Sessions[0]
+	if aws.ToString(session.ApplicationIdentifier) != "myapp" || aws.ToString(session.EnvironmentIdentifier) != "prod" || aws.ToString(session.ConfigurationProfileIdentifier) != "flags" {
+		t.Errorf("Unexpected session input: %+v", session)
+	}
+
+	// 不正な識別子はエラー
+	if _, err := manager.GetSecret("myapp/prod"); err == nil {
+		t.Error("Expected error for invalid identifier, got nil")
+	}
+}
+
+func TestApplication_Run_AppConfig(t *testing.T) {
+	manager := NewAppConfigManager()
+	manager.client = &MockAppConfigDataClient{
+		Content: map[string]string{"myapp/prod/flags": `{"FEATURE_X":"on","FEATURE_Y":"off"}`},
+	}
+	mockSecretManager := &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}}
+	mockRunner := &MockCommandRunner{}
+
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: mockSecretManager,
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--appconfig", "myapp/prod/flags", "--key", "db"},
+		Backends:      map[string]SecretManager{SourceAppConfig: manager},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// AppConfigの内容はSecrets Managerと同じように展開される
+	env := strings.Join(mockRunner.ExecutedCommands[0].Env, "\n")
+	for _, want := range []string{"FEATURE_X=on", "FEATURE_Y=off", "DB_USER=admin"} {
+		if !strings.Contains(env, want) {
+			t.Errorf("Expected environment variable %s", want)
+		}
+	}
+
+	// AppConfigのプロファイルはSecrets Managerに問い合わせない
+	if len(mockSecretManager.Calls) != 1 || mockSecretManager.Calls[0] != "db" {
+		t.Errorf("Expected only 'db' from Secrets Manager, got: %v", mockSecretManager.Calls)
+	}
+
+	// バックエンドが設定されていない場合はエラー
+	app.Backends = nil
+	if err := app.Run(); err == nil {
+		t.Error("Expected error without an AppConfig backend, got nil")
+	}
+}
@@ -0,0 +1,1047 @@
+package secrun
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// argParser separates AWSecRun options from the command and its arguments.
+// Options may appear anywhere on the command line, before or after the
+// command's own arguments, and take their value either as the next argument
+// or inline as --option=value. AWSecRun has no single-dash options, so -x and
+// -abc always belong to the command.
+type argParser struct {
+	argv []string
+	pos  int
+
+	// The option being parsed and its inline value, if any
+	name      string
+	inline    string
+	hasInline bool
+	consumed  bool
+
+	opts *Options
+	// last is the secret that per-secret options such as --prefix apply to
+	last *SecretSpec
+
+	// Options whose default is only valid together with another option
+	flattenSepSet bool
+	joinSepSet    bool
+	fileSuffixSet bool
+	cacheTTLSet   bool
+}
+
+// optionHandler records one AWSecRun option in p.opts
+type optionHandler func(p *argParser) error
+
+// parse consumes every remaining argument
+func (p *argParser) parse() error {
+	for ; p.pos < len(p.argv); p.pos++ {
+		if p.argv[p.pos] == "--" {
+			// Everything after -- belongs to the command verbatim
+			p.opts.Args = append(p.opts.Args, p.argv[p.pos+1:]...)
+			return nil
+		}
+		if err := p.parseOne(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseOne handles the argument at p.pos, passing anything that is not an
+// AWSecRun option, including an unknown --option=value, to the command
+func (p *argParser) parseOne() error {
+	raw := p.argv[p.pos]
+	p.name, p.inline, p.hasInline, p.consumed = raw, "", false, false
+	if strings.HasPrefix(raw, "--") {
+		if name, v, ok := strings.Cut(raw, "="); ok {
+			p.name, p.inline, p.hasInline = name, v, true
+		}
+	}
+
+	handler, ok := optionHandlers[p.name]
+	if !ok {
+		p.opts.Args = append(p.opts.Args, raw)
+		return nil
+	}
+	if err := handler(p); err != nil {
+		return err
+	}
+	if p.hasInline && !p.consumed {
+		return fmt.Errorf("%s does not take a value", p.name)
+	}
+	return nil
+}
+
+// value returns the inline value or the argument following the current option
+func (p *argParser) value() (string, error) {
+	if v, ok := p.optionalValue(); ok {
+		return v, nil
+	}
+	if p.pos+1 >= len(p.argv) {
+		return "", fmt.Errorf("%s requires a value", p.name)
+	}
+	p.pos++
+	return p.argv[p.pos], nil
+}
+
+// optionalValue returns the inline value of an option whose value may be omitted
+func (p *argParser) optionalValue() (string, bool) {
+	if !p.hasInline {
+		return "", false
+	}
+	p.consumed = true
+	return p.inline, true
+}
+
+// optionHandlers looks up the handler of each option in optionTable
+var optionHandlers = func() map[string]optionHandler {
+	handlers := map[string]optionHandler{}
+	for _, o := range optionTable {
+		for _, name := range o.names {
+			handlers[name] = o.handle
+		}
+	}
+	return handlers
+}()
+
+// optionTable lists every AWSecRun option with the handler that parses it
+var optionTable = []struct {
+	names  []string
+	handle optionHandler
+}{
+	{[]string{"--key"}, func(p *argParser) error {
+		if !p.hasInline && p.pos+1 >= len(p.argv) {
+			// A trailing --key without a name belongs to the command
+			p.opts.Args = append(p.opts.Args, p.name)
+			return nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.last = &SecretSpec{Name: v}
+		if ref, ok := strings.CutPrefix(v, "@"); ok {
+			// --key @ENVVAR fetches the secret named by the value of ENVVAR
+			if ref == "" {
+				return fmt.Errorf("invalid %s %q: expected @ENVVAR", p.name, v)
+			}
+			p.last.NameEnv = ref
+		}
+		p.opts.Secrets = append(p.opts.Secrets, p.last)
+		return nil
+	}},
+	{[]string{"--secret-name-template"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.last = &SecretSpec{Name: v, NameTemplate: v}
+		p.opts.Secrets = append(p.opts.Secrets, p.last)
+		return nil
+	}},
+	{[]string{"--param"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.last = &SecretSpec{Name: v, Source: SourceSSM}
+		p.opts.Secrets = append(p.opts.Secrets, p.last)
+		return nil
+	}},
+	{[]string{"--appconfig"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.last = &SecretSpec{Name: v, Source: SourceAppConfig}
+		p.opts.Secrets = append(p.opts.Secrets, p.last)
+		return nil
+	}},
+	{[]string{"--vault"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.last = &SecretSpec{Name: v, Source: SourceVault}
+		p.opts.Secrets = append(p.opts.Secrets, p.last)
+		return nil
+	}},
+	{[]string{"--gcp-secret"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.last = &SecretSpec{Name: v, Source: SourceGCP}
+		p.opts.Secrets = append(p.opts.Secrets, p.last)
+		return nil
+	}},
+	{[]string{"--azure-secret"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.last = &SecretSpec{Name: v, Source: SourceAzure}
+		p.opts.Secrets = append(p.opts.Secrets, p.last)
+		return nil
+	}},
+	{[]string{"--azure-vault-url"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.AzureVaultURL = v
+		return nil
+	}},
+	{[]string{"--extract"}, func(p *argParser) error {
+		if p.last == nil {
+			return fmt.Errorf("%s must follow --key", p.name)
+		}
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		pointer, prefix, ok := strings.Cut(v, "=")
+		if !ok {
+			return fmt.Errorf("invalid %s %q: expected POINTER=PREFIX", p.name, v)
+		}
+		p.last.Extracts = append(p.last.Extracts, Extraction{Pointer: pointer, Prefix: prefix})
+		return nil
+	}},
+	{[]string{"--select", "--as"}, func(p *argParser) error {
+		if p.last == nil {
+			return fmt.Errorf("%s must follow --key", p.name)
+		}
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		if v == "" {
+			return fmt.Errorf("invalid %s: must not be empty", p.name)
+		}
+		if p.name == "--select" {
+			p.last.Select = v
+		} else {
+			p.last.As = v
+		}
+		return nil
+	}},
+	{[]string{"--index"}, func(p *argParser) error {
+		if p.last == nil {
+			return fmt.Errorf("%s must follow --key", p.name)
+		}
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		sel, err := parseArrayIndex(v)
+		if err != nil {
+			return err
+		}
+		p.last.Index = sel
+		return nil
+	}},
+	{[]string{"--rename"}, func(p *argParser) error {
+		if p.last == nil {
+			return fmt.Errorf("%s must follow --key", p.name)
+		}
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		from, to, ok := strings.Cut(v, "=")
+		if !ok || from == "" || to == "" {
+			return fmt.Errorf("invalid %s %q: expected FROM=TO", p.name, v)
+		}
+		if p.last.Renames == nil {
+			p.last.Renames = map[string]string{}
+		}
+		p.last.Renames[from] = to
+		return nil
+	}},
+	{[]string{"--include", "--exclude"}, func(p *argParser) error {
+		if p.last == nil {
+			return fmt.Errorf("%s must follow --key", p.name)
+		}
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		if _, err := path.Match(v, ""); err != nil {
+			return fmt.Errorf("invalid %s %q: %w", p.name, v, err)
+		}
+		if p.name == "--include" {
+			p.last.Include = append(p.last.Include, v)
+		} else {
+			p.last.Exclude = append(p.last.Exclude, v)
+		}
+		return nil
+	}},
+	{[]string{"--prefix"}, func(p *argParser) error {
+		if p.last == nil {
+			return fmt.Errorf("%s must follow --key", p.name)
+		}
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.last.Prefix = v
+		return nil
+	}},
+	{[]string{"--version-id", "--version-stage"}, func(p *argParser) error {
+		if p.last == nil {
+			return fmt.Errorf("%s must follow --key", p.name)
+		}
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		if p.name == "--version-id" {
+			p.last.Version.ID = v
+		} else {
+			p.last.Version.Stage = v
+		}
+		if p.last.Version.ID != "" && p.last.Version.Stage != "" {
+			return fmt.Errorf("--version-id and --version-stage cannot both be set for secret %s", p.last.Name)
+		}
+		return nil
+	}},
+	{[]string{"--inject-secret-date"}, func(p *argParser) error {
+		if p.last == nil {
+			return fmt.Errorf("%s must follow --key", p.name)
+		}
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.last.InjectDate = v
+		return nil
+	}},
+	{[]string{"--region"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.Region = v
+		return nil
+	}},
+	{[]string{"--aws-config-file"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.AWSConfigFile = v
+		return nil
+	}},
+	{[]string{"--aws-credentials-file"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.AWSCredentialsFile = v
+		return nil
+	}},
+	{[]string{"--profile"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.Profile = v
+		return nil
+	}},
+	{[]string{"--fallback-stage"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		if v == "" {
+			return fmt.Errorf("%s must not be empty", p.name)
+		}
+		p.opts.FallbackStage = v
+		return nil
+	}},
+	{[]string{"--endpoint-url"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		if u, err := url.Parse(v); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid %s %q: expected an http or https URL", p.name, v)
+		}
+		p.opts.EndpointURL = v
+		return nil
+	}},
+	{[]string{"--aws-shared-config-disable"}, func(p *argParser) error {
+		p.opts.NoSharedConfig = true
+		return nil
+	}},
+	{[]string{"--timeout"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid %s %q: expected a non-negative duration such as 30s", p.name, v)
+		}
+		p.opts.Timeout = d
+		return nil
+	}},
+	{[]string{"--max-retries"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid %s %q: expected a non-negative integer", p.name, v)
+		}
+		p.opts.MaxRetries = n
+		return nil
+	}},
+	{[]string{"--retry-base-delay"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid %s %q: expected a non-negative duration such as 200ms", p.name, v)
+		}
+		p.opts.RetryBaseDelay = d
+		return nil
+	}},
+	{[]string{"--binary-mode"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		if v != BinaryModeBase64 && v != BinaryModeFile {
+			return fmt.Errorf("invalid %s %q: expected base64 or file", p.name, v)
+		}
+		p.opts.BinaryMode = v
+		return nil
+	}},
+	{[]string{"--on-nul-byte"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		if v != NulByteError && v != NulByteFile {
+			return fmt.Errorf("invalid %s %q: expected error or file", p.name, v)
+		}
+		p.opts.OnNulByte = v
+		return nil
+	}},
+	{[]string{"--file-threshold"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		n, err := parseByteSize(v)
+		if err != nil || n == 0 {
+			return fmt.Errorf("invalid %s %q: expected a positive size such as 64KB", p.name, v)
+		}
+		p.opts.FileThreshold = n
+		return nil
+	}},
+	{[]string{"--file-suffix"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		if v == "" {
+			return fmt.Errorf("%s must not be empty", p.name)
+		}
+		p.opts.FileSuffix = v
+		p.fileSuffixSet = true
+		return nil
+	}},
+	{[]string{"--fail-on-empty"}, func(p *argParser) error {
+		p.opts.FailOnEmpty = true
+		return nil
+	}},
+	{[]string{"--on-conflict"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		if v != ConflictLast && v != ConflictFirst && v != ConflictError {
+			return fmt.Errorf("invalid %s %q: expected error, last or first", p.name, v)
+		}
+		p.opts.OnConflict = v
+		return nil
+	}},
+	{[]string{"--flatten"}, func(p *argParser) error {
+		p.opts.Flatten = true
+		return nil
+	}},
+	{[]string{"--flatten-sep"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		if v == "" {
+			return fmt.Errorf("%s must not be empty", p.name)
+		}
+		p.opts.FlattenSep = v
+		p.flattenSepSet = true
+		return nil
+	}},
+	{[]string{"--on-json-array-root"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		if v != ArrayRootBlob && v != ArrayRootIndex && v != ArrayRootJoin {
+			return fmt.Errorf("invalid %s %q: expected blob, index or join", p.name, v)
+		}
+		p.opts.ArrayRoot = v
+		return nil
+	}},
+	{[]string{"--join-sep"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		if v == "" {
+			return fmt.Errorf("%s must not be empty", p.name)
+		}
+		p.opts.JoinSep = v
+		p.joinSepSet = true
+		return nil
+	}},
+	{[]string{"--assume-role-arn"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.AssumeRoleARN = v
+		return nil
+	}},
+	{[]string{"--external-id"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.ExternalID = v
+		return nil
+	}},
+	{[]string{"--expect-hash"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		name, hash, ok := strings.Cut(v, "=")
+		if !ok || name == "" || hash == "" {
+			return fmt.Errorf("invalid %s %q: expected NAME=SHA256", p.name, v)
+		}
+		if p.opts.ExpectedHashes == nil {
+			p.opts.ExpectedHashes = map[string]string{}
+		}
+		p.opts.ExpectedHashes[name] = strings.ToLower(hash)
+		return nil
+	}},
+	{[]string{"--encryption-context"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		key, val, ok := strings.Cut(v, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid %s %q: expected KEY=VALUE", p.name, v)
+		}
+		if p.opts.EncryptionContext == nil {
+			p.opts.EncryptionContext = map[string]string{}
+		}
+		p.opts.EncryptionContext[key] = val
+		return nil
+	}},
+	{[]string{"--audit-file"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.AuditFile = v
+		return nil
+	}},
+	{[]string{"--cache-dir"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.CacheDir = v
+		return nil
+	}},
+	{[]string{"--cache-ttl"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid %s %q: expected a positive duration such as 15m", p.name, v)
+		}
+		p.opts.CacheTTL = d
+		p.cacheTTLSet = true
+		return nil
+	}},
+	{[]string{"--fetch-report"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.FetchReport = v
+		return nil
+	}},
+	{[]string{commandFromSecretFlag}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.CommandFromSecret = v
+		return nil
+	}},
+	{[]string{"--lock-file"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.LockFile = v
+		return nil
+	}},
+	{[]string{"--lock-nonblocking"}, func(p *argParser) error {
+		p.opts.LockNonblocking = true
+		return nil
+	}},
+	{[]string{"--metrics-file"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.MetricsFile = v
+		return nil
+	}},
+	{[]string{"--require-secret-count"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid %s %q: expected a non-negative integer", p.name, v)
+		}
+		p.opts.RequireSecretCount = n
+		return nil
+	}},
+	{[]string{"--schema", "--validate-json-schema"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		name, path, ok := strings.Cut(v, "=")
+		if !ok || name == "" || path == "" {
+			return fmt.Errorf("invalid %s %q: expected NAME=SCHEMA_FILE", p.name, v)
+		}
+		if p.opts.Schemas == nil {
+			p.opts.Schemas = map[string]string{}
+		}
+		p.opts.Schemas[name] = path
+		return nil
+	}},
+	{[]string{"--env-uppercase-replace"}, func(p *argParser) error {
+		p.opts.NormalizeKeys = true
+		return nil
+	}},
+	{[]string{"--child-env-allow", "--command-env-allowlist"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		for _, pattern := range strings.Split(v, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				p.opts.EnvAllow = append(p.opts.EnvAllow, pattern)
+			}
+		}
+		return nil
+	}},
+	{[]string{"--clean-env"}, func(p *argParser) error {
+		p.opts.CleanEnv = true
+		return nil
+	}},
+	{[]string{"--no-inherit-path"}, func(p *argParser) error {
+		p.opts.NoInheritPath = true
+		return nil
+	}},
+	{[]string{"--pass-env"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.PassEnv = append(p.opts.PassEnv, v)
+		return nil
+	}},
+	{[]string{"--join"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		join, err := parseJoin(v)
+		if err != nil {
+			return err
+		}
+		p.opts.Joins = append(p.opts.Joins, join)
+		return nil
+	}},
+	{[]string{"--set"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		name, text, ok := strings.Cut(v, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("invalid %s %q: expected NAME=TEMPLATE", p.name, v)
+		}
+		if _, err := template.New("").Parse(text); err != nil {
+			return fmt.Errorf("invalid %s %s: %w", p.name, name, err)
+		}
+		p.opts.Sets = append(p.opts.Sets, EnvTemplate{Name: name, Template: text})
+		return nil
+	}},
+	{[]string{"--require"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		for _, key := range strings.Split(v, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				p.opts.RequiredKeys = append(p.opts.RequiredKeys, key)
+			}
+		}
+		return nil
+	}},
+	{[]string{"--workdir"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.Workdir = v
+		return nil
+	}},
+	{[]string{"--chroot", "--root-dir"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.Chroot = v
+		return nil
+	}},
+	{[]string{"--argv0"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.Argv0 = v
+		return nil
+	}},
+	{[]string{"--detach"}, func(p *argParser) error {
+		p.opts.Detach = true
+		return nil
+	}},
+	{[]string{"--detach-output"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.DetachOutput = v
+		return nil
+	}},
+	{[]string{"--pid-file"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.PIDFile = v
+		return nil
+	}},
+	{[]string{"--capture-output"}, func(p *argParser) error {
+		p.opts.CaptureOutput = true
+		return nil
+	}},
+	{[]string{"--command-output-json"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.OutputJSON = v
+		return nil
+	}},
+	{[]string{"--fail-if-empty-stdout"}, func(p *argParser) error {
+		p.opts.FailIfEmptyStdout = true
+		return nil
+	}},
+	{[]string{"--limit-output-bytes"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		n, err := parseByteSize(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", p.name, err)
+		}
+		p.opts.OutputLimit = n
+		return nil
+	}},
+	{[]string{"--dump-args-json"}, func(p *argParser) error {
+		p.opts.DumpArgs = true
+		return nil
+	}},
+	{[]string{"--write-env-file"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.EnvFile = v
+		return nil
+	}},
+	{[]string{"--stdin-from-file"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.StdinFile = v
+		return nil
+	}},
+	{[]string{"--merge-json", "--secret-json-merge"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.MergeJSON = v
+		return nil
+	}},
+	{[]string{"--systemd-creds"}, func(p *argParser) error {
+		p.opts.SystemdCreds = true
+		return nil
+	}},
+	{[]string{"--print-command"}, func(p *argParser) error {
+		p.opts.PrintCommand = true
+		return nil
+	}},
+	{[]string{"--dry-run"}, func(p *argParser) error {
+		p.opts.DryRun = true
+		return nil
+	}},
+	{[]string{"--show-values"}, func(p *argParser) error {
+		p.opts.ShowValues = true
+		return nil
+	}},
+	{[]string{"--yes"}, func(p *argParser) error {
+		p.opts.AssumeYes = true
+		return nil
+	}},
+	{[]string{"--command-retries"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid %s %q: expected a non-negative integer", p.name, v)
+		}
+		p.opts.CommandRetries = n
+		return nil
+	}},
+	{[]string{"--command-retry-delay"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid %s %q: expected a non-negative duration such as 1s", p.name, v)
+		}
+		p.opts.CommandRetryDelay = d
+		return nil
+	}},
+	{[]string{"--command-retry-jitter"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			return fmt.Errorf("invalid %s %q: expected a fraction between 0 and 1 such as 0.3", p.name, v)
+		}
+		p.opts.CommandRetryJitter = f
+		return nil
+	}},
+	{[]string{"--refetch-on-restart"}, func(p *argParser) error {
+		p.opts.RefetchOnRestart = true
+		return nil
+	}},
+	{[]string{"--max-total-runtime-across-retries"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid %s %q: expected a non-negative duration such as 5m", p.name, v)
+		}
+		p.opts.MaxTotalRuntime = d
+		return nil
+	}},
+	{[]string{"--kill-timeout"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid %s %q: expected a non-negative duration such as 10s", p.name, v)
+		}
+		p.opts.KillTimeout = d
+		return nil
+	}},
+	{[]string{"--sigterm-exit", "--sigterm-to-exit-code"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 255 {
+			return fmt.Errorf("invalid %s %q: expected an exit code between 0 and 255", p.name, v)
+		}
+		p.opts.SigtermExit = n
+		return nil
+	}},
+	{[]string{"--warn-unused-secrets"}, func(p *argParser) error {
+		p.opts.WarnUnusedSecrets = true
+		return nil
+	}},
+	{[]string{"--abort-on-warning"}, func(p *argParser) error {
+		p.opts.AbortOnWarning = true
+		return nil
+	}},
+	{[]string{"--json-logs-buffered"}, func(p *argParser) error {
+		p.opts.BufferedLogs = true
+		return nil
+	}},
+	{[]string{"--json-log-escape-html"}, func(p *argParser) error {
+		escape := true
+		if v, ok := p.optionalValue(); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q: expected true or false", p.name, v)
+			}
+			escape = b
+		}
+		p.opts.DisableHTMLEscape = !escape
+		return nil
+	}},
+	{[]string{"--log-output"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.LogOutput = v
+		return nil
+	}},
+	{[]string{"--log-level"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		if _, ok := logLevels[v]; !ok {
+			return fmt.Errorf("invalid %s %q: expected error, warn, info or debug", p.name, v)
+		}
+		p.opts.LogLevel = v
+		return nil
+	}},
+	{[]string{"--verbose"}, func(p *argParser) error {
+		p.opts.Verbose = true
+		p.opts.LogLevel = "debug"
+		return nil
+	}},
+	{[]string{"--log-format"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		if v != LogFormatJSON && v != LogFormatText {
+			return fmt.Errorf("invalid %s %q: expected json or text", p.name, v)
+		}
+		p.opts.LogFormat = v
+		return nil
+	}},
+	{[]string{"--log-level-case"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		if v != LogLevelCaseLower && v != LogLevelCaseUpper {
+			return fmt.Errorf("invalid %s %q: expected upper or lower", p.name, v)
+		}
+		p.opts.LogLevelCase = v
+		return nil
+	}},
+	{[]string{"--log-sample", "--log-sampling"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		n, err := parseSampleRate(v)
+		if err != nil {
+			return err
+		}
+		p.opts.LogSample = n
+		return nil
+	}},
+	{[]string{"--redact-logs"}, func(p *argParser) error {
+		p.opts.RedactLogs = true
+		return nil
+	}},
+	{[]string{"--scrub-output"}, func(p *argParser) error {
+		p.opts.ScrubOutput = true
+		return nil
+	}},
+	{[]string{"--mask-char"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		if utf8.RuneCountInString(v) != 1 {
+			return fmt.Errorf("invalid %s %q: expected a single character", p.name, v)
+		}
+		p.opts.Mask.Char = v
+		return nil
+	}},
+	{[]string{"--mask-length"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid %s %q: expected a positive integer", p.name, v)
+		}
+		p.opts.Mask.Length = n
+		return nil
+	}},
+	{[]string{"--mask-label"}, func(p *argParser) error {
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.opts.Mask.Label = v
+		return nil
+	}},
+}
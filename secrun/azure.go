@@ -0,0 +1,90 @@
+package secrun
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+)
+
+// azureVaultURLEnv holds the vault URL used when --azure-vault-url is not given
+const azureVaultURLEnv = "AZURE_KEYVAULT_URL"
+
+// AzureSecretManager implements SecretManager using Azure Key Vault
+type AzureSecretManager struct {
+	ctx context.Context
+	// VaultURL defaults to AZURE_KEYVAULT_URL, e.g. https://myvault.vault.azure.net
+	VaultURL string
+	// newClient builds the Key Vault client, authenticated with DefaultAzureCredential by default
+	newClient func(vaultURL string) (*azsecrets.Client, error)
+
+	once   sync.Once
+	client *azsecrets.Client
+	err    error
+}
+
+// NewAzureSecretManager creates an AzureSecretManager authenticated with DefaultAzureCredential
+func NewAzureSecretManager() *AzureSecretManager {
+	return &AzureSecretManager{
+		ctx:      context.Background(),
+		VaultURL: os.Getenv(azureVaultURLEnv),
+		newClient: func(vaultURL string) (*azsecrets.Client, error) {
+			cred, err := azidentity.NewDefaultAzureCredential(nil)
+			if err != nil {
+				return nil, err
+			}
+			return azsecrets.NewClient(vaultURL, cred, nil)
+		},
+	}
+}
+
+// SetContext sets the context used for subsequent requests
+func (m *AzureSecretManager) SetContext(ctx context.Context) {
+	m.ctx = ctx
+}
+
+// Source reports the backend label of AzureSecretManager
+func (m *AzureSecretManager) Source() string {
+	return SourceAzure
+}
+
+// getClient lazily creates the Key Vault client
+func (m *AzureSecretManager) getClient() (*azsecrets.Client, error) {
+	m.once.Do(func() {
+		if m.VaultURL == "" {
+			m.err = fmt.Errorf("Azure Key Vault URL is not set: use --azure-vault-url or %s", azureVaultURLEnv)
+			return
+		}
+		m.client, m.err = m.newClient(m.VaultURL)
+		if m.err != nil {
+			m.err = fmt.Errorf("failed to create Azure Key Vault client: %w", m.err)
+		}
+	})
+	return m.client, m.err
+}
+
+// GetSecret reads the secret NAME, or NAME/VERSION for a specific version
+func (m *AzureSecretManager) GetSecret(secretName string) (string, error) {
+	name, version, _ := strings.Cut(strings.Trim(secretName, "/"), "/")
+	if name == "" || strings.Contains(version, "/") {
+		return "", fmt.Errorf("invalid Azure secret name %q: expected NAME[/VERSION]", secretName)
+	}
+	client, err := m.getClient()
+	if err != nil {
+		return "", err
+	}
+
+	// An empty version reads the latest one
+	resp, err := client.GetSecret(m.ctx, name, version, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get Azure secret: %w", err)
+	}
+	if resp.Value == nil {
+		return "", fmt.Errorf("Azure secret %s has no value", secretName)
+	}
+	return *resp.Value, nil
+}
@@ -0,0 +1,137 @@
+package secrun
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+)
+
+// fakeAzureCredential は固定のトークンを返す
+type fakeAzureCredential struct{}
+
+func (fakeAzureCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "test-token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// fakeAzureTransport はKey VaultのGetSecretを模倣するトランスポート
+type fakeAzureTransport struct {
+	// secrets は "NAME" または "NAME/VERSION" をキーとする
+	secrets  map[string]string
+	requests []string
+}
+
+func (f *fakeAzureTransport) Do(req *http.Request) (*http.Response, error) {
+	respond := func(status int, header http.Header, body string) (*http.Response, error) {
+		if header == nil {
+			header = http.Header{}
+		}
+		header.Set("Content-Type", "application/json")
+		return &http.Response{StatusCode: status, Header: header, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	}
+
+	// 最初はトークンなしで送られるので、チャレンジを返す
+	if req.Header.Get("Authorization") == "" {
+		header := http.Header{}
+		header.Set("WWW-Authenticate", `Bearer authorization="https://login.microsoftonline.com/tenant" resource="https://vault.azure.net"`)
+		return respond(http.StatusUnauthorized, header, "")
+	}
+
+	key := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/secrets/"), "/")
+	f.requests = append(f.requests, key)
+	value, ok := f.secrets[key]
+	if !ok {
+		return respond(http.StatusNotFound, nil, `{"error":{"code":"SecretNotFound","message":"not found"}}`)
+	}
+	body, _ := json.Marshal(map[string]string{"value": value, "id": "https://test.vault.azure.net/secrets/" + key})
+	return respond(http.StatusOK, nil, string(body))
+}
+
+// newTestAzureSecretManager はフェイクのトランスポートを使うAzureSecretManagerを返す
+func newTestAzureSecretManager(transport *fakeAzureTransport) *AzureSecretManager {
+	m := NewAzureSecretManager()
+	m.VaultURL = "https://test.vault.azure.net"
+	m.newClient = func(vaultURL string) (*azsecrets.Client, error) {
+		return azsecrets.NewClient(vaultURL, fakeAzureCredential{}, &azsecrets.ClientOptions{
+			ClientOptions: azcore.ClientOptions{Transport: transport},
+		})
+	}
+	return m
+}
+
+func TestAzureSecretManager_GetSecret(t *testing.T) {
+	transport := &fakeAzureTransport{secrets: map[string]string{
+		"db":        `{"DB_USER":"admin"}`,
+		"db/v1":     `{"DB_USER":"old"}`,
+		"api-token": "plain-token",
+	}}
+	m := newTestAzureSecretManager(transport)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"db", `{"DB_USER":"admin"}`},
+		{"db/v1", `{"DB_USER":"old"}`},
+		{"api-token", "plain-token"},
+	}
+	for _, tt := range tests {
+		got, err := m.GetSecret(tt.name)
+		if err != nil {
+			t.Fatalf("GetSecret(%q) error = %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("GetSecret(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := m.GetSecret("missing"); err == nil {
+		t.Error("Expected error for a missing secret, got nil")
+	}
+	if _, err := m.GetSecret("a/b/c"); err == nil {
+		t.Error("Expected error for an invalid name, got nil")
+	}
+
+	// URLがなければエラー
+	unset := NewAzureSecretManager()
+	unset.VaultURL = ""
+	if _, err := unset.GetSecret("db"); err == nil || !strings.Contains(err.Error(), azureVaultURLEnv) {
+		t.Errorf("Expected error naming %s, got: %v", azureVaultURLEnv, err)
+	}
+}
+
+func TestApplication_Run_AzureSecret(t *testing.T) {
+	transport := &fakeAzureTransport{secrets: map[string]string{"db": `{"DB_USER":"admin","DB_PASSWORD":"from-azure"}`}}
+	azure := newTestAzureSecretManager(transport)
+
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{},
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--azure-secret", "db", "--prefix", "APP_", "--azure-vault-url", "https://other.vault.azure.net"},
+		Backends:      map[string]SecretManager{SourceAzure: azure},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// --azure-vault-urlが環境変数より優先される
+	if azure.VaultURL != "https://other.vault.azure.net" {
+		t.Errorf("VaultURL = %q, want the --azure-vault-url value", azure.VaultURL)
+	}
+	// JSONのシークレットはキーごとに展開される
+	env := strings.Join(mockRunner.ExecutedCommands[0].Env, "\n")
+	for _, want := range []string{"APP_DB_USER=admin", "APP_DB_PASSWORD=from-azure"} {
+		if !strings.Contains(env, want) {
+			t.Errorf("Expected %s, got: %s", want, env)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package secrun
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+// maxBatchSecrets is the most secrets one BatchGetSecretValue call accepts
+const maxBatchSecrets = 20
+
+// SecretPrefetcher is implemented by secret managers that can fetch several secrets
+// in one round trip ahead of the GetSecret calls for them
+type SecretPrefetcher interface {
+	Prefetch(secretNames []string)
+}
+
+// Prefetch fetches the current versions of secretNames with BatchGetSecretValue, 20 at a
+// time, for GetSecret to return. Secrets missing from a batch response, and every secret of
+// a batch call that fails, e.g. where the API is unavailable, are fetched by GetSecret as usual.
+func (sm *AWSSecretManager) Prefetch(secretNames []string) {
+	cfg, err := sm.LoadConfig()
+	if err != nil {
+		// GetSecret reports the error for each secret
+		return
+	}
+	svc := sm.getClient(cfg)
+
+	for start := 0; start < len(secretNames); start += maxBatchSecrets {
+		batch := secretNames[start:min(start+maxBatchSecrets, len(secretNames))]
+
+		begin := time.Now()
+		result, err := svc.BatchGetSecretValue(sm.ctx, &secretsmanager.BatchGetSecretValueInput{
+			SecretIdList: batch,
+		})
+		latency := time.Since(begin)
+		// The individual fetches still succeed without batch access, e.g. under a policy
+		// granting only secretsmanager:GetSecretValue, so falling back is not a warning
+		if err != nil {
+			sm.log("info", "Batch secret fetch failed, fetching secrets individually", map[string]interface{}{
+				"secretNames": batch,
+				"error":       err.Error(),
+			})
+			continue
+		}
+
+		for _, e := range result.Errors {
+			sm.log("info", "Batch secret fetch failed for secret, fetching it individually", map[string]string{
+				"secretName": aws.ToString(e.SecretId),
+				"errorCode":  aws.ToString(e.ErrorCode),
+			})
+		}
+
+		requestID, _ := awsmiddleware.GetRequestIDMetadata(result.ResultMetadata)
+		sm.mu.Lock()
+		if sm.prefetched == nil {
+			sm.prefetched = map[string]types.SecretValueEntry{}
+		}
+		// Entries carry the secret's name and ARN, either of which may have been requested
+		for _, name := range batch {
+			for _, entry := range result.SecretValues {
+				if aws.ToString(entry.Name) != name && aws.ToString(entry.ARN) != name {
+					continue
+				}
+				sm.prefetched[name] = entry
+				sm.metadata[name] = SecretMetadata{
+					CreatedDate: aws.ToTime(entry.CreatedDate),
+					RequestID:   requestID,
+					LatencyMs:   latency.Milliseconds(),
+				}
+				break
+			}
+		}
+		sm.mu.Unlock()
+	}
+}
+
+// takePrefetched returns and forgets the prefetched current version of a secret
+func (sm *AWSSecretManager) takePrefetched(secretName string) (types.SecretValueEntry, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	entry, ok := sm.prefetched[secretName]
+	delete(sm.prefetched, secretName)
+	return entry, ok
+}
+
+// batchableSecrets returns the distinct names of the Secrets Manager secrets requested at
+// their current version, in command-line order, which can be fetched in a batch
+func batchableSecrets(opts *Options) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, spec := range opts.Secrets {
+		if spec.Source != "" && spec.Source != SourceSecretsManager {
+			continue
+		}
+		if spec.Version != (SecretVersion{}) || seen[spec.Name] {
+			continue
+		}
+		seen[spec.Name] = true
+		names = append(names, spec.Name)
+	}
+	return names
+}
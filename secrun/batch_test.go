@@ -0,0 +1,188 @@
+package secrun
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/smithy-go"
+)
+
+// PartialBatchSecretsManagerClient はFailedのシークレットだけバッチ取得に失敗するクライアント
+type PartialBatchSecretsManagerClient struct {
+	MockSecretsManagerClient
+	Failed map[string]bool
+}
+
+// BatchGetSecretValue はFailedのシークレットをErrorsに入れて返す
+func (c *PartialBatchSecretsManagerClient) BatchGetSecretValue(ctx context.Context, params *secretsmanager.BatchGetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.BatchGetSecretValueOutput, error) {
+	output, err := c.MockSecretsManagerClient.BatchGetSecretValue(ctx, params, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	var values []types.SecretValueEntry
+	for _, entry := range output.SecretValues {
+		if c.Failed[aws.ToString(entry.Name)] {
+			output.Errors = append(output.Errors, types.APIErrorType{
+				SecretId:  entry.Name,
+				ErrorCode: aws.String("InternalServiceError"),
+			})
+			continue
+		}
+		values = append(values, entry)
+	}
+	output.SecretValues = values
+	return output, nil
+}
+
+func TestApplication_Run_BatchFetch(t *testing.T) {
+	secrets := map[string]string{
+		"db":    `{"DB_USER":"admin","LOG_LEVEL":"debug"}`,
+		"api":   `{"API_KEY":"xyz"}`,
+		"cache": `{"CACHE_HOST":"redis","LOG_LEVEL":"warn"}`,
+	}
+
+	tests := []struct {
+		name       string
+		client     secretsManagerAPI
+		wantSingle []string
+	}{
+		{
+			name:   "full success",
+			client: &MockSecretsManagerClient{Secrets: secrets},
+		},
+		{
+			name:       "partial failure",
+			client:     &PartialBatchSecretsManagerClient{MockSecretsManagerClient: MockSecretsManagerClient{Secrets: secrets}, Failed: map[string]bool{"api": true}},
+			wantSingle: []string{"api"},
+		},
+		{
+			name: "batch unsupported",
+			client: &MockSecretsManagerClient{
+				Secrets:    secrets,
+				BatchError: &smithy.GenericAPIError{Code: "UnknownOperationException", Message: "not supported"},
+			},
+			wantSingle: []string{"db", "api", "cache"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			mockRunner := &MockCommandRunner{}
+			app := &Application{
+				Logger:        &MockLogger{},
+				SecretManager: newTestAWSSecretManager(tt.client, &calls),
+				CommandRunner: mockRunner,
+				Args:          []string{"program", "/usr/bin/env", "--key", "db", "--key", "api", "--key", "cache"},
+			}
+
+			if err := app.Run(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			// 全シークレットを1回のバッチで要求し、取得できなかったものだけ個別に取得する
+			var mock *MockSecretsManagerClient
+			switch c := tt.client.(type) {
+			case *MockSecretsManagerClient:
+				mock = c
+			case *PartialBatchSecretsManagerClient:
+				mock = &c.MockSecretsManagerClient
+			}
+			if len(mock.BatchInputs) != 1 || strings.Join(mock.BatchInputs[0], ",") != "db,api,cache" {
+				t.Errorf("batch inputs = %v, want [[db api cache]]", mock.BatchInputs)
+			}
+			var single []string
+			for _, input := range mock.Inputs {
+				single = append(single, aws.ToString(input.SecretId))
+			}
+			if strings.Join(single, ",") != strings.Join(tt.wantSingle, ",") {
+				t.Errorf("GetSecretValue calls = %v, want %v", single, tt.wantSingle)
+			}
+
+			// コマンドライン順に後勝ちで結合される
+			env := strings.Join(mockRunner.ExecutedCommands[0].Env, "\n")
+			for _, want := range []string{"DB_USER=admin", "API_KEY=xyz", "CACHE_HOST=redis", "LOG_LEVEL=warn"} {
+				if !strings.Contains(env, want) {
+					t.Errorf("Expected %s in env", want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplication_Run_BatchFallbackIsNotAWarning(t *testing.T) {
+	secrets := map[string]string{"db": `{"DB_USER":"admin"}`, "api": `{"API_KEY":"xyz"}`}
+	tests := []struct {
+		name   string
+		client secretsManagerAPI
+	}{
+		{
+			name: "batch denied",
+			client: &MockSecretsManagerClient{
+				Secrets:    secrets,
+				BatchError: &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "not authorized to perform: secretsmanager:BatchGetSecretValue"},
+			},
+		},
+		{
+			name:   "partial failure",
+			client: &PartialBatchSecretsManagerClient{MockSecretsManagerClient: MockSecretsManagerClient{Secrets: secrets}, Failed: map[string]bool{"api": true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			mockRunner := &MockCommandRunner{}
+			app := &Application{
+				Logger:        &MockLogger{},
+				SecretManager: newTestAWSSecretManager(tt.client, &calls),
+				CommandRunner: mockRunner,
+				Args:          []string{"program", "/usr/bin/env", "--key", "db", "--key", "api", "--abort-on-warning"},
+			}
+
+			// 個別取得に成功すれば、バッチ取得の失敗で--abort-on-warningが中断しない
+			if err := app.Run(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			env := strings.Join(mockRunner.ExecutedCommands[0].Env, "\n")
+			for _, want := range []string{"DB_USER=admin", "API_KEY=xyz"} {
+				if !strings.Contains(env, want) {
+					t.Errorf("Expected %s in env", want)
+				}
+			}
+		})
+	}
+}
+
+func TestAWSSecretManager_PrefetchInBatchesOf20(t *testing.T) {
+	client := &MockSecretsManagerClient{Secrets: map[string]string{}}
+	var names []string
+	for i := 0; i < 25; i++ {
+		name := fmt.Sprintf("secret-%02d", i)
+		client.Secrets[name] = fmt.Sprintf(`{"N":"%d"}`, i)
+		names = append(names, name)
+	}
+	calls := 0
+	sm := newTestAWSSecretManager(client, &calls)
+
+	sm.Prefetch(names)
+
+	// 20件ずつに分けて要求する
+	if len(client.BatchInputs) != 2 || len(client.BatchInputs[0]) != 20 || len(client.BatchInputs[1]) != 5 {
+		t.Fatalf("batch sizes = %v, want 20 and 5", client.BatchInputs)
+	}
+	for i, name := range names {
+		got, err := sm.GetSecret(name)
+		if err != nil || got != fmt.Sprintf(`{"N":"%d"}`, i) {
+			t.Errorf("GetSecret(%s) = %q, %v", name, got, err)
+		}
+	}
+	if len(client.Inputs) != 0 {
+		t.Errorf("GetSecretValue calls = %d, want 0", len(client.Inputs))
+	}
+}
@@ -0,0 +1,272 @@
+package secrun
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Defaults for the on-disk secret cache
+const (
+	DefaultCacheTTL = 15 * time.Minute
+	// cachePassphraseEnv holds the passphrase the cache encryption key is derived from
+	cachePassphraseEnv = "AWSECRUN_CACHE_PASSPHRASE"
+)
+
+// Sizes of the fields stored in front of the ciphertext of a cache entry
+const (
+	cacheSaltSize = 16
+	cacheKeySize  = 32
+)
+
+// cacheEntry is the plaintext of a cached secret, with the version details and
+// metadata of the fetch so a cache hit reports the same as the backend did
+type cacheEntry struct {
+	FetchedAt time.Time       `json:"fetchedAt"`
+	Value     string          `json:"value"`
+	Binary    []byte          `json:"binary,omitempty"`
+	VersionID string          `json:"versionId,omitempty"`
+	Stages    []string        `json:"stages,omitempty"`
+	Metadata  *SecretMetadata `json:"metadata,omitempty"`
+}
+
+// CachingSecretManager serves secrets from encrypted files in Dir while they are
+// younger than TTL, and fetches and stores them through Inner otherwise
+type CachingSecretManager struct {
+	Inner SecretManager
+	// Backend is the source Inner serves, e.g. aws-sm; it namespaces the cache entries
+	Backend    string
+	Dir        string
+	TTL        time.Duration
+	Passphrase string
+	Logger     Logger
+	// Now is the clock used for expiry; nil uses time.Now
+	Now func() time.Time
+
+	mu       sync.Mutex
+	metadata map[string]SecretMetadata
+}
+
+// GetSecret returns the cached secret, or fetches and caches it
+func (c *CachingSecretManager) GetSecret(secretName string) (string, error) {
+	value, err := c.FetchSecret(secretName, SecretVersion{})
+	if err != nil {
+		return "", err
+	}
+	return value.String, nil
+}
+
+// GetSecretVersion returns the cached version of the secret, or fetches and caches it
+func (c *CachingSecretManager) GetSecretVersion(secretName string, version SecretVersion) (string, error) {
+	value, err := c.FetchSecret(secretName, version)
+	if err != nil {
+		return "", err
+	}
+	return value.String, nil
+}
+
+// FetchSecret returns the cached secret with its version details, or fetches and caches it
+func (c *CachingSecretManager) FetchSecret(secretName string, version SecretVersion) (*SecretValue, error) {
+	if version != (SecretVersion{}) {
+		_, versioned := c.Inner.(VersionedSecretManager)
+		if _, ok := c.Inner.(SecretFetcher); !versioned && !ok {
+			return nil, fmt.Errorf("%s does not support version selection", sourceNames[c.Backend])
+		}
+	}
+	return c.get(secretName, version)
+}
+
+// Source reports the backend label of the wrapped manager
+func (c *CachingSecretManager) Source() string {
+	return sourceOf(c.Inner)
+}
+
+// SecretMetadata returns the metadata of the secret as last served, from the cache
+// entry on a hit and from the wrapped manager otherwise
+func (c *CachingSecretManager) SecretMetadata(secretName string) (SecretMetadata, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	meta, ok := c.metadata[secretName]
+	return meta, ok
+}
+
+// SecretTags returns the tags from the wrapped manager, so production secrets are still detected
+func (c *CachingSecretManager) SecretTags(secretName string) (map[string]string, error) {
+	if provider, ok := c.Inner.(SecretTagProvider); ok {
+		return provider.SecretTags(secretName)
+	}
+	return nil, nil
+}
+
+func (c *CachingSecretManager) get(secretName string, version SecretVersion) (*SecretValue, error) {
+	path := c.path(secretName, version)
+	entry, err := c.read(path)
+	switch {
+	case err == nil && c.now().Sub(entry.FetchedAt) < c.TTL:
+		c.log("info", "Using cached secret", map[string]string{"secretName": secretName})
+		if entry.Metadata != nil {
+			c.recordMetadata(secretName, *entry.Metadata)
+		}
+		return &SecretValue{
+			String:    entry.Value,
+			Binary:    entry.Binary,
+			VersionID: entry.VersionID,
+			Stages:    entry.Stages,
+			Source:    c.Source(),
+		}, nil
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		c.log("warn", "Ignoring unreadable cache entry", map[string]string{
+			"secretName": secretName,
+			"error":      err.Error(),
+		})
+	}
+
+	value, err := fetchSecret(c.Inner, secretName, version)
+	if err != nil {
+		return nil, err
+	}
+	entry = cacheEntry{
+		FetchedAt: c.now(),
+		Value:     value.String,
+		Binary:    value.Binary,
+		VersionID: value.VersionID,
+		Stages:    value.Stages,
+	}
+	if provider, ok := c.Inner.(SecretMetadataProvider); ok {
+		if meta, ok := provider.SecretMetadata(secretName); ok {
+			entry.Metadata = &meta
+			c.recordMetadata(secretName, meta)
+		}
+	}
+	// The fetch already succeeded, so a cache that cannot be written only costs a later API call
+	if err := c.write(path, entry); err != nil {
+		c.log("warn", "Failed to cache secret", map[string]string{
+			"secretName": secretName,
+			"error":      err.Error(),
+		})
+	}
+	return value, nil
+}
+
+func (c *CachingSecretManager) recordMetadata(secretName string, meta SecretMetadata) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.metadata == nil {
+		c.metadata = make(map[string]SecretMetadata)
+	}
+	c.metadata[secretName] = meta
+}
+
+// path returns the cache file of a secret; the name is hashed so it is not stored in clear
+func (c *CachingSecretManager) path(secretName string, version SecretVersion) string {
+	sum := sha256.Sum256([]byte(c.Backend + "\x00" + secretName + "\x00" + version.ID + "\x00" + version.Stage))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:]))
+}
+
+// read decrypts the cache entry at path
+func (c *CachingSecretManager) read(path string) (cacheEntry, error) {
+	var entry cacheEntry
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return entry, err
+	}
+	if len(data) < cacheSaltSize {
+		return entry, fmt.Errorf("cache entry is truncated")
+	}
+
+	gcm, err := c.cipher(data[:cacheSaltSize])
+	if err != nil {
+		return entry, err
+	}
+	data = data[cacheSaltSize:]
+	if len(data) < gcm.NonceSize() {
+		return entry, fmt.Errorf("cache entry is truncated")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return entry, fmt.Errorf("failed to decrypt cache entry: %w", err)
+	}
+	if err := json.Unmarshal(plaintext, &entry); err != nil {
+		return entry, fmt.Errorf("failed to decode cache entry: %w", err)
+	}
+	return entry, nil
+}
+
+// write encrypts entry into path as salt, nonce and ciphertext, readable only by the owner
+func (c *CachingSecretManager) write(path string, entry cacheEntry) error {
+	plaintext, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, cacheSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	gcm, err := c.cipher(salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	data := append(salt, nonce...)
+	data = gcm.Seal(data, nonce, plaintext, nil)
+
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return err
+	}
+	// Write to a temp file first so a concurrent reader never sees a partial entry
+	tmp, err := os.CreateTemp(c.Dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// cipher derives the AES-GCM cipher of an entry from the passphrase and the entry's salt
+func (c *CachingSecretManager) cipher(salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(c.Passphrase), salt, 1<<15, 8, 1, cacheKeySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (c *CachingSecretManager) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+func (c *CachingSecretManager) log(level, message string, data interface{}) {
+	if c.Logger != nil {
+		c.Logger.Log(level, message, data)
+	}
+}
@@ -0,0 +1,242 @@
+package secrun
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCache はモックをラップし、操作できるクロックを持つCachingSecretManagerを返す
+func newTestCache(t *testing.T, inner *MockSecretManager, now *time.Time) *CachingSecretManager {
+	t.Helper()
+	return &CachingSecretManager{
+		Inner:      inner,
+		Backend:    SourceSecretsManager,
+		Dir:        filepath.Join(t.TempDir(), "cache"),
+		TTL:        10 * time.Minute,
+		Passphrase: "correct horse",
+		Logger:     &MockLogger{},
+		Now:        func() time.Time { return *now },
+	}
+}
+
+func TestCachingSecretManager_Hit(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	inner := &MockSecretManager{Secrets: map[string]string{"db": `{"DB_PASSWORD":"secure123"}`}}
+	cache := newTestCache(t, inner, &now)
+
+	for i := 0; i < 2; i++ {
+		got, err := cache.GetSecret("db")
+		if err != nil {
+			t.Fatalf("GetSecret() error = %v", err)
+		}
+		if got != `{"DB_PASSWORD":"secure123"}` {
+			t.Errorf("GetSecret() = %q", got)
+		}
+		now = now.Add(9 * time.Minute)
+	}
+
+	// TTL内の2回目はバックエンドを呼ばない
+	if len(inner.Calls) != 1 {
+		t.Errorf("Expected 1 backend call, got: %v", inner.Calls)
+	}
+
+	// キャッシュファイルは暗号化され、所有者だけが読める
+	entries, err := os.ReadDir(cache.Dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected one cache file, got: %v, %v", entries, err)
+	}
+	path := filepath.Join(cache.Dir, entries[0].Name())
+	data, _ := os.ReadFile(path)
+	if bytes.Contains(data, []byte("secure123")) || strings.Contains(entries[0].Name(), "db") {
+		t.Errorf("Cache file leaks the secret or its name: %s %q", entries[0].Name(), data)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("cache file mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestCachingSecretManager_Expiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	inner := &MockSecretManager{Secrets: map[string]string{"db": `{"DB_PASSWORD":"old"}`}}
+	cache := newTestCache(t, inner, &now)
+
+	if _, err := cache.GetSecret("db"); err != nil {
+		t.Fatalf("GetSecret() error = %v", err)
+	}
+
+	// TTLを過ぎたエントリはバックエンドから取り直して更新する
+	inner.Secrets["db"] = `{"DB_PASSWORD":"new"}`
+	now = now.Add(10 * time.Minute)
+	got, err := cache.GetSecret("db")
+	if err != nil {
+		t.Fatalf("GetSecret() error = %v", err)
+	}
+	if got != `{"DB_PASSWORD":"new"}` || len(inner.Calls) != 2 {
+		t.Errorf("GetSecret() = %q after %d calls, want the new value from a second call", got, len(inner.Calls))
+	}
+
+	now = now.Add(time.Minute)
+	if got, _ := cache.GetSecret("db"); got != `{"DB_PASSWORD":"new"}` || len(inner.Calls) != 2 {
+		t.Errorf("GetSecret() = %q after %d calls, want the refreshed entry from the cache", got, len(inner.Calls))
+	}
+}
+
+func TestCachingSecretManager_CorruptFallback(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	inner := &MockSecretManager{Secrets: map[string]string{"db": `{"DB_PASSWORD":"secure123"}`}}
+	cache := newTestCache(t, inner, &now)
+	if _, err := cache.GetSecret("db"); err != nil {
+		t.Fatalf("GetSecret() error = %v", err)
+	}
+	path := cache.path("db", SecretVersion{})
+
+	tests := []struct {
+		name    string
+		corrupt func()
+	}{
+		{"garbage", func() { os.WriteFile(path, []byte("not a cache entry at all"), 0600) }},
+		{"truncated", func() { os.WriteFile(path, []byte("short"), 0600) }},
+		{"wrong passphrase", func() { cache.Passphrase = "other passphrase" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := len(inner.Calls)
+			logger := &MockLogger{}
+			cache.Logger = logger
+			tt.corrupt()
+
+			// 読めないキャッシュは無視してバックエンドから取得する
+			got, err := cache.GetSecret("db")
+			if err != nil {
+				t.Fatalf("GetSecret() error = %v", err)
+			}
+			if got != `{"DB_PASSWORD":"secure123"}` || len(inner.Calls) != calls+1 {
+				t.Errorf("GetSecret() = %q with %d new calls, want a backend fetch", got, len(inner.Calls)-calls)
+			}
+			if len(logger.Logs) == 0 || logger.Logs[0].Level != "warn" {
+				t.Errorf("Expected a warning about the unreadable entry, got: %+v", logger.Logs)
+			}
+
+			// 取り直した値で上書きされ、次はキャッシュから返る
+			cache.GetSecret("db")
+			if len(inner.Calls) != calls+1 {
+				t.Errorf("Expected the rewritten entry to be used, got %d new calls", len(inner.Calls)-calls)
+			}
+		})
+	}
+}
+
+func TestApplication_Run_CacheDir(t *testing.T) {
+	dir := t.TempDir()
+	mockSecretManager := &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}}
+	newApp := func() (*Application, *MockCommandRunner) {
+		runner := &MockCommandRunner{}
+		return &Application{
+			Logger:        &MockLogger{},
+			SecretManager: mockSecretManager,
+			CommandRunner: runner,
+			Args:          []string{"program", "/usr/bin/env", "--key", "db", "--cache-dir", dir, "--cache-ttl", "1h"},
+		}, runner
+	}
+
+	// パスフレーズがなければエラー
+	t.Setenv(cachePassphraseEnv, "")
+	app, _ := newApp()
+	if err := app.Run(); err == nil || !strings.Contains(err.Error(), cachePassphraseEnv) {
+		t.Fatalf("Expected error naming %s, got: %v", cachePassphraseEnv, err)
+	}
+
+	// 2回目の実行はキャッシュから同じ環境変数を注入する
+	t.Setenv(cachePassphraseEnv, "correct horse")
+	for i := 0; i < 2; i++ {
+		app, runner := newApp()
+		if err := app.Run(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if env := strings.Join(runner.ExecutedCommands[0].Env, "\n"); !strings.Contains(env, "DB_USER=admin") {
+			t.Errorf("run %d: expected DB_USER=admin, got: %s", i, env)
+		}
+	}
+	if len(mockSecretManager.Calls) != 1 {
+		t.Errorf("Expected 1 backend call, got: %v", mockSecretManager.Calls)
+	}
+
+	for _, args := range [][]string{
+		{"program", "/bin/true", "--cache-ttl", "1h"},
+		{"program", "/bin/true", "--cache-dir", dir, "--binary-mode", "file"},
+	} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("parseArgs(%v) expected error, got nil", args[2:])
+		}
+	}
+}
+
+func TestApplication_Run_CacheHitKeepsMetadata(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(cachePassphraseEnv, "correct horse")
+	created := time.Date(2024, 3, 15, 0, 30, 0, 0, time.UTC)
+	mockSecretManager := &MockMetadataSecretManager{
+		MockSecretManager: MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}},
+		Metadata:          map[string]SecretMetadata{"db": {CreatedDate: created}},
+	}
+
+	// キャッシュヒットでもバックエンドが返した作成日時を注入できる
+	for i := 0; i < 2; i++ {
+		runner := &MockCommandRunner{}
+		app := &Application{
+			Logger:        &MockLogger{},
+			SecretManager: mockSecretManager,
+			CommandRunner: runner,
+			Args:          []string{"program", "/usr/bin/env", "--key", "db", "--cache-dir", dir, "--inject-secret-date", "DB_SECRET_DATE"},
+		}
+		if err := app.Run(); err != nil {
+			t.Fatalf("run %d: unexpected error: %v", i, err)
+		}
+		if env := strings.Join(runner.ExecutedCommands[0].Env, "\n"); !strings.Contains(env, "DB_SECRET_DATE=2024-03-15T00:30:00Z") {
+			t.Errorf("run %d: expected DB_SECRET_DATE=2024-03-15T00:30:00Z, got: %s", i, env)
+		}
+	}
+	if len(mockSecretManager.Calls) != 1 {
+		t.Errorf("Expected 1 backend call, got: %v", mockSecretManager.Calls)
+	}
+}
+
+func TestCachingSecretManager_HitKeepsVersion(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	calls := 0
+	client := &MockSecretsManagerClient{Binaries: map[string][]byte{"cert": {0x00, 0xff}}}
+	cache := &CachingSecretManager{
+		Inner:      newTestAWSSecretManager(client, &calls),
+		Backend:    SourceSecretsManager,
+		Dir:        filepath.Join(t.TempDir(), "cache"),
+		TTL:        10 * time.Minute,
+		Passphrase: "correct horse",
+		Logger:     &MockLogger{},
+		Now:        func() time.Time { return now },
+	}
+
+	first, err := cache.FetchSecret("cert", SecretVersion{Stage: "AWSPREVIOUS"})
+	if err != nil {
+		t.Fatalf("FetchSecret() error = %v", err)
+	}
+	second, err := cache.FetchSecret("cert", SecretVersion{Stage: "AWSPREVIOUS"})
+	if err != nil {
+		t.Fatalf("FetchSecret() error = %v", err)
+	}
+
+	// ヒットしてもバージョンID、ステージ、バイナリは取得時と同じ
+	if len(client.Inputs) != 1 {
+		t.Errorf("Expected 1 backend call, got: %d", len(client.Inputs))
+	}
+	if second.VersionID != "v-cert" || !reflect.DeepEqual(second.Stages, []string{"AWSPREVIOUS"}) {
+		t.Errorf("cached version = %q %v, want v-cert [AWSPREVIOUS]", second.VersionID, second.Stages)
+	}
+	if !bytes.Equal(second.Binary, []byte{0x00, 0xff}) || second.String != first.String || second.Source != SourceSecretsManager {
+		t.Errorf("cached value = %+v, want %+v", second, first)
+	}
+}
@@ -0,0 +1,248 @@
+package secrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// captureWriter turns the command's output into one log entry per line.
+// Each stream keeps its own partial line so stdout and stderr never mix.
+type captureWriter struct {
+	logger Logger
+	// limit is the maximum number of bytes captured across all streams; zero means unlimited
+	limit int64
+
+	mu       sync.Mutex
+	buffers  map[string]*bytes.Buffer
+	streams  []string
+	captured int64
+	limited  bool
+}
+
+// newCaptureWriter creates a captureWriter that stops capturing after limit bytes
+func newCaptureWriter(logger Logger, limit int64) *captureWriter {
+	return &captureWriter{
+		logger:  logger,
+		limit:   limit,
+		buffers: make(map[string]*bytes.Buffer),
+	}
+}
+
+// Stream returns a writer whose lines are logged tagged with stream=name
+func (w *captureWriter) Stream(name string) io.Writer {
+	return &captureStream{w: w, name: name}
+}
+
+// captureStream is one named stream of a captureWriter
+type captureStream struct {
+	w    *captureWriter
+	name string
+}
+
+// Write logs every complete line of the stream
+func (s *captureStream) Write(p []byte) (int, error) {
+	return s.w.write(s.name, p)
+}
+
+// Write logs every complete line without a stream tag
+func (w *captureWriter) Write(p []byte) (int, error) {
+	return w.write("", p)
+}
+
+// write logs every complete line of stream; output past the limit is discarded
+func (w *captureWriter) write(stream string, p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := len(p)
+	if w.limited {
+		return n, nil
+	}
+
+	if w.limit > 0 && w.captured+int64(len(p)) > w.limit {
+		p = p[:w.limit-w.captured]
+		w.limited = true
+	}
+	w.captured += int64(len(p))
+
+	buf, ok := w.buffers[stream]
+	if !ok {
+		buf = &bytes.Buffer{}
+		w.buffers[stream] = buf
+		w.streams = append(w.streams, stream)
+	}
+	buf.Write(p)
+
+	for {
+		line, err := buf.ReadString('\n')
+		if err != nil {
+			// Keep the incomplete line for the next write
+			buf.Reset()
+			buf.WriteString(line)
+			break
+		}
+		w.logLine(stream, strings.TrimSuffix(line, "\n"))
+	}
+
+	if w.limited {
+		w.flushLocked()
+		w.logger.Log("warn", "Command output capture limit reached; further output is discarded", map[string]int64{"limitBytes": w.limit})
+	}
+
+	// Report the full write so the command keeps running past the limit
+	return n, nil
+}
+
+// Flush logs any trailing output that did not end with a newline
+func (w *captureWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flushLocked()
+}
+
+func (w *captureWriter) flushLocked() {
+	for _, stream := range w.streams {
+		buf := w.buffers[stream]
+		if buf.Len() > 0 {
+			w.logLine(stream, buf.String())
+			buf.Reset()
+		}
+	}
+}
+
+func (w *captureWriter) logLine(stream, line string) {
+	data := map[string]string{"line": line}
+	if stream != "" {
+		data["stream"] = stream
+	}
+	w.logger.Log("info", "Command output", data)
+}
+
+// countingWriter passes output through to w and counts the bytes written
+type countingWriter struct {
+	w io.Writer
+
+	mu sync.Mutex
+	n  int64
+}
+
+// Write forwards p to the underlying writer, if any, and counts it
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	c.n += int64(len(p))
+	c.mu.Unlock()
+
+	if c.w == nil {
+		return len(p), nil
+	}
+	return c.w.Write(p)
+}
+
+// Count returns the number of bytes written so far
+func (c *countingWriter) Count() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
+
+// CommandResult is the JSON document describing a finished command
+type CommandResult struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exitCode"`
+	// Truncated is set when output beyond the capture limit was discarded
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// limitedBuffer keeps up to limit bytes of output; zero means unlimited
+type limitedBuffer struct {
+	limit int64
+
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	truncated bool
+}
+
+// Write stores output up to the limit and discards the rest
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(p)
+	if b.limit > 0 && int64(b.buf.Len())+int64(len(p)) > b.limit {
+		p = p[:b.limit-int64(b.buf.Len())]
+		b.truncated = true
+	}
+	b.buf.Write(p)
+
+	// Report the full write so the command keeps running past the limit
+	return n, nil
+}
+
+// String returns the stored output
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// Truncated reports whether any output was discarded
+func (b *limitedBuffer) Truncated() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.truncated
+}
+
+// writeCommandResult writes the result as JSON to path, or to stdout when path is -
+func writeCommandResult(path string, result CommandResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to encode command result: %w", err)
+	}
+	data = append(data, '\n')
+
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(path, data, 0600)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write command result: %w", err)
+	}
+	return nil
+}
+
+// parseByteSize parses a size such as 512, 64KB or 1MB (binary multiples)
+func parseByteSize(s string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(upper, u.suffix) {
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, u.suffix))
+			multiplier = u.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
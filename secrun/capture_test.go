@@ -0,0 +1,224 @@
+package secrun
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCaptureWriter(t *testing.T) {
+	logger := &MockLogger{}
+	w := newCaptureWriter(logger, 0)
+
+	// 行の途中で分割された書き込みも1行として記録される
+	w.Write([]byte("hello wo"))
+	w.Write([]byte("rld\nsecond line\ntrailing"))
+	w.Flush()
+
+	var lines []string
+	for _, log := range logger.Logs {
+		lines = append(lines, log.Data.(map[string]string)["line"])
+	}
+	want := []string{"hello world", "second line", "trailing"}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("captured lines = %q, want %q", lines, want)
+	}
+}
+
+func TestCaptureWriter_Streams(t *testing.T) {
+	logger := &MockLogger{}
+	w := newCaptureWriter(logger, 0)
+	stdout, stderr := w.Stream("stdout"), w.Stream("stderr")
+
+	// ストリームごとに行を組み立てるので、交互の書き込みでも行が混ざらない
+	stdout.Write([]byte("out "))
+	stderr.Write([]byte("err "))
+	stdout.Write([]byte("line\n"))
+	stderr.Write([]byte("line\nerr tail"))
+	stdout.Write([]byte("out tail"))
+	w.Flush()
+
+	var got []string
+	for _, log := range logger.Logs {
+		data := log.Data.(map[string]string)
+		got = append(got, data["stream"]+":"+data["line"])
+	}
+	want := []string{"stdout:out line", "stderr:err line", "stdout:out tail", "stderr:err tail"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("captured lines = %q, want %q", got, want)
+	}
+}
+
+func TestCaptureWriter_Limit(t *testing.T) {
+	logger := &MockLogger{}
+	w := newCaptureWriter(logger, 10)
+
+	// 上限を超えても書き込みは成功として扱われる
+	input := "12345\n67890abc\nmore output\n"
+	n, err := w.Write([]byte(input))
+	if err != nil || n != len(input) {
+		t.Fatalf("Write() = %d, %v, want %d, nil", n, err, len(input))
+	}
+	w.Write([]byte("even more\n"))
+	w.Flush()
+
+	var lines []string
+	warnings := 0
+	for _, log := range logger.Logs {
+		switch log.Level {
+		case "info":
+			lines = append(lines, log.Data.(map[string]string)["line"])
+		case "warn":
+			warnings++
+		}
+	}
+
+	// 上限までの出力だけがキャプチャされる
+	if strings.Join(lines, "|") != "12345|6789" {
+		t.Errorf("captured lines = %q, want [12345 6789]", lines)
+	}
+	// 警告は一度だけ記録される
+	if warnings != 1 {
+		t.Errorf("Expected 1 warning, got: %d", warnings)
+	}
+}
+
+func TestDefaultCommandRunner_CaptureOutput(t *testing.T) {
+	logger := &MockLogger{}
+	runner := NewCommandRunner()
+	runner.Logger = logger
+	runner.CaptureOutput = true
+	runner.OutputLimit = 16
+
+	// 子プロセスは上限を超えても最後まで実行される
+	err := runner.Run("/bin/sh", []string{"-c", "echo first; echo second; echo third; exit 3"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Fatalf("Expected the command to run to completion, got: %v", err)
+	}
+
+	var lines []string
+	warned := false
+	for _, log := range logger.Logs {
+		if log.Level == "warn" {
+			warned = true
+			continue
+		}
+		lines = append(lines, log.Data.(map[string]string)["line"])
+	}
+	if strings.Join(lines, "|") != "first|second|thi" {
+		t.Errorf("captured lines = %q, want [first second thi]", lines)
+	}
+	if !warned {
+		t.Error("Expected warning when the capture limit is reached")
+	}
+}
+
+func TestDefaultCommandRunner_CaptureOutputStreams(t *testing.T) {
+	logger := &MockLogger{}
+	runner := NewCommandRunner()
+	runner.Logger = logger
+	runner.CaptureOutput = true
+
+	err := runner.Run("/bin/sh", []string{"-c", "for i in 1 2 3; do echo out$i; echo err$i >&2; done"}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// stdoutとstderrの行がそれぞれのストリーム名で記録される
+	lines := map[string][]string{}
+	for _, log := range logger.Logs {
+		data := log.Data.(map[string]string)
+		lines[data["stream"]] = append(lines[data["stream"]], data["line"])
+	}
+	if got := strings.Join(lines["stdout"], "|"); got != "out1|out2|out3" {
+		t.Errorf("stdout lines = %q, want out1|out2|out3", got)
+	}
+	if got := strings.Join(lines["stderr"], "|"); got != "err1|err2|err3" {
+		t.Errorf("stderr lines = %q, want err1|err2|err3", got)
+	}
+	if len(lines) != 2 {
+		t.Errorf("Expected only stdout and stderr streams, got: %v", lines)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "512", want: 512},
+		{input: "64KB", want: 64 << 10},
+		{input: "1MB", want: 1 << 20},
+		{input: "2gb", want: 2 << 30},
+		{input: "10B", want: 10},
+		{input: "MB", wantErr: true},
+		{input: "-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.input)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseByteSize(%q) error = nil, want error", tt.input)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", tt.input, got, err, tt.want)
+		}
+	}
+}
+
+func TestDefaultCommandRunner_OutputJSON(t *testing.T) {
+	runner, args, env := helperRunner(t, "output")
+	runner.OutputJSON = filepath.Join(t.TempDir(), "result.json")
+
+	err := runner.Run(os.Args[0], args, env)
+	if ExitCode(err) != 3 {
+		t.Fatalf("Run() exit code = %d, want 3 (err: %v)", ExitCode(err), err)
+	}
+
+	data, err := os.ReadFile(runner.OutputJSON)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	var result CommandResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("Failed to parse result JSON: %v", err)
+	}
+
+	// 標準出力・標準エラー・終了コードが記録される
+	if result.Stdout != "to stdout" || result.Stderr != "to stderr" || result.ExitCode != 3 {
+		t.Errorf("result = %+v, want stdout/stderr and exit code 3", result)
+	}
+	if result.Truncated {
+		t.Error("Expected output not to be truncated")
+	}
+	// 出力はストリーミングされない
+	if got := readOutput(t, runner); got != "" {
+		t.Errorf("Expected no streamed output, got: %q", got)
+	}
+
+	// 上限を超えた出力は切り詰められる
+	runner.OutputLimit = 2
+	if err := runner.Run(os.Args[0], args, env); ExitCode(err) != 3 {
+		t.Fatalf("Run() error = %v", err)
+	}
+	data, _ = os.ReadFile(runner.OutputJSON)
+	result = CommandResult{}
+	json.Unmarshal(data, &result)
+	if result.Stdout != "to" || result.Stderr != "to" || !result.Truncated {
+		t.Errorf("result = %+v, want truncated output", result)
+	}
+}
+
+func TestParseArgs_CommandOutputJSONConflicts(t *testing.T) {
+	for _, flag := range []string{"--capture-output", "--detach"} {
+		if _, err := parseArgs([]string{"program", "/bin/true", "--command-output-json", "out.json", flag}); err == nil {
+			t.Errorf("Expected error combining --command-output-json with %s, got nil", flag)
+		}
+	}
+}
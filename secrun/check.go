@@ -0,0 +1,95 @@
+package secrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// checkFlag runs the access check instead of a command when given as the first argument
+const checkFlag = "--check"
+
+// SecretAccessChecker is implemented by secret managers that can verify a secret is
+// readable without fetching its value; other managers are checked by fetching it
+type SecretAccessChecker interface {
+	CanAccess(secretName string) error
+}
+
+// CheckResult is the outcome of the access check for one secret
+type CheckResult struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+}
+
+// CheckReport is written by --check: OK is set only when every secret is accessible
+type CheckReport struct {
+	OK      bool          `json:"ok"`
+	Secrets []CheckResult `json:"secrets"`
+}
+
+// CanAccess checks that a secret exists and is visible to the caller with DescribeSecret,
+// which neither returns nor decrypts the value
+func (sm *AWSSecretManager) CanAccess(secretName string) error {
+	cfg, err := sm.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	if _, err := sm.getClient(cfg).DescribeSecret(sm.ctx, &secretsmanager.DescribeSecretInput{
+		SecretId: aws.String(secretName),
+	}); err != nil {
+		return fmt.Errorf("failed to describe secret: %w", err)
+	}
+	return nil
+}
+
+// checkAccess checks every requested secret and writes a CheckReport to w.
+// It fails when any secret is inaccessible so the exit status reflects the result.
+func (app *Application) checkAccess(w io.Writer, opts *Options) error {
+	report := CheckReport{OK: true, Secrets: []CheckResult{}}
+	for _, spec := range opts.Secrets {
+		source := spec.Source
+		if source == "" {
+			source = SourceSecretsManager
+		}
+		result := CheckResult{Name: spec.Name, Source: source}
+
+		sm, err := app.secretManager(spec.Source)
+		if err == nil {
+			if checker, ok := sm.(SecretAccessChecker); ok {
+				err = checker.CanAccess(spec.Name)
+			} else {
+				_, err = sm.GetSecret(spec.Name)
+			}
+		}
+		if err != nil {
+			result.Error = err.Error()
+			report.OK = false
+		} else {
+			result.OK = true
+		}
+		report.Secrets = append(report.Secrets, result)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+
+	if !report.OK {
+		failed := 0
+		for _, result := range report.Secrets {
+			if !result.OK {
+				failed++
+			}
+		}
+		return fmt.Errorf("%d of %d secrets are not accessible", failed, len(report.Secrets))
+	}
+	return nil
+}
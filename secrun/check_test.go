@@ -0,0 +1,110 @@
+package secrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestApplication_CheckAccess(t *testing.T) {
+	client := &MockSecretsManagerClient{
+		DescribeErrors: map[string]error{
+			"missing": &types.ResourceNotFoundException{Message: aws.String("Secrets Manager can't find the specified secret.")},
+			"denied":  &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "not authorized to perform: secretsmanager:DescribeSecret"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		secret  string
+		wantErr string
+	}{
+		{name: "accessible", secret: "db"},
+		{name: "not found", secret: "missing", wantErr: "ResourceNotFoundException"},
+		{name: "access denied", secret: "denied", wantErr: "AccessDeniedException"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			app := &Application{
+				Logger:        &MockLogger{},
+				SecretManager: newTestAWSSecretManager(client, &calls),
+				CommandRunner: &MockCommandRunner{},
+			}
+			opts, err := parseArgs([]string{"program", "--check", "--key", tt.secret})
+			if err != nil {
+				t.Fatalf("parseArgs() error = %v", err)
+			}
+			if !opts.Check || opts.CommandPath != "" {
+				t.Fatalf("Check = %v, CommandPath = %q; want true and empty", opts.Check, opts.CommandPath)
+			}
+
+			var out bytes.Buffer
+			err = app.checkAccess(&out, opts)
+			if (err != nil) != (tt.wantErr != "") {
+				t.Fatalf("checkAccess() error = %v, wantErr %v", err, tt.wantErr != "")
+			}
+			if err != nil && ExitCode(err) != 1 {
+				t.Errorf("ExitCode = %d, want 1", ExitCode(err))
+			}
+
+			var report CheckReport
+			if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+				t.Fatalf("output is not a CheckReport: %v\n%s", err, out.String())
+			}
+			if report.OK != (tt.wantErr == "") || len(report.Secrets) != 1 {
+				t.Fatalf("report = %+v", report)
+			}
+			result := report.Secrets[0]
+			if result.Name != tt.secret || result.Source != SourceSecretsManager || result.OK != (tt.wantErr == "") {
+				t.Errorf("result = %+v", result)
+			}
+			if !strings.Contains(result.Error, tt.wantErr) {
+				t.Errorf("result error = %q, want containing %q", result.Error, tt.wantErr)
+			}
+		})
+	}
+
+	// 値の取得は行わずDescribeSecretだけで確認する
+	if len(client.Inputs) != 0 {
+		t.Errorf("GetSecretValue calls = %d, want 0", len(client.Inputs))
+	}
+}
+
+func TestApplication_Run_CheckDoesNotRunCommand(t *testing.T) {
+	mockRunner := &MockCommandRunner{}
+	mockSecretManager := &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: mockSecretManager,
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "--check", "--key", "db"},
+	}
+
+	// CanAccessを持たないSecretManagerは取得して確認する
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(mockRunner.ExecutedCommands) != 0 {
+		t.Error("Expected no command to run under --check")
+	}
+	if len(mockSecretManager.Calls) != 1 {
+		t.Errorf("GetSecret calls = %d, want 1", len(mockSecretManager.Calls))
+	}
+
+	// コマンド引数やシークレットなしはエラー
+	for _, args := range [][]string{
+		{"program", "--check", "--key", "db", "/usr/bin/env"},
+		{"program", "--check"},
+	} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("parseArgs(%v) expected error, got nil", args)
+		}
+	}
+}
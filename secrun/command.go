@@ -0,0 +1,63 @@
+package secrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// commandFromSecretFlag takes the command and its arguments from a secret instead of argv
+const commandFromSecretFlag = "--command-from-secret"
+
+// CommandSecret is the JSON form of a --command-from-secret secret
+type CommandSecret struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// parseCommandSecret parses the value of a --command-from-secret secret: either a
+// CommandSecret JSON object, or a plain string split on whitespace into the command
+// and its arguments. Plain strings are not interpreted by a shell, so arguments that
+// contain spaces need the JSON form.
+func parseCommandSecret(value string) (string, []string, error) {
+	trimmed := strings.TrimSpace(value)
+	if !strings.HasPrefix(trimmed, "{") {
+		fields := strings.Fields(trimmed)
+		if len(fields) == 0 {
+			return "", nil, fmt.Errorf("command secret is empty")
+		}
+		return fields[0], fields[1:], nil
+	}
+
+	var secret CommandSecret
+	dec := json.NewDecoder(bytes.NewReader([]byte(trimmed)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&secret); err != nil {
+		return "", nil, fmt.Errorf("command secret is not a valid {\"command\": ..., \"args\": [...]} object: %w", err)
+	}
+	if dec.More() {
+		return "", nil, fmt.Errorf("command secret has data after the JSON object")
+	}
+	if strings.TrimSpace(secret.Command) == "" {
+		return "", nil, fmt.Errorf("command secret has no \"command\"")
+	}
+	if secret.Args == nil {
+		secret.Args = []string{}
+	}
+	return secret.Command, secret.Args, nil
+}
+
+// loadCommand fetches the --command-from-secret secret from Secrets Manager and
+// returns the command and arguments it holds
+func (app *Application) loadCommand(secretName string) (string, []string, error) {
+	value, err := app.SecretManager.GetSecret(secretName)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to fetch command secret %s: %w", secretName, err)
+	}
+	commandPath, args, err := parseCommandSecret(value)
+	if err != nil {
+		return "", nil, fmt.Errorf("secret %s: %w", secretName, err)
+	}
+	return commandPath, args, nil
+}
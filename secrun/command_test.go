@@ -0,0 +1,112 @@
+package secrun
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplication_Run_CommandFromSecret(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		wantPath string
+		wantArgs []string
+	}{
+		{
+			name:     "JSON形式",
+			value:    `{"command":"/usr/bin/app","args":["--port","8080","hello world"]}`,
+			wantPath: "/usr/bin/app",
+			wantArgs: []string{"--port", "8080", "hello world"},
+		},
+		{
+			name:     "argsなしのJSON形式",
+			value:    `{"command":"/usr/bin/app"}`,
+			wantPath: "/usr/bin/app",
+			wantArgs: []string{},
+		},
+		{
+			name:     "文字列形式",
+			value:    "  /usr/bin/app --port 8080\n",
+			wantPath: "/usr/bin/app",
+			wantArgs: []string{"--port", "8080"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRunner := &MockCommandRunner{}
+			app := &Application{
+				Logger: &MockLogger{},
+				SecretManager: &MockSecretManager{Secrets: map[string]string{
+					"app-command": tt.value,
+					"db":          `{"DB_USER":"admin"}`,
+				}},
+				CommandRunner: mockRunner,
+				Args:          []string{"program", "--command-from-secret", "app-command", "--key", "db"},
+			}
+
+			if err := app.Run(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			// シークレットのコマンドと引数で実行され、環境変数も設定される
+			if len(mockRunner.ExecutedCommands) != 1 {
+				t.Fatalf("Expected 1 command execution, got: %d", len(mockRunner.ExecutedCommands))
+			}
+			executed := mockRunner.ExecutedCommands[0]
+			if executed.Path != tt.wantPath {
+				t.Errorf("command = %q, want %q", executed.Path, tt.wantPath)
+			}
+			if !reflect.DeepEqual(executed.Args, tt.wantArgs) {
+				t.Errorf("args = %q, want %q", executed.Args, tt.wantArgs)
+			}
+			if !strings.Contains(strings.Join(executed.Env, "\n"), "DB_USER=admin") {
+				t.Error("Expected DB_USER=admin in env")
+			}
+		})
+	}
+}
+
+func TestApplication_Run_CommandFromSecretMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr string
+	}{
+		{name: "空文字列", value: "   ", wantErr: "command secret is empty"},
+		{name: "commandなし", value: `{"args":["x"]}`, wantErr: `no "command"`},
+		{name: "argsが配列でない", value: `{"command":"/usr/bin/app","args":"--port 8080"}`, wantErr: "not a valid"},
+		{name: "未知のフィールド", value: `{"cmd":"/usr/bin/app"}`, wantErr: "not a valid"},
+		{name: "壊れたJSON", value: `{"command":`, wantErr: "not a valid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRunner := &MockCommandRunner{}
+			app := &Application{
+				Logger:        &MockLogger{},
+				SecretManager: &MockSecretManager{Secrets: map[string]string{"app-command": tt.value}},
+				CommandRunner: mockRunner,
+				Args:          []string{"program", "--command-from-secret=app-command"},
+			}
+
+			err := app.Run()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), "app-command") {
+				t.Errorf("Expected error containing %q, got: %v", tt.wantErr, err)
+			}
+			if len(mockRunner.ExecutedCommands) != 0 {
+				t.Errorf("Expected no command execution, got: %d", len(mockRunner.ExecutedCommands))
+			}
+		})
+	}
+
+	// コマンドラインのコマンドとは併用できない
+	opts, err := parseArgs([]string{"program", "/usr/bin/env", "--command-from-secret", "app-command"})
+	if err == nil {
+		err = opts.Validate()
+	}
+	if err == nil {
+		t.Error("Expected error combining a command with --command-from-secret")
+	}
+}
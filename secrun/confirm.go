@@ -0,0 +1,78 @@
+package secrun
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// SecretTagProvider is implemented by secret managers that can report the tags of a secret
+type SecretTagProvider interface {
+	SecretTags(secretName string) (map[string]string, error)
+}
+
+// productionTagKeys are the tag keys inspected to detect a production secret
+var productionTagKeys = []string{"env", "environment", "stage"}
+
+// isProductionTagged reports whether the tags mark a secret as belonging to production
+func isProductionTagged(tags map[string]string) bool {
+	for key, value := range tags {
+		for _, k := range productionTagKeys {
+			if !strings.EqualFold(key, k) {
+				continue
+			}
+			if v := strings.ToLower(value); v == "prod" || v == "production" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Prompter asks the user for confirmation on an interactive terminal
+type Prompter struct {
+	In  io.Reader
+	Out io.Writer
+	// IsTerminal reports whether In is connected to a terminal
+	IsTerminal func() bool
+}
+
+// NewPrompter creates a Prompter reading from stdin and prompting on stderr
+func NewPrompter() *Prompter {
+	return &Prompter{
+		In:         os.Stdin,
+		Out:        os.Stderr,
+		IsTerminal: func() bool { return isTerminal(os.Stdin) },
+	}
+}
+
+// isTerminal reports whether f is a character device such as a TTY
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Confirm asks a yes/no question and reports whether the user answered yes
+func (p *Prompter) Confirm(question string) (bool, error) {
+	if p.IsTerminal == nil || !p.IsTerminal() {
+		return false, fmt.Errorf("confirmation required but stdin is not a terminal")
+	}
+
+	fmt.Fprintf(p.Out, "%s [y/N]: ", question)
+	answer, err := bufio.NewReader(p.In).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("failed to read confirmation: %w", err)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
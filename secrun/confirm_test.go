@@ -0,0 +1,121 @@
+package secrun
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// newTestPrompter は入力とTTY判定を差し替えたPrompterを返す
+func newTestPrompter(input string, tty bool) (*Prompter, *bytes.Buffer) {
+	var out bytes.Buffer
+	return &Prompter{
+		In:         strings.NewReader(input),
+		Out:        &out,
+		IsTerminal: func() bool { return tty },
+	}, &out
+}
+
+func TestIsProductionTagged(t *testing.T) {
+	tests := []struct {
+		name string
+		tags map[string]string
+		want bool
+	}{
+		{name: "Environment=prod", tags: map[string]string{"Environment": "prod"}, want: true},
+		{name: "env=Production", tags: map[string]string{"env": "Production"}, want: true},
+		{name: "ステージング", tags: map[string]string{"Environment": "staging"}, want: false},
+		{name: "無関係なキー", tags: map[string]string{"Owner": "prod"}, want: false},
+		{name: "タグなし", tags: nil, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isProductionTagged(tt.tags); got != tt.want {
+				t.Errorf("isProductionTagged(%v) = %v, want %v", tt.tags, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplication_Run_ProductionConfirmation(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		tty         bool
+		yes         bool
+		wantErr     bool
+		wantPrompt  bool
+		wantCommand bool
+	}{
+		{name: "確認する", input: "y\n", tty: true, wantPrompt: true, wantCommand: true},
+		{name: "拒否する", input: "n\n", tty: true, wantErr: true, wantPrompt: true},
+		{name: "空の応答は拒否", input: "\n", tty: true, wantErr: true, wantPrompt: true},
+		{name: "TTYでない場合は中止", input: "y\n", tty: false, wantErr: true},
+		{name: "--yesで確認を省略", input: "", tty: false, yes: true, wantCommand: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &MockSecretsManagerClient{
+				Secrets: map[string]string{"prod/db": `{"DB_USER":"admin"}`},
+				Tags:    map[string]map[string]string{"prod/db": {"Environment": "prod"}},
+			}
+			calls := 0
+			mockRunner := &MockCommandRunner{}
+			prompter, out := newTestPrompter(tt.input, tt.tty)
+
+			args := []string{"program", "/usr/bin/env", "--key", "prod/db"}
+			if tt.yes {
+				args = append(args, "--yes")
+			}
+			app := &Application{
+				Logger:        &MockLogger{},
+				SecretManager: newTestAWSSecretManager(client, &calls),
+				CommandRunner: mockRunner,
+				Args:          args,
+				Prompter:      prompter,
+			}
+
+			err := app.Run()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := strings.Contains(out.String(), "prod/db"); got != tt.wantPrompt {
+				t.Errorf("Prompt shown = %v, want %v (output: %q)", got, tt.wantPrompt, out.String())
+			}
+			if got := len(mockRunner.ExecutedCommands) == 1; got != tt.wantCommand {
+				t.Errorf("Command executed = %v, want %v", got, tt.wantCommand)
+			}
+		})
+	}
+}
+
+func TestApplication_Run_NonProductionSkipsConfirmation(t *testing.T) {
+	client := &MockSecretsManagerClient{
+		Secrets: map[string]string{"dev/db": `{"DB_USER":"admin"}`},
+		Tags:    map[string]map[string]string{"dev/db": {"Environment": "dev"}},
+	}
+	calls := 0
+	mockRunner := &MockCommandRunner{}
+	prompter, out := newTestPrompter("", false)
+
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: newTestAWSSecretManager(client, &calls),
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "dev/db"},
+		Prompter:      prompter,
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// 本番タグがなければ確認しない
+	if out.Len() != 0 {
+		t.Errorf("Expected no prompt, got: %q", out.String())
+	}
+	if len(mockRunner.ExecutedCommands) != 1 {
+		t.Errorf("Expected 1 command execution, got: %d", len(mockRunner.ExecutedCommands))
+	}
+}
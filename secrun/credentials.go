@@ -0,0 +1,31 @@
+package secrun
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// credentialsDirEnv is the variable systemd sets to the service's credentials directory
+const credentialsDirEnv = "CREDENTIALS_DIRECTORY"
+
+// writeCredentials writes each env var as a read-only file named after its key,
+// following the layout systemd uses for LoadCredential
+func writeCredentials(dir string, envVars map[string]string) error {
+	for key, value := range envVars {
+		if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+			return fmt.Errorf("secret key %q is not a valid credential name", key)
+		}
+
+		path := filepath.Join(dir, key)
+		// A previous credential is read-only, so replace it rather than truncating it
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to replace credential %s: %w", key, err)
+		}
+		if err := os.WriteFile(path, []byte(value), 0400); err != nil {
+			return fmt.Errorf("failed to write credential %s: %w", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package secrun
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestApplication_Run_SystemdCreds(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(credentialsDirEnv, dir)
+
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin","DB_PASSWORD":"secure123"}`}},
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--systemd-creds"},
+	}
+
+	// 2回実行しても読み取り専用のファイルを置き換えられる
+	for i := 0; i < 2; i++ {
+		if err := app.Run(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// キーごとにファイルが0400で書き込まれる
+	for key, want := range map[string]string{"DB_USER": "admin", "DB_PASSWORD": "secure123"} {
+		path := filepath.Join(dir, key)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile(%s) error = %v", key, err)
+		}
+		if string(data) != want {
+			t.Errorf("credential %s = %q, want %q", key, data, want)
+		}
+		info, _ := os.Stat(path)
+		if runtime.GOOS != "windows" && info.Mode().Perm() != 0400 {
+			t.Errorf("credential %s mode = %v, want 0400", key, info.Mode().Perm())
+		}
+	}
+	if len(mockRunner.ExecutedCommands) != 2 {
+		t.Errorf("Expected the command to run, got: %d executions", len(mockRunner.ExecutedCommands))
+	}
+
+	// ディレクトリが未設定ならエラー
+	t.Setenv(credentialsDirEnv, "")
+	if err := app.Run(); err == nil {
+		t.Error("Expected error without $CREDENTIALS_DIRECTORY, got nil")
+	}
+}
+
+func TestWriteCredentials_InvalidName(t *testing.T) {
+	if err := writeCredentials(t.TempDir(), map[string]string{"../escape": "x"}); err == nil {
+		t.Error("Expected error for a key containing a path separator, got nil")
+	}
+}
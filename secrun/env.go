@@ -0,0 +1,466 @@
+package secrun
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Policies for secret values containing NUL bytes, which env vars cannot hold
+const (
+	// NulByteError refuses to run the command
+	NulByteError = "error"
+	// NulByteFile writes the value to a 0600 temp file and injects its path instead
+	NulByteFile = "file"
+)
+
+// Policies for env var names defined by more than one secret
+const (
+	// ConflictLast keeps the value from the secret listed last
+	ConflictLast = "last"
+	// ConflictFirst keeps the value from the secret listed first
+	ConflictFirst = "first"
+	// ConflictError refuses to run the command
+	ConflictError = "error"
+)
+
+// EnvCounts summarizes how many environment variables each source contributed
+type EnvCounts struct {
+	Inherited   int `json:"inherited"`
+	FromSecrets int `json:"from_secrets"`
+	Overridden  int `json:"overridden"`
+}
+
+// assembleEnv layers secret-derived variables over the inherited environment
+func assembleEnv(inherited []string, envVars map[string]string) ([]string, EnvCounts) {
+	counts := EnvCounts{FromSecrets: len(envVars)}
+
+	env := make([]string, 0, len(inherited)+len(envVars))
+	for _, entry := range inherited {
+		key, _, _ := strings.Cut(entry, "=")
+		if _, ok := envVars[key]; ok {
+			counts.Overridden++
+			continue
+		}
+		env = append(env, entry)
+		counts.Inherited++
+	}
+
+	keys := make([]string, 0, len(envVars))
+	for k := range envVars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, k+"="+envVars[k])
+	}
+
+	return env, counts
+}
+
+// Origins of the env vars passed to the command
+const (
+	EnvSourceInherited = "inherited"
+	EnvSourceSecret    = "secret"
+	EnvSourceJoin      = "join"
+	EnvSourceSet       = "set"
+)
+
+// EnvKeySource records where an env var passed to the command came from, without its value
+type EnvKeySource struct {
+	Key    string `json:"key"`
+	Source string `json:"source"`
+	// Secret names the secret the key came from
+	Secret string `json:"secret,omitempty"`
+	// OverridesInherited is set when the key replaced a variable of the inherited environment
+	OverridesInherited bool `json:"overridesInherited,omitempty"`
+}
+
+// envKeySources attributes each key of env to the inherited environment, the
+// secret in owners that set it, a --set or a --join
+func envKeySources(env, inherited []string, envVars map[string]string, owners map[string]string, sets []EnvTemplate) []EnvKeySource {
+	inheritedKeys := make(map[string]bool, len(inherited))
+	for _, entry := range inherited {
+		key, _, _ := strings.Cut(entry, "=")
+		inheritedKeys[key] = true
+	}
+	setKeys := make(map[string]bool, len(sets))
+	for _, set := range sets {
+		setKeys[set.Name] = true
+	}
+
+	sources := make([]EnvKeySource, 0, len(env))
+	for _, entry := range env {
+		key, _, _ := strings.Cut(entry, "=")
+		source := EnvKeySource{Key: key, Source: EnvSourceInherited}
+		if _, ok := envVars[key]; ok {
+			source.Source = EnvSourceJoin
+			if setKeys[key] {
+				source.Source = EnvSourceSet
+			} else if owner, ok := owners[key]; ok {
+				source.Source = EnvSourceSecret
+				source.Secret = owner
+			}
+			source.OverridesInherited = inheritedKeys[key]
+		}
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+// normalizeEnvKey uppercases a key and replaces characters invalid in env names with _
+func normalizeEnvKey(key string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(key) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+// KeyCollision records source keys that normalize to the same env var name
+type KeyCollision struct {
+	Key     string `json:"key"`
+	Kept    string `json:"kept"`
+	Dropped string `json:"dropped"`
+}
+
+// normalizeEnvKeys normalizes every key, keeping the first (in sorted order) on collision
+func normalizeEnvKeys(secretMap map[string]string) (map[string]string, []KeyCollision) {
+	keys := make([]string, 0, len(secretMap))
+	for k := range secretMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := make(map[string]string, len(secretMap))
+	origin := make(map[string]string, len(secretMap))
+	var collisions []KeyCollision
+	for _, k := range keys {
+		normalized := normalizeEnvKey(k)
+		if kept, ok := origin[normalized]; ok {
+			collisions = append(collisions, KeyCollision{Key: normalized, Kept: kept, Dropped: k})
+			continue
+		}
+		origin[normalized] = k
+		result[normalized] = secretMap[k]
+	}
+
+	return result, collisions
+}
+
+// filterEnv keeps only the entries whose names match one of the glob patterns
+func filterEnv(env []string, patterns []string) ([]string, error) {
+	filtered := make([]string, 0, len(env))
+	for _, entry := range env {
+		key, _, _ := strings.Cut(entry, "=")
+		for _, pattern := range patterns {
+			matched, err := path.Match(pattern, key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+			if matched {
+				filtered = append(filtered, entry)
+				break
+			}
+		}
+	}
+	return filtered, nil
+}
+
+// filterKeys keeps the keys of secretMap that match an include pattern, or all keys
+// when there are none, minus those matching an exclude pattern, which takes precedence.
+// Patterns are validated when parsed.
+func filterKeys(secretMap map[string]string, include, exclude []string) map[string]string {
+	matchAny := func(patterns []string, key string) bool {
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, key); matched {
+				return true
+			}
+		}
+		return false
+	}
+
+	filtered := make(map[string]string, len(secretMap))
+	for k, v := range secretMap {
+		if len(include) > 0 && !matchAny(include, k) {
+			continue
+		}
+		if matchAny(exclude, k) {
+			continue
+		}
+		filtered[k] = v
+	}
+	return filtered
+}
+
+// passEnv keeps only the entries of env whose name is listed in names
+func passEnv(env []string, names []string) []string {
+	keep := make(map[string]bool, len(names))
+	for _, name := range names {
+		keep[name] = true
+	}
+
+	passed := make([]string, 0, len(names))
+	for _, entry := range env {
+		key, _, _ := strings.Cut(entry, "=")
+		if keep[key] {
+			passed = append(passed, entry)
+		}
+	}
+	return passed
+}
+
+// applyJoins adds each join's target to envVars, built from keys already in envVars
+func applyJoins(envVars map[string]string, joins []Join) error {
+	for _, join := range joins {
+		values := make([]string, 0, len(join.Keys))
+		for _, key := range join.Keys {
+			v, ok := envVars[key]
+			if !ok {
+				return fmt.Errorf("--join %s: no secret key %s", join.Target, key)
+			}
+			values = append(values, v)
+		}
+		envVars[join.Target] = strings.Join(values, join.Sep)
+	}
+	return nil
+}
+
+// applySets renders each --set template against the inherited environment overlaid
+// with envVars and adds the result to envVars, so later templates see earlier ones
+func applySets(envVars map[string]string, inherited []string, sets []EnvTemplate) error {
+	if len(sets) == 0 {
+		return nil
+	}
+	vars := environMap(inherited)
+	for k, v := range envVars {
+		vars[k] = v
+	}
+	for _, set := range sets {
+		value, err := renderTemplate(set.Template, vars)
+		if err != nil {
+			return fmt.Errorf("--set %s: %w", set.Name, err)
+		}
+		envVars[set.Name] = value
+		vars[set.Name] = value
+	}
+	return nil
+}
+
+// lookupEnv returns the last value of key in env; on Windows keys are case-insensitive
+func lookupEnv(env []string, key string) (string, bool) {
+	for i := len(env) - 1; i >= 0; i-- {
+		k, v, ok := strings.Cut(env[i], "=")
+		if ok && (k == key || runtime.GOOS == "windows" && strings.EqualFold(k, key)) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// missingKeys returns the required keys that have no entry in env
+func missingKeys(env []string, required []string) []string {
+	present := make(map[string]bool, len(env))
+	for _, entry := range env {
+		key, _, _ := strings.Cut(entry, "=")
+		present[key] = true
+	}
+
+	var missing []string
+	for _, key := range required {
+		if !present[key] {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// unusedSecrets returns, in order, the secrets none of whose keys reach the final
+// environment, given which secret supplied each key's final value
+func unusedSecrets(secrets []string, owners map[string]string, env []string) []string {
+	used := make(map[string]bool, len(secrets))
+	for _, entry := range env {
+		key, _, _ := strings.Cut(entry, "=")
+		if owner, ok := owners[key]; ok {
+			used[owner] = true
+		}
+	}
+
+	var unused []string
+	for _, name := range secrets {
+		if !used[name] {
+			unused = append(unused, name)
+		}
+	}
+	return unused
+}
+
+// dotenvQuoter escapes a value for a double-quoted dotenv string
+var dotenvQuoter = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "$", `\$`)
+
+// formatDotenvValue quotes a value when it contains characters dotenv parsers treat specially
+func formatDotenvValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\r\n\"'`\\$#=") {
+		return value
+	}
+	return `"` + dotenvQuoter.Replace(value) + `"`
+}
+
+// formatDotenv renders env vars as KEY=VALUE lines sorted by key
+func formatDotenv(envVars map[string]string) string {
+	keys := make([]string, 0, len(envVars))
+	for k := range envVars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k + "=" + formatDotenvValue(envVars[k]) + "\n")
+	}
+	return b.String()
+}
+
+// shellQuote wraps a value in single quotes for a POSIX shell; an embedded single
+// quote closes the string, is escaped and reopens it
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// isShellName reports whether name can be assigned by a POSIX shell
+func isShellName(name string) bool {
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		return false
+	}
+	for _, c := range name {
+		if c != '_' && (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// formatExport renders env vars as export KEY='VALUE' lines sorted by key, for eval
+// in a POSIX shell. Keys a shell cannot assign are rejected rather than skipped.
+func formatExport(envVars map[string]string) (string, error) {
+	keys := make([]string, 0, len(envVars))
+	for k := range envVars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		if !isShellName(k) {
+			return "", fmt.Errorf("cannot export %s: not a valid shell variable name; use --env-uppercase-replace or --rename", k)
+		}
+		b.WriteString("export " + k + "=" + shellQuote(envVars[k]) + "\n")
+	}
+	return b.String(), nil
+}
+
+// writeEnvFile writes env vars to a dotenv file readable only by the owner
+func writeEnvFile(filename string, envVars map[string]string) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write env file: %w", err)
+	}
+	defer f.Close()
+
+	// Tighten the mode of a file that already existed
+	if err := f.Chmod(0600); err != nil {
+		return fmt.Errorf("failed to write env file: %w", err)
+	}
+	if _, err := f.WriteString(formatDotenv(envVars)); err != nil {
+		return fmt.Errorf("failed to write env file: %w", err)
+	}
+	return f.Close()
+}
+
+// routeNulValues applies the --on-nul-byte policy to values containing NUL bytes.
+// With NulByteFile the value is written to a temp file whose path replaces it;
+// the paths of the files written are returned so they can be removed later.
+func routeNulValues(envVars map[string]string, policy string) ([]string, error) {
+	keys := make([]string, 0, len(envVars))
+	for k, v := range envVars {
+		if strings.IndexByte(v, 0) >= 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var files []string
+	for _, k := range keys {
+		if policy != NulByteFile {
+			return files, fmt.Errorf("value of %s contains a NUL byte and cannot be passed as an env var; use --on-nul-byte file to pass it as a file", k)
+		}
+
+		name, err := writeValueFile(k, envVars[k])
+		if name != "" {
+			files = append(files, name)
+		}
+		if err != nil {
+			return files, err
+		}
+		envVars[k] = name
+	}
+	return files, nil
+}
+
+// DefaultFileSuffix is appended to the name of an env var whose value was moved to a file
+const DefaultFileSuffix = "_FILE"
+
+// routeLargeValues moves values longer than threshold bytes to temp files, replacing
+// KEY with KEY+suffix set to the file path. It returns the keys moved and the paths of
+// the files written, which are returned even on error so they can be removed.
+func routeLargeValues(envVars map[string]string, threshold int64, suffix string) ([]string, []string, error) {
+	keys := make([]string, 0, len(envVars))
+	for k, v := range envVars {
+		if int64(len(v)) > threshold {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var files []string
+	for _, k := range keys {
+		if _, ok := envVars[k+suffix]; ok {
+			return keys, files, fmt.Errorf("cannot move the value of %s to a file: %s is already set", k, k+suffix)
+		}
+		name, err := writeValueFile(k, envVars[k])
+		if name != "" {
+			files = append(files, name)
+		}
+		if err != nil {
+			return keys, files, err
+		}
+		delete(envVars, k)
+		envVars[k+suffix] = name
+	}
+	return keys, files, nil
+}
+
+// writeValueFile writes the value of key to a new 0600 temp file and returns its path,
+// which is also returned if writing fails after the file was created
+func writeValueFile(key, value string) (string, error) {
+	// CreateTemp creates the file with mode 0600
+	f, err := os.CreateTemp("", "awsecrun-secret-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create file for %s: %w", key, err)
+	}
+	if _, err := f.WriteString(value); err != nil {
+		f.Close()
+		return f.Name(), fmt.Errorf("failed to write file for %s: %w", key, err)
+	}
+	if err := f.Close(); err != nil {
+		return f.Name(), fmt.Errorf("failed to write file for %s: %w", key, err)
+	}
+	return f.Name(), nil
+}
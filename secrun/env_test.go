@@ -0,0 +1,849 @@
+package secrun
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestAssembleEnv(t *testing.T) {
+	inherited := []string{"PATH=/usr/bin", "HOME=/root", "DB_HOST=old", "LANG=C"}
+	envVars := map[string]string{
+		"DB_HOST":     "db.local",
+		"DB_PASSWORD": "secure123",
+	}
+
+	env, counts := assembleEnv(inherited, envVars)
+
+	// 継承3件、シークレット2件、上書き1件
+	want := EnvCounts{Inherited: 3, FromSecrets: 2, Overridden: 1}
+	if counts != want {
+		t.Errorf("assembleEnv() counts = %+v, want %+v", counts, want)
+	}
+
+	// 上書きされた変数は一度だけ、シークレットの値で現れる
+	dbHost := 0
+	for _, entry := range env {
+		if entry == "DB_HOST=old" {
+			t.Error("Expected inherited DB_HOST to be overridden")
+		}
+		if entry == "DB_HOST=db.local" {
+			dbHost++
+		}
+	}
+	if dbHost != 1 {
+		t.Errorf("Expected DB_HOST=db.local exactly once, got %d", dbHost)
+	}
+	if len(env) != 5 {
+		t.Errorf("Expected 5 environment entries, got %d: %v", len(env), env)
+	}
+}
+
+func TestApplication_Run_LogsEnvCounts(t *testing.T) {
+	t.Setenv("DB_HOST", "inherited")
+
+	mockLogger := &MockLogger{}
+	app := &Application{
+		Logger: mockLogger,
+		SecretManager: &MockSecretManager{Secrets: map[string]string{
+			"db":  `{"DB_HOST":"db.local","DB_USER":"admin"}`,
+			"api": `{"API_KEY":"xyz"}`,
+		}},
+		CommandRunner: &MockCommandRunner{},
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--key", "api"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 集計ログの値を確認
+	for _, log := range mockLogger.Logs {
+		if log.Message != "Assembled environment" {
+			continue
+		}
+		counts, ok := log.Data.(EnvCounts)
+		if !ok {
+			t.Fatalf("Expected EnvCounts data, got: %T", log.Data)
+		}
+		if counts.FromSecrets != 3 || counts.Overridden != 1 {
+			t.Errorf("counts = %+v, want from_secrets=3 overridden=1", counts)
+		}
+		return
+	}
+	t.Error("Expected log summarizing environment counts")
+}
+
+func TestApplication_Run_VerboseLogsEnvSources(t *testing.T) {
+	t.Setenv("DB_HOST", "inherited-host")
+
+	mockLogger := &MockLogger{}
+	app := &Application{
+		Logger: mockLogger,
+		SecretManager: &MockSecretManager{Secrets: map[string]string{
+			"db":  `{"DB_HOST":"db.local","DB_USER":"admin"}`,
+			"api": `{"API_KEY":"xyz"}`,
+		}},
+		CommandRunner: &MockCommandRunner{},
+		Args:          []string{"program", "/usr/bin/env", "--verbose", "--key", "db", "--key", "api"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 注入されたキーごとにdebugログが出て、値が含まれないことを確認
+	want := map[string]EnvKeySource{
+		"DB_HOST": {Key: "DB_HOST", Source: EnvSourceSecret, Secret: "db", OverridesInherited: true},
+		"DB_USER": {Key: "DB_USER", Source: EnvSourceSecret, Secret: "db"},
+		"API_KEY": {Key: "API_KEY", Source: EnvSourceSecret, Secret: "api"},
+	}
+	got := map[string]EnvKeySource{}
+	inherited := 0
+	for _, log := range mockLogger.Logs {
+		if log.Message != "Setting env var" {
+			continue
+		}
+		if log.Level != "debug" {
+			t.Errorf("level = %q, want debug", log.Level)
+		}
+		source, ok := log.Data.(EnvKeySource)
+		if !ok {
+			t.Fatalf("Expected EnvKeySource data, got: %T", log.Data)
+		}
+		encoded, _ := json.Marshal(source)
+		for _, value := range []string{"db.local", "admin", "xyz", "inherited-host"} {
+			if strings.Contains(string(encoded), value) {
+				t.Errorf("log entry %s leaks value %q", encoded, value)
+			}
+		}
+		if source.Source == EnvSourceInherited {
+			inherited++
+			continue
+		}
+		got[source.Key] = source
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sources = %+v, want %+v", got, want)
+	}
+	if inherited == 0 {
+		t.Error("Expected inherited env vars to be logged")
+	}
+}
+
+func TestApplication_Run_OnConflict(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{name: "default", want: "DB_HOST=replica.local"},
+		{name: "last", args: []string{"--on-conflict", "last"}, want: "DB_HOST=replica.local"},
+		{name: "first", args: []string{"--on-conflict", "first"}, want: "DB_HOST=primary.local"},
+		{name: "error", args: []string{"--on-conflict", "error"}, wantErr: "env var DB_HOST is defined by both secret primary and secret replica"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &MockCommandRunner{}
+			args := append([]string{"program", "/usr/bin/env"}, tt.args...)
+			app := &Application{
+				Logger: &MockLogger{},
+				SecretManager: &MockSecretManager{Secrets: map[string]string{
+					"primary": `{"DB_HOST":"primary.local","DB_USER":"admin"}`,
+					"replica": `{"DB_HOST":"replica.local"}`,
+				}},
+				CommandRunner: runner,
+				Args:          append(args, "--key", "primary", "--key", "replica"),
+			}
+
+			err := app.Run()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want containing %q", err, tt.wantErr)
+				}
+				// 衝突時はコマンドを実行しない
+				if len(runner.ExecutedCommands) != 0 {
+					t.Error("Expected command not to run")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			env := strings.Join(runner.ExecutedCommands[0].Env, "\n")
+			for _, want := range []string{tt.want, "DB_USER=admin"} {
+				if !strings.Contains(env, want) {
+					t.Errorf("env missing %q", want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplication_Run_IncludeExclude(t *testing.T) {
+	secret := `{"DB_USER":"admin","DB_PASSWORD":"secure123","DB_HOST":"db.local","API_KEY":"xyz","API_SECRET":"abc"}`
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "include only", args: []string{"--include", "DB_*"}, want: []string{"DB_HOST", "DB_PASSWORD", "DB_USER"}},
+		{name: "exclude only", args: []string{"--exclude", "*_PASSWORD", "--exclude", "API_SECRET"}, want: []string{"API_KEY", "DB_HOST", "DB_USER"}},
+		{name: "combined", args: []string{"--include", "DB_*", "--include", "API_KEY", "--exclude", "DB_PASSWORD"}, want: []string{"API_KEY", "DB_HOST", "DB_USER"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRunner := &MockCommandRunner{}
+			app := &Application{
+				Logger:        &MockLogger{},
+				SecretManager: &MockSecretManager{Secrets: map[string]string{"shared": secret}},
+				CommandRunner: mockRunner,
+				Args:          append([]string{"program", "/usr/bin/env", "--key", "shared"}, tt.args...),
+			}
+
+			if err := app.Run(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			// シークレット由来のキーだけを取り出して比較する
+			var got []string
+			for _, entry := range mockRunner.ExecutedCommands[0].Env {
+				key, _, _ := strings.Cut(entry, "=")
+				if strings.Contains(secret, `"`+key+`"`) {
+					got = append(got, key)
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("keys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// 不正なパターンと--keyなしの指定はエラー
+	for _, args := range [][]string{
+		{"program", "/usr/bin/env", "--key", "shared", "--include", "DB_["},
+		{"program", "/usr/bin/env", "--exclude", "DB_*"},
+	} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("parseArgs(%v) expected error, got nil", args)
+		}
+	}
+}
+
+func TestNormalizeEnvKeys(t *testing.T) {
+	got, collisions := normalizeEnvKeys(map[string]string{
+		"db-host": "db.local",
+		"api.key": "xyz",
+		"Port":    "5432",
+	})
+
+	want := map[string]string{"DB_HOST": "db.local", "API_KEY": "xyz", "PORT": "5432"}
+	if len(got) != len(want) {
+		t.Fatalf("normalizeEnvKeys() = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("normalizeEnvKeys()[%s] = %q, want %q", k, got[k], v)
+		}
+	}
+	if len(collisions) != 0 {
+		t.Errorf("Expected no collisions, got: %v", collisions)
+	}
+
+	// 正規化後に同じ名前になるキーは衝突として報告される
+	got, collisions = normalizeEnvKeys(map[string]string{"db-host": "a", "db.host": "b"})
+	if len(got) != 1 || got["DB_HOST"] != "a" {
+		t.Errorf("normalizeEnvKeys() = %v, want DB_HOST=a", got)
+	}
+	if len(collisions) != 1 || collisions[0].Kept != "db-host" || collisions[0].Dropped != "db.host" {
+		t.Errorf("Expected collision keeping db-host over db.host, got: %v", collisions)
+	}
+}
+
+func TestApplication_Run_EnvUppercaseReplace(t *testing.T) {
+	mockLogger := &MockLogger{}
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        mockLogger,
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"db-host":"a","db.host":"b","api.key":"xyz"}`}},
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--env-uppercase-replace"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	env := strings.Join(mockRunner.ExecutedCommands[0].Env, "\n")
+	if !strings.Contains(env, "DB_HOST=a") || !strings.Contains(env, "API_KEY=xyz") {
+		t.Errorf("Expected normalized keys in env, got: %v", mockRunner.ExecutedCommands[0].Env)
+	}
+
+	// 衝突は警告として記録される
+	warned := false
+	for _, log := range mockLogger.Logs {
+		if log.Level == "warn" && strings.Contains(log.Message, "collide") {
+			warned = true
+		}
+	}
+	if !warned {
+		t.Error("Expected warning about colliding keys")
+	}
+}
+
+func TestFilterEnv(t *testing.T) {
+	env := []string{"PATH=/usr/bin", "HOME=/root", "DB_HOST=db", "DB_PASSWORD=secret", "API_KEY=xyz"}
+
+	got, err := filterEnv(env, []string{"PATH", "DB_*"})
+	if err != nil {
+		t.Fatalf("filterEnv() error = %v", err)
+	}
+	want := []string{"PATH=/usr/bin", "DB_HOST=db", "DB_PASSWORD=secret"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("filterEnv() = %v, want %v", got, want)
+	}
+
+	// 不正なパターンはエラー
+	if _, err := filterEnv(env, []string{"["}); err == nil {
+		t.Error("Expected error for invalid pattern, got nil")
+	}
+}
+
+func TestApplication_Run_ChildEnvAllow(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+	t.Setenv("SHOULD_NOT_PASS", "1")
+
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin","DB_PASSWORD":"secure123","API_KEY":"xyz"}`}},
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--child-env-allow", "HOME,DB_*"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 許可リストに一致する変数だけがランナーに渡される
+	got := mockRunner.ExecutedCommands[0].Env
+	want := []string{"HOME=/home/test", "DB_PASSWORD=secure123", "DB_USER=admin"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Env = %v, want %v", got, want)
+	}
+}
+
+func TestApplication_Run_Require(t *testing.T) {
+	t.Setenv("INHERITED_KEY", "1")
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "すべて存在", args: []string{"--key", "db", "--require", "DB_USER,DB_PASSWORD,INHERITED_KEY"}},
+		{name: "一部が欠落", args: []string{"--key", "db", "--require", "DB_USER,DB_PASWORD,API_KEY"}, wantErr: "DB_PASWORD, API_KEY"},
+		{name: "シークレットなしでも継承した環境で判定", args: []string{"--require", "INHERITED_KEY"}},
+		{name: "シークレットなしで欠落", args: []string{"--require", "DB_USER"}, wantErr: "DB_USER"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRunner := &MockCommandRunner{}
+			app := &Application{
+				Logger:        &MockLogger{},
+				SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin","DB_PASSWORD":"secure123"}`}},
+				CommandRunner: mockRunner,
+				Args:          append([]string{"program", "/usr/bin/env"}, tt.args...),
+			}
+
+			err := app.Run()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+
+			// 欠落したキーがすべてエラーに列挙され、コマンドは実行されない
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Run() error = %v, want it to list %q", err, tt.wantErr)
+			}
+			if len(mockRunner.ExecutedCommands) != 0 {
+				t.Errorf("Expected the command not to run, got: %d executions", len(mockRunner.ExecutedCommands))
+			}
+		})
+	}
+}
+
+func TestApplication_Run_WarnUnusedSecrets(t *testing.T) {
+	secrets := map[string]string{
+		"db":    `{"DB_USER":"admin","DB_PASSWORD":"secure123"}`,
+		"api":   `{"API_KEY":"xyz"}`,
+		"dbnew": `{"DB_USER":"root","DB_PASSWORD":"rotated"}`,
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantUnused []string
+	}{
+		{name: "すべて使われる", args: []string{"--key", "db", "--key", "api"}},
+		{name: "許可リストで除外", args: []string{"--key", "db", "--key", "api", "--child-env-allow", "DB_*"}, wantUnused: []string{"api"}},
+		{name: "後のシークレットで上書き", args: []string{"--key", "db", "--key", "dbnew"}, wantUnused: []string{"db"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &MockLogger{}
+			app := &Application{
+				Logger:        logger,
+				SecretManager: &MockSecretManager{Secrets: secrets},
+				CommandRunner: &MockCommandRunner{},
+				Args:          append([]string{"program", "/usr/bin/env", "--warn-unused-secrets"}, tt.args...),
+			}
+			if err := app.Run(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			// 1つもキーが渡らなかったシークレットが警告に列挙される
+			var unused []string
+			for _, log := range logger.Logs {
+				if log.Level == "warn" && strings.Contains(log.Message, "no keys in the command environment") {
+					unused = log.Data.(map[string]interface{})["secrets"].([]string)
+				}
+			}
+			if strings.Join(unused, ",") != strings.Join(tt.wantUnused, ",") {
+				t.Errorf("unused secrets = %v, want %v", unused, tt.wantUnused)
+			}
+		})
+	}
+}
+
+func TestFormatDotenv(t *testing.T) {
+	got := formatDotenv(map[string]string{
+		"PLAIN":     "value",
+		"SPACES":    "hello world",
+		"MULTILINE": "line1\nline2",
+		"QUOTES":    `say "hi"`,
+		"DOLLAR":    "pa$$word",
+		"EMPTY":     "",
+	})
+
+	want := strings.Join([]string{
+		`DOLLAR="pa\$\$word"`,
+		`EMPTY=""`,
+		`MULTILINE="line1\nline2"`,
+		`PLAIN=value`,
+		`QUOTES="say \"hi\""`,
+		`SPACES="hello world"`,
+	}, "\n") + "\n"
+	if got != want {
+		t.Errorf("formatDotenv() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestApplication_Run_WriteEnvFile(t *testing.T) {
+	t.Setenv("AWSECRUN_TEST_INHERITED", "parent")
+	path := filepath.Join(t.TempDir(), ".env")
+	// 既存ファイルの権限も絞る
+	if err := os.WriteFile(path, []byte("OLD=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_PASSWORD":"p@ss \"word\"","DB_USER":"admin"}`}},
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--write-env-file", path},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// コマンドは実行しない
+	if len(mockRunner.ExecutedCommands) != 0 {
+		t.Errorf("Expected no command execution, got: %d", len(mockRunner.ExecutedCommands))
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// シークレット由来の変数のみが書き込まれる
+	data, _ := os.ReadFile(path)
+	want := "DB_PASSWORD=\"p@ss \\\"word\\\"\"\nDB_USER=admin\n"
+	if string(data) != want {
+		t.Errorf("env file =\n%s\nwant\n%s", data, want)
+	}
+}
+
+func TestApplication_Run_Join(t *testing.T) {
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin","DB_PASS":"secure123","DB_HOST":"db.internal"}`}},
+		CommandRunner: mockRunner,
+		Args: []string{"program", "/usr/bin/env", "--key", "db",
+			"--join", "DB_USER,DB_PASS=CREDS::",
+			"--join", `DB_HOST,DB_USER=TAG:" / "`,
+			"--join", "DB_USER,DB_HOST=PLAIN"},
+	}
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 指定した区切り文字でキーの値を連結した変数が追加される
+	env := strings.Join(mockRunner.ExecutedCommands[0].Env, "\n")
+	for _, want := range []string{"CREDS=admin:secure123", "TAG=db.internal / admin", "PLAIN=admindb.internal", "DB_USER=admin"} {
+		if !strings.Contains(env, want) {
+			t.Errorf("Expected %s, got: %s", want, env)
+		}
+	}
+
+	// 存在しないキーはエラー
+	app.Args = []string{"program", "/usr/bin/env", "--key", "db", "--join", "DB_USER,DB_PASSWORD=CREDS::"}
+	if err := app.Run(); err == nil || !strings.Contains(err.Error(), "DB_PASSWORD") {
+		t.Errorf("Expected missing key error, got: %v", err)
+	}
+}
+
+func TestApplication_Run_CleanEnv(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+	t.Setenv("AMBIENT_SECRET", "leak")
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "許可リストなし", args: []string{"--clean-env"}, want: []string{"DB_USER=admin"}},
+		{name: "許可リストあり", args: []string{"--clean-env", "--pass-env", "PATH", "--pass-env", "HOME"}, want: []string{"PATH=" + os.Getenv("PATH"), "HOME=/home/test", "DB_USER=admin"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRunner := &MockCommandRunner{}
+			app := &Application{
+				Logger:        &MockLogger{},
+				SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}},
+				CommandRunner: mockRunner,
+				Args:          append([]string{"program", "/usr/bin/env", "--key", "db"}, tt.args...),
+			}
+			if err := app.Run(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			// シークレットと明示的に許可した変数だけが渡される
+			got := append([]string(nil), mockRunner.ExecutedCommands[0].Env...)
+			sort.Strings(got)
+			sort.Strings(tt.want)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("Env = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// --clean-envなしの--pass-envはエラー
+	if _, err := parseArgs([]string{"program", "/usr/bin/env", "--pass-env", "PATH"}); err == nil {
+		t.Error("Expected error for --pass-env without --clean-env, got nil")
+	}
+}
+
+func TestDefaultCommandRunner_CleanEnvResolvesCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a Unix command")
+	}
+
+	// 子プロセスにPATHを渡さなくても、コマンドは自身のPATHで解決される
+	runner := NewCommandRunner()
+	runner.Stdout = &strings.Builder{}
+	if err := runner.Run("true", nil, []string{"DB_USER=admin"}); err != nil {
+		t.Errorf("Run() with an empty PATH error = %v", err)
+	}
+}
+
+func TestApplication_Run_ResolvesCommandWithSecretPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+
+	// シークレットのPATHにだけ存在するコマンドを用意する
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho resolved from secret PATH\n"
+	if err := os.WriteFile(filepath.Join(dir, "awsecrun-test-cmd"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	runner := NewCommandRunner()
+	runner.Stdout = &out
+	mockLogger := &MockLogger{}
+	app := &Application{
+		Logger:        mockLogger,
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"paths": `{"PATH":"` + dir + `"}`}},
+		CommandRunner: runner,
+		Args:          []string{"program", "awsecrun-test-cmd", "--key", "paths"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := out.String(); got != "resolved from secret PATH\n" {
+		t.Errorf("output = %q", got)
+	}
+	// 解決した絶対パスがdebugログに出る
+	resolved := false
+	for _, log := range mockLogger.Logs {
+		if log.Message == "Resolved command path" && log.Level == "debug" {
+			resolved = log.Data.(map[string]string)["path"] == filepath.Join(dir, "awsecrun-test-cmd")
+		}
+	}
+	if !resolved {
+		t.Errorf("Expected a debug log with the resolved path, got: %+v", mockLogger.Logs)
+	}
+
+	// シークレットのPATHで上書きされると、元のPATHのコマンドは見つからない
+	app.Args = []string{"program", "true", "--key", "paths"}
+	if err := app.Run(); err == nil || !strings.Contains(err.Error(), "command true not found in PATH") {
+		t.Errorf("Expected command not found error, got: %v", err)
+	}
+}
+
+func TestDefaultCommandRunner_NoInheritPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a Unix command")
+	}
+
+	// PATHを受け取らないコマンドは自身のPATHへフォールバックしない
+	runner := NewCommandRunner()
+	runner.NoInheritPath = true
+	err := runner.Run("true", nil, []string{"DB_USER=admin"})
+	if err == nil || !strings.Contains(err.Error(), "--no-inherit-path") {
+		t.Errorf("Expected error without PATH under --no-inherit-path, got: %v", err)
+	}
+}
+
+func TestApplication_Run_OnNulByte(t *testing.T) {
+	secrets := map[string]string{"key": `{"SIGNING_KEY":"ab\u0000cd","DB_USER":"admin"}`}
+
+	// デフォルトではNULを含む値があるとコマンドを実行せずにエラーになる
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: secrets},
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "key"},
+	}
+	err := app.Run()
+	if err == nil || !strings.Contains(err.Error(), "SIGNING_KEY") {
+		t.Fatalf("Expected NUL byte error naming SIGNING_KEY, got: %v", err)
+	}
+	if strings.Contains(err.Error(), "ab") {
+		t.Errorf("Error leaked the secret value: %v", err)
+	}
+	if len(mockRunner.ExecutedCommands) != 0 {
+		t.Errorf("Expected no command to run, got: %v", mockRunner.ExecutedCommands)
+	}
+
+	// fileではバイト列をそのままファイルに書き、パスを注入する
+	var path string
+	var contents []byte
+	mockRunner = &MockCommandRunner{RunFunc: func(_ string, _ []string, env []string) error {
+		for _, entry := range env {
+			if v, ok := strings.CutPrefix(entry, "SIGNING_KEY="); ok {
+				path = v
+			}
+		}
+		var err error
+		contents, err = os.ReadFile(path)
+		return err
+	}}
+	app = &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: secrets},
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "key", "--on-nul-byte", "file"},
+	}
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(contents) != "ab\x00cd" {
+		t.Errorf("file contents = %q, want %q", contents, "ab\x00cd")
+	}
+	env := strings.Join(mockRunner.ExecutedCommands[0].Env, "\n")
+	if !strings.Contains(env, "DB_USER=admin") {
+		t.Errorf("Expected other values to stay in the environment, got: %s", env)
+	}
+	// コマンドの終了後にファイルは削除される
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed, stat error = %v", path, err)
+	}
+
+	if _, err := parseArgs([]string{"program", "/bin/true", "--on-nul-byte", "strip"}); err == nil {
+		t.Error("Expected error for unknown --on-nul-byte policy, got nil")
+	}
+}
+
+func TestApplication_Run_FileThreshold(t *testing.T) {
+	cert := strings.Repeat("C", 100)
+	secrets := map[string]string{"tls": `{"TLS_CERT":"` + cert + `","TLS_NAME":"api"}`}
+
+	// しきい値以下の値はそのまま、超える値はファイルのパスとして_FILE付きで渡す
+	var path string
+	var contents []byte
+	mockRunner := &MockCommandRunner{RunFunc: func(_ string, _ []string, env []string) error {
+		for _, entry := range env {
+			if v, ok := strings.CutPrefix(entry, "TLS_CERT_FILE="); ok {
+				path = v
+			}
+		}
+		var err error
+		contents, err = os.ReadFile(path)
+		return err
+	}}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: secrets},
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "tls", "--file-threshold", "64"},
+	}
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(contents) != cert {
+		t.Errorf("file contents = %q, want %q", contents, cert)
+	}
+	env := strings.Join(mockRunner.ExecutedCommands[0].Env, "\n")
+	if !strings.Contains(env, "TLS_NAME=api") {
+		t.Errorf("Expected the small value inline, got: %s", env)
+	}
+	if strings.Contains(env, "TLS_CERT=") {
+		t.Errorf("Expected the large value not to be inline, got: %s", env)
+	}
+	if info, err := os.Stat(path); err == nil {
+		t.Errorf("Expected %s to be removed, got mode %v", path, info.Mode())
+	}
+
+	// サフィックスは変更できる
+	mockRunner = &MockCommandRunner{}
+	app.CommandRunner = mockRunner
+	app.Args = []string{"program", "/usr/bin/env", "--key", "tls", "--file-threshold", "64", "--file-suffix", "_PATH"}
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if env := strings.Join(mockRunner.ExecutedCommands[0].Env, "\n"); !strings.Contains(env, "TLS_CERT_PATH=") {
+		t.Errorf("Expected TLS_CERT_PATH in env, got: %s", env)
+	}
+
+	if _, err := parseArgs([]string{"program", "/bin/true", "--file-suffix", "_PATH"}); err == nil {
+		t.Error("Expected error for --file-suffix without --file-threshold, got nil")
+	}
+}
+
+func TestFormatExport(t *testing.T) {
+	envVars := map[string]string{
+		"QUOTED":  "it's a 'test'",
+		"SPACED":  "hello world",
+		"NEWLINE": "line1\nline2",
+		"PLAIN":   "admin",
+	}
+
+	got, err := formatExport(envVars)
+	if err != nil {
+		t.Fatalf("formatExport() error = %v", err)
+	}
+	// キー順に並び、単一引用符は '\'' でエスケープされる
+	want := "export NEWLINE='line1\nline2'\n" +
+		"export PLAIN='admin'\n" +
+		`export QUOTED='it'\''s a '\''test'\'''` + "\n" +
+		"export SPACED='hello world'\n"
+	if got != want {
+		t.Errorf("formatExport() = %q, want %q", got, want)
+	}
+
+	// シェルで代入できないキーはエラー
+	if _, err := formatExport(map[string]string{"DB-HOST": "x"}); err == nil || !strings.Contains(err.Error(), "DB-HOST") {
+		t.Errorf("Expected error naming DB-HOST, got: %v", err)
+	}
+}
+
+func TestApplication_Run_Export(t *testing.T) {
+	// 標準出力をキャプチャする
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	defer func() {
+		os.Stdout = oldStdout
+	}()
+
+	values := map[string]string{
+		"QUOTED":  "it's \"quoted\" $HOME `x`",
+		"SPACED":  "  leading and trailing  ",
+		"NEWLINE": "line1\nline2\n",
+	}
+	secret, _ := json.Marshal(values)
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"db": string(secret)}},
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "--export", "--key", "db"},
+	}
+
+	err := app.Run()
+	w.Close()
+	var buf strings.Builder
+	io.Copy(&buf, r)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(mockRunner.ExecutedCommands) != 0 {
+		t.Errorf("Expected no command to run, got: %v", mockRunner.ExecutedCommands)
+	}
+
+	// シェルでevalすると元の値がそのまま復元される
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+	for key, want := range values {
+		out, err := exec.Command(sh, "-c", `eval "$1"; printf '%s' "$`+key+`"`, "sh", buf.String()).Output()
+		if err != nil {
+			t.Fatalf("eval failed: %v", err)
+		}
+		if string(out) != want {
+			t.Errorf("%s = %q, want %q", key, out, want)
+		}
+	}
+
+	// コマンドや余分な引数は受け付けない
+	for _, args := range [][]string{{"program", "--export"}, {"program", "--export", "--key", "db", "/usr/bin/env"}} {
+		opts, err := parseArgs(args)
+		if err == nil {
+			err = opts.Validate()
+		}
+		if err == nil {
+			t.Errorf("Expected error for %v", args)
+		}
+	}
+}
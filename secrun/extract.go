@@ -0,0 +1,293 @@
+package secrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// decodeJSON decodes a secret string into generic JSON values, keeping numbers intact
+func decodeJSON(secretString string) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader([]byte(secretString)))
+	dec.UseNumber()
+
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// resolveJSONPointer returns the value referenced by an RFC 6901 JSON pointer
+func resolveJSONPointer(doc interface{}, pointer string) (interface{}, error) {
+	if pointer == "" {
+		return doc, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q: must start with /", pointer)
+	}
+
+	current := doc
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+
+		switch node := current.(type) {
+		case map[string]interface{}:
+			child, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("JSON pointer %q: key %q not found", pointer, token)
+			}
+			current = child
+		case []interface{}:
+			idx, err := strconv.Atoi(token)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, fmt.Errorf("JSON pointer %q: invalid array index %q", pointer, token)
+			}
+			current = node[idx]
+		default:
+			return nil, fmt.Errorf("JSON pointer %q: cannot descend into a scalar value", pointer)
+		}
+	}
+
+	return current, nil
+}
+
+// flattenJSON collects the leaf values of a JSON tree as env vars named by their path
+func flattenJSON(value interface{}, prefix, sep string, out map[string]string) {
+	switch node := value.(type) {
+	case map[string]interface{}:
+		for k, v := range node {
+			flattenJSON(v, joinKey(prefix, strings.ToUpper(k), sep), sep, out)
+		}
+	case []interface{}:
+		for i, v := range node {
+			flattenJSON(v, joinKey(prefix, strconv.Itoa(i), sep), sep, out)
+		}
+	default:
+		out[prefix] = stringifyJSON(node)
+	}
+}
+
+// joinKey appends a path segment to a flattened key
+func joinKey(prefix, segment, sep string) string {
+	if prefix == "" || strings.HasSuffix(prefix, sep) {
+		return prefix + segment
+	}
+	return prefix + sep + segment
+}
+
+// stringifyJSON renders a scalar JSON value as an env var value
+func stringifyJSON(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// extractSecret injects the sub-trees selected by the extractions under their prefixes
+func extractSecret(secretString string, extracts []Extraction) (map[string]string, error) {
+	doc, err := decodeJSON(secretString)
+	if err != nil {
+		return nil, fmt.Errorf("secret is not valid JSON: %w", err)
+	}
+
+	result := make(map[string]string)
+	for _, ex := range extracts {
+		node, err := resolveJSONPointer(doc, ex.Pointer)
+		if err != nil {
+			return nil, err
+		}
+
+		switch node.(type) {
+		case map[string]interface{}, []interface{}:
+			flattenJSON(node, ex.Prefix, "_", result)
+		default:
+			return nil, fmt.Errorf("JSON pointer %q does not reference an object or array", ex.Pointer)
+		}
+	}
+
+	return result, nil
+}
+
+// selectSecretField returns the value at a dotted path such as database.password.
+// Objects and arrays are returned as compact JSON; array elements are addressed by index.
+func selectSecretField(secretString, path string) (string, error) {
+	doc, err := decodeJSON(secretString)
+	if err != nil {
+		return "", fmt.Errorf("secret is not valid JSON: %w", err)
+	}
+
+	current := doc
+	for _, segment := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			child, ok := node[segment]
+			if !ok {
+				return "", fmt.Errorf("selector %q: key %q not found", path, segment)
+			}
+			current = child
+		case []interface{}:
+			idx, err := strconv.Atoi(segment)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return "", fmt.Errorf("selector %q: invalid array index %q", path, segment)
+			}
+			current = node[idx]
+		default:
+			return "", fmt.Errorf("selector %q: cannot descend into a scalar value at %q", path, segment)
+		}
+	}
+
+	switch current.(type) {
+	case map[string]interface{}, []interface{}:
+		encoded, err := json.Marshal(current)
+		if err != nil {
+			return "", err
+		}
+		return string(encoded), nil
+	}
+	return stringifyJSON(current), nil
+}
+
+// ArrayIndex selects an element of a JSON array secret and optionally one of its fields
+type ArrayIndex struct {
+	Index int    `json:"index"`
+	Field string `json:"field,omitempty"`
+}
+
+// parseArrayIndex parses an index selector of the form N or N#FIELD
+func parseArrayIndex(s string) (*ArrayIndex, error) {
+	idx, field, _ := strings.Cut(s, "#")
+	n, err := strconv.Atoi(idx)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid index %q: expected N or N#FIELD", s)
+	}
+	return &ArrayIndex{Index: n, Field: field}, nil
+}
+
+// selectArrayElement returns the selected element of a JSON array secret as a secret string.
+// When a field is selected, only that field is kept, under its own name. Scalar
+// elements are returned as their plain value rather than re-encoded as JSON.
+func selectArrayElement(secretString string, sel *ArrayIndex) (string, error) {
+	doc, err := decodeJSON(secretString)
+	if err != nil {
+		return "", fmt.Errorf("secret is not valid JSON: %w", err)
+	}
+	list, ok := doc.([]interface{})
+	if !ok {
+		return "", fmt.Errorf("secret is not a JSON array")
+	}
+	if sel.Index >= len(list) {
+		return "", fmt.Errorf("index %d is out of range for an array of %d elements", sel.Index, len(list))
+	}
+
+	element := list[sel.Index]
+	if sel.Field != "" {
+		obj, ok := element.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("element %d is not a JSON object", sel.Index)
+		}
+		value, ok := obj[sel.Field]
+		if !ok {
+			return "", fmt.Errorf("element %d has no field %q", sel.Index, sel.Field)
+		}
+		element = map[string]string{sel.Field: stringifyJSON(value)}
+	}
+
+	switch element.(type) {
+	case map[string]interface{}, map[string]string, []interface{}:
+	default:
+		return stringifyJSON(element), nil
+	}
+	encoded, err := json.Marshal(element)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// DefaultFlattenSep joins the path segments of flattened keys
+const DefaultFlattenSep = "_"
+
+// flattenSecret flattens a JSON object secret into upper-cased keys joined by sep.
+// ok is false when the secret is not a JSON object.
+func flattenSecret(secretString, sep string) (map[string]string, bool) {
+	doc, err := decodeJSON(secretString)
+	if err != nil {
+		return nil, false
+	}
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	result := make(map[string]string)
+	flattenJSON(obj, "", sep, result)
+	return result, true
+}
+
+// Policies for secrets whose JSON root is an array
+const (
+	// ArrayRootBlob keeps the whole array as the single "secret" value
+	ArrayRootBlob = "blob"
+	// ArrayRootIndex injects each element as SECRET_0, SECRET_1, ...
+	ArrayRootIndex = "index"
+	// ArrayRootJoin injects the elements as one SECRET value joined by the join separator
+	ArrayRootJoin = "join"
+)
+
+// DefaultArrayName is the env var name array elements are injected under without --as
+const DefaultArrayName = "SECRET"
+
+// DefaultJoinSep separates the elements of an array secret injected with ArrayRootJoin
+const DefaultJoinSep = ","
+
+// expandArrayRoot expands a top-level JSON array secret according to policy, naming
+// the env vars after name and joining elements with sep for ArrayRootJoin.
+// ok is false when the secret is not a JSON array or the policy keeps it as a blob.
+func expandArrayRoot(secretString, policy, name, sep string) (map[string]string, bool, error) {
+	if policy == ArrayRootBlob {
+		return nil, false, nil
+	}
+	doc, err := decodeJSON(secretString)
+	if err != nil {
+		return nil, false, nil
+	}
+	elements, ok := doc.([]interface{})
+	if !ok {
+		return nil, false, nil
+	}
+
+	values := make([]string, len(elements))
+	for i, element := range elements {
+		switch element.(type) {
+		case map[string]interface{}, []interface{}:
+			// Nested elements are kept as compact JSON
+			data, err := json.Marshal(element)
+			if err != nil {
+				return nil, false, err
+			}
+			values[i] = string(data)
+		default:
+			values[i] = stringifyJSON(element)
+		}
+	}
+
+	if policy == ArrayRootJoin {
+		return map[string]string{name: strings.Join(values, sep)}, true, nil
+	}
+	result := make(map[string]string, len(values))
+	for i, v := range values {
+		result[name+"_"+strconv.Itoa(i)] = v
+	}
+	return result, true, nil
+}
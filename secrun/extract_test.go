@@ -0,0 +1,444 @@
+package secrun
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveJSONPointer(t *testing.T) {
+	doc, err := decodeJSON(`{"a":{"b/c":{"d~e":"x"}},"list":["p","q"]}`)
+	if err != nil {
+		t.Fatalf("decodeJSON() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		pointer string
+		want    string
+		wantErr bool
+	}{
+		{name: "エスケープされたキー", pointer: "/a/b~1c/d~0e", want: "x"},
+		{name: "配列のインデックス", pointer: "/list/1", want: "q"},
+		{name: "存在しないキー", pointer: "/a/missing", wantErr: true},
+		{name: "範囲外のインデックス", pointer: "/list/5", wantErr: true},
+		{name: "スラッシュなし", pointer: "a", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveJSONPointer(doc, tt.pointer)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("resolveJSONPointer(%q) error = nil, want error", tt.pointer)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveJSONPointer(%q) error = %v", tt.pointer, err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveJSONPointer(%q) = %v, want %v", tt.pointer, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractSecret(t *testing.T) {
+	secret := `{"database":{"host":"db.local","port":5432,"tls":true,"replicas":["r1","r2"]},"api":{"token":"t"}}`
+
+	got, err := extractSecret(secret, []Extraction{{Pointer: "/database", Prefix: "DB_"}})
+	if err != nil {
+		t.Fatalf("extractSecret() error = %v", err)
+	}
+
+	want := map[string]string{
+		"DB_HOST":       "db.local",
+		"DB_PORT":       "5432",
+		"DB_TLS":        "true",
+		"DB_REPLICAS_0": "r1",
+		"DB_REPLICAS_1": "r2",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("extractSecret() = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("extractSecret()[%s] = %q, want %q", k, got[k], v)
+		}
+	}
+
+	// スカラー値を指すポインタはエラー
+	if _, err := extractSecret(secret, []Extraction{{Pointer: "/api/token", Prefix: "API_"}}); err == nil {
+		t.Error("Expected error for pointer referencing a scalar, got nil")
+	}
+
+	// JSONでないシークレットはエラー
+	if _, err := extractSecret("plain", []Extraction{{Pointer: "/database", Prefix: "DB_"}}); err == nil {
+		t.Error("Expected error for non-JSON secret, got nil")
+	}
+}
+
+func TestApplication_Run_Extract(t *testing.T) {
+	// モックの準備
+	mockSecretManager := &MockSecretManager{
+		Secrets: map[string]string{
+			"app-config": `{"database":{"user":"admin","password":"secure123"},"cache":{"host":"redis"}}`,
+		},
+	}
+	mockRunner := &MockCommandRunner{}
+
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: mockSecretManager,
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "app-config", "--extract", "/database=DB_", "--extract", "/cache=CACHE_"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(mockRunner.ExecutedCommands) != 1 {
+		t.Fatalf("Expected 1 command execution, got: %d", len(mockRunner.ExecutedCommands))
+	}
+	cmd := mockRunner.ExecutedCommands[0]
+
+	// 抽出したキーがプレフィックス付きで設定されていることを確認
+	for _, want := range []string{"DB_USER=admin", "DB_PASSWORD=secure123", "CACHE_HOST=redis"} {
+		found := false
+		for _, env := range cmd.Env {
+			if env == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Expected environment variable %s", want)
+		}
+	}
+
+	// 抽出対象外のトップレベルキーは注入されない
+	for _, env := range cmd.Env {
+		if strings.HasPrefix(env, "database=") || strings.HasPrefix(env, "cache=") {
+			t.Errorf("Unexpected environment variable %s", env)
+		}
+	}
+
+	// --extract は --key の後にのみ指定できる
+	app.Args = []string{"program", "/usr/bin/env", "--extract", "/database=DB_"}
+	if err := app.Run(); err == nil {
+		t.Error("Expected error for --extract without --key, got nil")
+	}
+}
+
+func TestApplication_Run_Select(t *testing.T) {
+	secrets := map[string]string{
+		"app-config": `{"api_key":"xyz","database":{"user":"admin","password":"secure123"},"cache":{"host":"redis"}}`,
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{name: "top level", args: []string{"--select", "api_key"}, want: "api_key=xyz"},
+		{name: "nested", args: []string{"--select", "database.password"}, want: "password=secure123"},
+		{name: "nested as", args: []string{"--select", "database.password", "--as", "DB_PASSWORD"}, want: "DB_PASSWORD=secure123"},
+		{name: "missing path", args: []string{"--select", "database.host"}, wantErr: `selector "database.host": key "host" not found`},
+		{name: "as without select", args: []string{"--as", "DB_PASSWORD"}, wantErr: "--as requires --select"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRunner := &MockCommandRunner{}
+			app := &Application{
+				Logger:        &MockLogger{},
+				SecretManager: &MockSecretManager{Secrets: secrets},
+				CommandRunner: mockRunner,
+				Args:          append([]string{"program", "/usr/bin/env", "--key", "app-config"}, tt.args...),
+			}
+
+			err := app.Run()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			// 選択したフィールドだけが注入され、他のフィールドは無視される
+			var injected []string
+			for _, env := range mockRunner.ExecutedCommands[0].Env {
+				for _, key := range []string{"api_key=", "database=", "cache=", "password=", "DB_PASSWORD="} {
+					if strings.HasPrefix(env, key) {
+						injected = append(injected, env)
+					}
+				}
+			}
+			if len(injected) != 1 || injected[0] != tt.want {
+				t.Errorf("injected = %v, want [%s]", injected, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplication_Run_Index(t *testing.T) {
+	mockSecretManager := &MockSecretManager{
+		Secrets: map[string]string{
+			"creds": `[{"user":"alice","password":"first","port":5432},{"user":"bob","password":"second"}]`,
+		},
+	}
+	mockRunner := &MockCommandRunner{}
+
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: mockSecretManager,
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "creds", "--index", "0#password"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 要素0のフィールドだけが注入される
+	env := strings.Join(mockRunner.ExecutedCommands[0].Env, "\n")
+	if !strings.Contains(env, "password=first") {
+		t.Errorf("Expected password=first, got: %s", env)
+	}
+	if strings.Contains(env, "user=") {
+		t.Errorf("Expected only the selected field, got: %s", env)
+	}
+
+	// フィールドを省略すると要素全体を展開する
+	app.Args = []string{"program", "/usr/bin/env", "--key", "creds", "--index", "1"}
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	env = strings.Join(mockRunner.ExecutedCommands[1].Env, "\n")
+	if !strings.Contains(env, "user=bob") || !strings.Contains(env, "password=second") {
+		t.Errorf("Expected element 1 to be expanded, got: %s", env)
+	}
+
+	// 数値のフィールドも文字列として注入される
+	app.Args = []string{"program", "/usr/bin/env", "--key", "creds", "--index", "0#port"}
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if env = strings.Join(mockRunner.ExecutedCommands[2].Env, "\n"); !strings.Contains(env, "port=5432") {
+		t.Errorf("Expected port=5432, got: %s", env)
+	}
+
+	// 範囲外のインデックスはエラー
+	app.Args = []string{"program", "/usr/bin/env", "--key", "creds", "--index", "2#password"}
+	if err := app.Run(); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("Expected out of range error, got: %v", err)
+	}
+
+	// 不正な指定はエラー
+	app.Args = []string{"program", "/usr/bin/env", "--key", "creds", "--index", "first#password"}
+	if err := app.Run(); err == nil {
+		t.Error("Expected error for a non-numeric index, got nil")
+	}
+}
+
+func TestApplication_Run_IndexScalarElement(t *testing.T) {
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"hosts": `["a","b",5432]`}},
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "hosts", "--index", "1"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 文字列の要素はJSONとして再エンコードされず、そのままの値で注入される
+	var injected []string
+	for _, env := range mockRunner.ExecutedCommands[0].Env {
+		if strings.HasPrefix(env, "secret=") {
+			injected = append(injected, env)
+		}
+	}
+	if len(injected) != 1 || injected[0] != "secret=b" {
+		t.Errorf("injected = %q, want [secret=b]", injected)
+	}
+
+	// 数値の要素も文字列として注入される
+	app.Args = []string{"program", "/usr/bin/env", "--key", "hosts", "--index", "2"}
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if env := strings.Join(mockRunner.ExecutedCommands[1].Env, "\n"); !strings.Contains(env, "secret=5432") {
+		t.Errorf("Expected secret=5432, got: %s", env)
+	}
+
+	// フィールド指定はオブジェクトの要素にしか使えない
+	app.Args = []string{"program", "/usr/bin/env", "--key", "hosts", "--index", "0#host"}
+	if err := app.Run(); err == nil || !strings.Contains(err.Error(), "not a JSON object") {
+		t.Errorf("Expected not a JSON object error, got: %v", err)
+	}
+}
+
+func TestApplication_Run_JSONArrayRoot(t *testing.T) {
+	secret := `["alpha",42,true,{"k":"v"}]`
+
+	tests := []struct {
+		name   string
+		policy []string
+		want   []string
+	}{
+		{name: "デフォルトは丸ごと1つの値", want: []string{"secret=" + secret}},
+		{name: "index", policy: []string{"--on-json-array-root", "index"}, want: []string{"SECRET_0=alpha", "SECRET_1=42", "SECRET_2=true", `SECRET_3={"k":"v"}`}},
+		{name: "join", policy: []string{"--on-json-array-root", "join"}, want: []string{`SECRET=alpha,42,true,{"k":"v"}`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRunner := &MockCommandRunner{}
+			app := &Application{
+				Logger:        &MockLogger{},
+				SecretManager: &MockSecretManager{Secrets: map[string]string{"list": secret, "obj": `{"KEY":"value"}`}},
+				CommandRunner: mockRunner,
+				Args:          append([]string{"program", "/usr/bin/env", "--key", "list", "--key", "obj"}, tt.policy...),
+			}
+			if err := app.Run(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			// 配列の要素がポリシーに従って注入され、オブジェクトのシークレットはそのまま展開される
+			env := strings.Join(mockRunner.ExecutedCommands[0].Env, "\n")
+			for _, want := range append(tt.want, "KEY=value") {
+				if !strings.Contains(env, want) {
+					t.Errorf("Expected %s, got: %s", want, env)
+				}
+			}
+		})
+	}
+
+	// 未知のポリシーはエラー
+	if _, err := parseArgs([]string{"program", "/usr/bin/env", "--on-json-array-root", "split"}); err == nil {
+		t.Error("Expected error for an unknown policy, got nil")
+	}
+}
+
+func TestApplication_Run_JSONArrayRootNaming(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		wantErr string
+	}{
+		{name: "index with as", args: []string{"--key", "hosts", "--as", "HOST", "--on-json-array-root", "index"}, want: []string{"HOST_0=a", "HOST_1=b", "HOST_2=c"}},
+		{name: "join with as", args: []string{"--key", "hosts", "--as", "HOSTS", "--on-json-array-root", "join"}, want: []string{"HOSTS=a,b,c"}},
+		{name: "join with sep", args: []string{"--key", "hosts", "--as", "HOSTS", "--on-json-array-root", "join", "--join-sep", " "}, want: []string{"HOSTS=a b c"}},
+		{name: "as on an object secret", args: []string{"--key", "obj", "--as", "HOST", "--on-json-array-root", "index"}, wantErr: "secret obj is not a JSON array"},
+		{name: "as with blob", args: []string{"--key", "hosts", "--as", "HOST"}, wantErr: "--as requires --select"},
+		{name: "join sep without join", args: []string{"--key", "hosts", "--on-json-array-root", "index", "--join-sep", ":"}, wantErr: "--join-sep requires --on-json-array-root join"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRunner := &MockCommandRunner{}
+			app := &Application{
+				Logger:        &MockLogger{},
+				SecretManager: &MockSecretManager{Secrets: map[string]string{"hosts": `["a","b","c"]`, "obj": `{"KEY":"value"}`}},
+				CommandRunner: mockRunner,
+				Args:          append([]string{"program", "/usr/bin/env"}, tt.args...),
+			}
+			err := app.Run()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Expected error containing %q, got: %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			// --asの名前で配列の要素が注入される
+			env := strings.Join(mockRunner.ExecutedCommands[0].Env, "\n")
+			for _, want := range tt.want {
+				if !strings.Contains(env, want) {
+					t.Errorf("Expected %s, got: %s", want, env)
+				}
+			}
+			if strings.Contains(env, "SECRET_0=") || strings.Contains(env, "\nSECRET=") {
+				t.Errorf("Expected no SECRET env vars with --as, got: %s", env)
+			}
+		})
+	}
+}
+
+func TestApplication_Run_Flatten(t *testing.T) {
+	secrets := map[string]string{
+		"nested": `{"db":{"host":"x","port":5432,"ssl":true,"replica":null},"list":["a",{"b":"c"}],"name":"app"}`,
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "ネストしたオブジェクトと配列",
+			args: []string{"--flatten"},
+			want: []string{"DB_HOST=x", "DB_PORT=5432", "DB_SSL=true", "DB_REPLICA=", "LIST_0=a", "LIST_1_B=c", "NAME=app"},
+		},
+		{
+			name: "区切り文字を変更",
+			args: []string{"--flatten", "--flatten-sep", "__"},
+			want: []string{"DB__HOST=x", "DB__PORT=5432", "LIST__1__B=c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRunner := &MockCommandRunner{}
+			app := &Application{
+				Logger:        &MockLogger{},
+				SecretManager: &MockSecretManager{Secrets: secrets},
+				CommandRunner: mockRunner,
+				Args:          append([]string{"program", "/usr/bin/env", "--key", "nested"}, tt.args...),
+			}
+			if err := app.Run(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			// 数値や真偽値も文字列として注入される
+			env := strings.Join(mockRunner.ExecutedCommands[0].Env, "\n")
+			for _, want := range tt.want {
+				if !strings.Contains(env, want+"\n") && !strings.HasSuffix(env, want) {
+					t.Errorf("Expected %s, got: %s", want, env)
+				}
+			}
+		})
+	}
+
+	// 指定しなければ従来どおり丸ごと1つの値になる
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: secrets},
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "nested"},
+	}
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if env := strings.Join(mockRunner.ExecutedCommands[0].Env, "\n"); !strings.Contains(env, "secret="+secrets["nested"]) {
+		t.Errorf("Expected the whole secret as one value, got: %s", env)
+	}
+
+	// --flattenなしの区切り文字指定はエラー
+	if _, err := parseArgs([]string{"program", "/usr/bin/env", "--flatten-sep", "."}); err == nil {
+		t.Error("Expected error for --flatten-sep without --flatten, got nil")
+	}
+}
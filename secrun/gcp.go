@@ -0,0 +1,145 @@
+package secrun
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2/google"
+)
+
+// gcpDefaultEndpoint is the Secret Manager REST API
+const gcpDefaultEndpoint = "https://secretmanager.googleapis.com"
+
+// gcpScope is the OAuth scope required to access secret versions
+const gcpScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// GCPSecretManager implements SecretManager using Google Cloud Secret Manager
+type GCPSecretManager struct {
+	ctx      context.Context
+	Endpoint string
+	// newClient builds the authorized HTTP client, from application default credentials by default
+	newClient func(ctx context.Context) (*http.Client, error)
+
+	once   sync.Once
+	client *http.Client
+	err    error
+}
+
+// NewGCPSecretManager creates a GCPSecretManager authenticated with application default credentials
+func NewGCPSecretManager() *GCPSecretManager {
+	return &GCPSecretManager{
+		ctx:      context.Background(),
+		Endpoint: gcpDefaultEndpoint,
+		newClient: func(ctx context.Context) (*http.Client, error) {
+			return google.DefaultClient(ctx, gcpScope)
+		},
+	}
+}
+
+// SetContext sets the context used for subsequent requests
+func (m *GCPSecretManager) SetContext(ctx context.Context) {
+	m.ctx = ctx
+}
+
+// Source reports the backend label of GCPSecretManager
+func (m *GCPSecretManager) Source() string {
+	return SourceGCP
+}
+
+// getClient lazily creates the authorized HTTP client
+func (m *GCPSecretManager) getClient() (*http.Client, error) {
+	m.once.Do(func() {
+		// The token source outlives a single fetch, so it is not bound to the fetch deadline
+		m.client, m.err = m.newClient(context.Background())
+		if m.err != nil {
+			m.err = fmt.Errorf("failed to load Google application default credentials: %w", m.err)
+		}
+	})
+	return m.client, m.err
+}
+
+// accessSecretVersionResponse is the shape of an AccessSecretVersion response
+type accessSecretVersionResponse struct {
+	Name    string `json:"name"`
+	Payload struct {
+		Data       string `json:"data"`
+		DataCrc32c string `json:"dataCrc32c"`
+	} `json:"payload"`
+}
+
+// gcpVersionName completes a secret resource name with the latest version when none is given
+func gcpVersionName(name string) (string, error) {
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	switch {
+	case len(parts) == 4 && parts[0] == "projects" && parts[2] == "secrets":
+		return strings.Join(append(parts, "versions", "latest"), "/"), nil
+	case len(parts) == 6 && parts[0] == "projects" && parts[2] == "secrets" && parts[4] == "versions":
+		return strings.Join(parts, "/"), nil
+	}
+	return "", fmt.Errorf("invalid GCP secret name %q: expected projects/PROJECT/secrets/SECRET[/versions/VERSION]", name)
+}
+
+// GetSecret accesses a secret version and returns its payload
+func (m *GCPSecretManager) GetSecret(secretName string) (string, error) {
+	name, err := gcpVersionName(secretName)
+	if err != nil {
+		return "", err
+	}
+	client, err := m.getClient()
+	if err != nil {
+		return "", err
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(m.Endpoint, "/"))
+	if err != nil {
+		return "", fmt.Errorf("invalid GCP endpoint %q: %w", m.Endpoint, err)
+	}
+	endpoint := base.JoinPath("v1", name).String() + ":access"
+	req, err := http.NewRequestWithContext(m.ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to access GCP secret: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read GCP response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GCP Secret Manager returned %s for %s", resp.Status, name)
+	}
+
+	var result accessSecretVersionResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("failed to decode GCP response: %w", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(result.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode GCP secret payload: %w", err)
+	}
+
+	if result.Payload.DataCrc32c != "" {
+		want, err := strconv.ParseUint(result.Payload.DataCrc32c, 10, 32)
+		if err != nil {
+			return "", fmt.Errorf("invalid GCP payload checksum %q: %w", result.Payload.DataCrc32c, err)
+		}
+		if crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)) != uint32(want) {
+			return "", fmt.Errorf("GCP secret %s failed checksum verification", name)
+		}
+	}
+	return string(data), nil
+}
@@ -0,0 +1,155 @@
+package secrun_test
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"awssecrun/secrun"
+)
+
+func TestPrepare(t *testing.T) {
+	opts := secrun.DefaultOptions()
+	opts.CommandPath = "/usr/bin/psql"
+	opts.Args = []string{"-h", "db.local"}
+	opts.Secrets = []*secrun.SecretSpec{{Name: "db", Prefix: "APP_"}}
+	opts.CleanEnv = true
+	opts.PassEnv = []string{"HOME"}
+	t.Setenv("HOME", "/home/test")
+
+	sm := &secrun.MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin","DB_PASSWORD":"secure123"}`}}
+	app := secrun.New(opts)
+	app.Logger = &secrun.MockLogger{}
+	app.SecretManager = sm
+
+	argv := append([]string(nil), os.Args...)
+	prepared, err := app.Prepare()
+	if err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+	defer prepared.Cleanup()
+
+	// argvを使わず、組み立てた環境変数とコマンドを返す
+	if strings.Join(os.Args, " ") != strings.Join(argv, " ") {
+		t.Errorf("os.Args changed: %v", os.Args)
+	}
+	if prepared.CommandPath != "/usr/bin/psql" || strings.Join(prepared.Args, " ") != "-h db.local" {
+		t.Errorf("command = %q %v", prepared.CommandPath, prepared.Args)
+	}
+	env := append([]string(nil), prepared.Env...)
+	sort.Strings(env)
+	want := []string{"APP_DB_PASSWORD=secure123", "APP_DB_USER=admin", "HOME=/home/test"}
+	if strings.Join(env, "|") != strings.Join(want, "|") {
+		t.Errorf("Env = %v, want %v", env, want)
+	}
+	if len(sm.Calls) != 1 {
+		t.Errorf("Expected 1 fetch, got: %v", sm.Calls)
+	}
+}
+
+func TestPrepare_CleanupFiles(t *testing.T) {
+	opts := secrun.DefaultOptions()
+	opts.Secrets = []*secrun.SecretSpec{{Name: "key"}}
+	opts.OnNulByte = secrun.NulByteFile
+
+	app := secrun.New(opts)
+	app.Logger = &secrun.MockLogger{}
+	app.SecretManager = &secrun.MockSecretManager{Secrets: map[string]string{"key": `{"SIGNING_KEY":"ab\u0000cd"}`}}
+
+	prepared, err := app.Prepare()
+	if err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+
+	// ファイルは呼び出し側がCleanupするまで残る
+	var path string
+	for _, entry := range prepared.Env {
+		if v, ok := strings.CutPrefix(entry, "SIGNING_KEY="); ok {
+			path = v
+		}
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "ab\x00cd" {
+		t.Fatalf("ReadFile(%q) = %q, %v", path, data, err)
+	}
+	if err := prepared.Cleanup(); err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed, stat error = %v", path, err)
+	}
+}
+
+func TestNew_Run(t *testing.T) {
+	opts := secrun.DefaultOptions()
+	opts.CommandPath = "/usr/bin/env"
+	opts.Secrets = []*secrun.SecretSpec{{Name: "db"}}
+
+	runner := &secrun.MockCommandRunner{}
+	app := secrun.New(opts)
+	app.Logger = &secrun.MockLogger{}
+	app.SecretManager = &secrun.MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}}
+	app.CommandRunner = runner
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(runner.ExecutedCommands) != 1 || !strings.Contains(strings.Join(runner.ExecutedCommands[0].Env, "\n"), "DB_USER=admin") {
+		t.Errorf("Expected the command to run with DB_USER, got: %+v", runner.ExecutedCommands)
+	}
+}
+
+func TestNew_InvalidOptions(t *testing.T) {
+	// 組み合わせられないオプションはコマンドラインと同じく拒否される
+	opts := secrun.DefaultOptions()
+	opts.PassEnv = []string{"HOME"}
+	app := secrun.New(opts)
+	app.Logger = &secrun.MockLogger{}
+	if _, err := app.Prepare(); err == nil || !strings.Contains(err.Error(), "--clean-env") {
+		t.Errorf("Expected --pass-env validation error, got: %v", err)
+	}
+
+	opts = secrun.DefaultOptions()
+	opts.DryRun = true
+	if _, err := secrun.New(opts).Prepare(); err == nil {
+		t.Error("Expected error for dry-run with Prepare, got nil")
+	}
+}
+
+func TestNew_OptionsNotModified(t *testing.T) {
+	t.Setenv("DB_SECRET", "db-prod")
+	t.Setenv("ENV", "prod")
+	opts := secrun.DefaultOptions()
+	opts.CommandPath = "/usr/bin/env"
+	opts.Secrets = []*secrun.SecretSpec{{NameEnv: "DB_SECRET"}, {NameTemplate: "{{.ENV}}/api"}}
+
+	sm := &secrun.MockSecretManager{Secrets: map[string]string{"db-prod": `{"DB_USER":"admin"}`, "prod/api": `{"API_KEY":"xyz"}`}}
+	app := secrun.New(opts)
+	app.Logger = &secrun.MockLogger{}
+	app.SecretManager = sm
+	app.CommandRunner = &secrun.MockCommandRunner{}
+	if _, err := app.Prepare(); err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+	if err := app.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// 解決したシークレット名で取得しても、呼び出し元のOptionsは書き換えない
+	if strings.Join(sm.Calls, ",") != "db-prod,prod/api,db-prod,prod/api" {
+		t.Errorf("fetches = %v", sm.Calls)
+	}
+	if opts.Secrets[0].Name != "" || opts.Secrets[1].Name != "" {
+		t.Errorf("Options were modified: %+v, %+v", *opts.Secrets[0], *opts.Secrets[1])
+	}
+
+	// 環境が変われば、同じOptionsで別のシークレットを取得する
+	t.Setenv("DB_SECRET", "db-staging")
+	sm.Secrets["db-staging"] = `{"DB_USER":"staging"}`
+	if err := app.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := sm.Calls[len(sm.Calls)-2]; got != "db-staging" {
+		t.Errorf("fetch = %q, want db-staging", got)
+	}
+}
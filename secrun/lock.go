@@ -0,0 +1,92 @@
+package secrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errLockHeld is returned by tryLockFile when another process holds the lock
+var errLockHeld = errors.New("lock held by another process")
+
+// lockPollInterval is how often a held lock is retried while waiting for it
+const lockPollInterval = 50 * time.Millisecond
+
+// fileLock is an exclusive lock on a --lock-file, held until Release
+type fileLock struct {
+	f *os.File
+}
+
+// acquireLock takes an exclusive lock on path, creating the file if needed. It waits
+// for the lock until ctx is done unless nonblocking is set, in which case a held lock
+// is an error. The lock belongs to the open file, so the OS releases it when the
+// holder exits, and a file left behind by a crashed run does not block later runs.
+func acquireLock(ctx context.Context, path string, nonblocking bool, logger Logger) (*fileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	err = tryLockFile(f)
+	if errors.Is(err, errLockHeld) {
+		holder := lockHolder(f)
+		if nonblocking {
+			f.Close()
+			if holder != "" {
+				return nil, fmt.Errorf("lock file %s is held by another process (pid %s)", path, holder)
+			}
+			return nil, fmt.Errorf("lock file %s is held by another process", path)
+		}
+		logger.Log("info", "Waiting for lock file", map[string]string{"path": path, "holderPid": holder})
+		err = waitLockFile(ctx, f)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	// Record our PID for whoever finds the lock held
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &fileLock{f: f}, nil
+}
+
+// waitLockFile retries tryLockFile until it takes the lock or ctx is done. Polling,
+// unlike a blocking flock, lets a cancelled run stop waiting.
+func waitLockFile(ctx context.Context, f *os.File) error {
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up waiting for the lock: %w", context.Cause(ctx))
+		case <-ticker.C:
+		}
+		if err := tryLockFile(f); !errors.Is(err, errLockHeld) {
+			return err
+		}
+	}
+}
+
+// lockHolder returns the PID recorded in a held lock file, if any
+func lockHolder(f *os.File) string {
+	buf := make([]byte, 32)
+	n, _ := f.ReadAt(buf, 0)
+	return strings.TrimSpace(string(buf[:n]))
+}
+
+// Release releases the lock. The file is kept, as removing it would let a waiting
+// process and a new one lock different files.
+func (l *fileLock) Release() error {
+	l.f.Truncate(0)
+	if err := unlockFile(l.f); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
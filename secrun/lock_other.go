@@ -0,0 +1,18 @@
+//go:build !unix
+
+package secrun
+
+import (
+	"fmt"
+	"os"
+)
+
+// tryLockFile is not supported on this platform
+func tryLockFile(f *os.File) error {
+	return fmt.Errorf("--lock-file is not supported on this platform")
+}
+
+// unlockFile is not supported on this platform
+func unlockFile(f *os.File) error {
+	return nil
+}
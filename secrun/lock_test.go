@@ -0,0 +1,295 @@
+//go:build unix
+
+package secrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+func TestAcquireLock_Contention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "awsecrun.lock")
+	first, err := acquireLock(context.Background(), path, false, &MockLogger{})
+	if err != nil {
+		t.Fatalf("acquireLock() error = %v", err)
+	}
+
+	// 保持中のロックはノンブロッキングでは即座にエラーになり、保持者のPIDが示される
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := acquireLock(context.Background(), path, true, &MockLogger{})
+		errCh <- err
+	}()
+	if err := <-errCh; err == nil || !strings.Contains(err.Error(), fmt.Sprintf("pid %d", os.Getpid())) {
+		t.Errorf("Expected error naming the holder's pid, got: %v", err)
+	}
+
+	// ブロッキングでは解放されるまで待つ
+	logger := &MockLogger{}
+	acquired := make(chan *fileLock, 1)
+	go func() {
+		lock, err := acquireLock(context.Background(), path, false, logger)
+		if err != nil {
+			t.Errorf("acquireLock() error = %v", err)
+		}
+		acquired <- lock
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("Expected the second goroutine to wait for the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := first.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	select {
+	case lock := <-acquired:
+		if lock != nil {
+			lock.Release()
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Second goroutine did not acquire the released lock")
+	}
+}
+
+func TestAcquireLock_StaleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "awsecrun.lock")
+	// クラッシュしたプロセスが残したロックファイルはロックを妨げない
+	if err := os.WriteFile(path, []byte("999999\n"), 0600); err != nil {
+		t.Fatalf("Failed to write lock file: %v", err)
+	}
+
+	lock, err := acquireLock(context.Background(), path, true, &MockLogger{})
+	if err != nil {
+		t.Fatalf("acquireLock() error = %v", err)
+	}
+	defer lock.Release()
+
+	data, _ := os.ReadFile(path)
+	if got, want := strings.TrimSpace(string(data)), fmt.Sprint(os.Getpid()); got != want {
+		t.Errorf("lock file pid = %q, want %q", got, want)
+	}
+}
+
+func TestApplication_Run_LockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "awsecrun.lock")
+	var active, maxActive int32
+	run := func() error {
+		app := &Application{
+			Logger:        &MockLogger{},
+			SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}},
+			CommandRunner: &MockCommandRunner{RunFunc: func(string, []string, []string) error {
+				n := atomic.AddInt32(&active, 1)
+				for {
+					m := atomic.LoadInt32(&maxActive)
+					if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+						break
+					}
+				}
+				time.Sleep(20 * time.Millisecond)
+				atomic.AddInt32(&active, -1)
+				return nil
+			}},
+			Args: []string{"program", "/usr/bin/env", "--key", "db", "--lock-file", path},
+		}
+		return app.Run()
+	}
+
+	// 同じロックファイルを使う実行は直列化される
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := run(); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if maxActive != 1 {
+		t.Errorf("max concurrent commands = %d, want 1", maxActive)
+	}
+
+	// --lock-nonblockingではロック保持中は待たずに失敗する
+	held, err := acquireLock(context.Background(), path, false, &MockLogger{})
+	if err != nil {
+		t.Fatalf("acquireLock() error = %v", err)
+	}
+	defer held.Release()
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}},
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--lock-file", path, "--lock-nonblocking"},
+	}
+	if err := app.Run(); err == nil || !strings.Contains(err.Error(), "held by another process") {
+		t.Errorf("Expected lock held error, got: %v", err)
+	}
+	if len(mockRunner.ExecutedCommands) != 0 {
+		t.Errorf("Expected no command execution, got: %d", len(mockRunner.ExecutedCommands))
+	}
+
+	// --lock-fileなしの--lock-nonblockingはエラー
+	if _, err := parseArgs([]string{"program", "/usr/bin/env", "--lock-nonblocking"}); err == nil {
+		t.Error("Expected error for --lock-nonblocking without --lock-file")
+	}
+}
+
+// DeadlineSecretsManagerClient はコンテキストが終了していれば取得に失敗するクライアント
+type DeadlineSecretsManagerClient struct {
+	MockSecretsManagerClient
+}
+
+// GetSecretValue はコンテキストが有効な場合だけシークレットを返す
+func (c *DeadlineSecretsManagerClient) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.MockSecretsManagerClient.GetSecretValue(ctx, params, optFns...)
+}
+
+func TestApplication_Run_LockWaitDoesNotCountAgainstTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "awsecrun.lock")
+	held, err := acquireLock(context.Background(), path, false, &MockLogger{})
+	if err != nil {
+		t.Fatalf("acquireLock() error = %v", err)
+	}
+
+	calls := 0
+	client := &DeadlineSecretsManagerClient{MockSecretsManagerClient{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}}}
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: newTestAWSSecretManager(client, &calls),
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--lock-file", path, "--timeout", "100ms"},
+	}
+	errCh := make(chan error, 1)
+	go func() { errCh <- app.Run() }()
+
+	// --timeoutより長くロックを保持しても、ロック取得後の取得はタイムアウトしない
+	time.Sleep(300 * time.Millisecond)
+	if err := held.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run() did not return after the lock was released")
+	}
+	if len(mockRunner.ExecutedCommands) != 1 {
+		t.Errorf("Expected 1 command execution, got: %d", len(mockRunner.ExecutedCommands))
+	}
+}
+
+func TestApplication_RunContext_CancelWhileWaitingForLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "awsecrun.lock")
+	held, err := acquireLock(context.Background(), path, false, &MockLogger{})
+	if err != nil {
+		t.Fatalf("acquireLock() error = %v", err)
+	}
+	defer held.Release()
+
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}},
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--lock-file", path},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// ロック待ちはキャンセルで打ち切られる
+	if err := app.RunContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got: %v", err)
+	}
+	if len(mockRunner.ExecutedCommands) != 0 {
+		t.Errorf("Expected no command execution, got: %d", len(mockRunner.ExecutedCommands))
+	}
+
+	// --detachとは併用できない
+	if _, err := parseArgs([]string{"program", "/usr/bin/env", "--lock-file", path, "--detach"}); err == nil {
+		t.Error("Expected error combining --lock-file with --detach")
+	}
+}
+
+func TestApplication_Run_LockWaitPhase(t *testing.T) {
+	logger := &MockLogger{}
+	app := &Application{
+		Logger:        logger,
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}},
+		CommandRunner: &MockCommandRunner{},
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--lock-file", filepath.Join(t.TempDir(), "awsecrun.lock")},
+		Now:           fakeClock(0, 2*time.Millisecond, 50*time.Millisecond, 3*time.Millisecond, 7*time.Millisecond, 20*time.Millisecond, 3*time.Millisecond, 100*time.Millisecond),
+	}
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// ロック待ちは準備とは別のフェーズとして記録され、準備はロックの前後を合算する
+	durations := finalDurations(t, logger)
+	want := map[string]float64{
+		PhaseSetup:       5,
+		PhaseLockWait:    50,
+		PhaseConfigLoad:  7,
+		PhaseFetch:       20,
+		PhaseEnvAssembly: 3,
+		PhaseCommand:     100,
+	}
+	for phase, ms := range want {
+		if durations[phase] != ms {
+			t.Errorf("%s = %vms, want %vms", phase, durations[phase], ms)
+		}
+	}
+}
+
+func TestApplication_Run_RefetchOnRestartKeepsLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "awsecrun.lock")
+	sm := &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}}
+	attempts := 0
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: sm,
+		CommandRunner: &MockCommandRunner{RunFunc: func(string, []string, []string) error {
+			attempts++
+			if attempts == 1 {
+				return fmt.Errorf("exit status 1")
+			}
+			return nil
+		}},
+		Args:  []string{"program", "/usr/bin/env", "--key", "db", "--lock-file", path, "--command-retries", "1", "--refetch-on-restart"},
+		Sleep: func(time.Duration) {},
+	}
+
+	// 再取得は保持中のロックを取り直さない
+	errCh := make(chan error, 1)
+	go func() { errCh <- app.Run() }()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run() did not return; the refetch waited for its own lock")
+	}
+	if attempts != 2 || len(sm.Calls) != 2 {
+		t.Errorf("attempts = %d, fetches = %d, want 2 and 2", attempts, len(sm.Calls))
+	}
+}
@@ -0,0 +1,265 @@
+package secrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SamplingLogger wraps a Logger and emits only one in every N info entries
+type SamplingLogger struct {
+	Inner Logger
+	N     int
+
+	mu    sync.Mutex
+	count int
+}
+
+// NewSamplingLogger creates a SamplingLogger that keeps 1 in n info entries
+func NewSamplingLogger(inner Logger, n int) *SamplingLogger {
+	return &SamplingLogger{
+		Inner: inner,
+		N:     n,
+	}
+}
+
+// Log forwards every non-info entry and a 1-in-N sample of info entries
+func (l *SamplingLogger) Log(level, message string, data interface{}) {
+	if level == "info" && l.N > 1 {
+		l.mu.Lock()
+		keep := l.count%l.N == 0
+		l.count++
+		l.mu.Unlock()
+
+		if !keep {
+			return
+		}
+	}
+
+	l.Inner.Log(level, message, data)
+}
+
+// WarningCounter wraps a Logger and counts the warnings passing through it
+type WarningCounter struct {
+	Inner Logger
+
+	mu    sync.Mutex
+	count int
+}
+
+// NewWarningCounter creates a WarningCounter around inner
+func NewWarningCounter(inner Logger) *WarningCounter {
+	return &WarningCounter{
+		Inner: inner,
+	}
+}
+
+// Log counts warn entries and forwards every entry
+func (l *WarningCounter) Log(level, message string, data interface{}) {
+	if level == "warn" {
+		l.mu.Lock()
+		l.count++
+		l.mu.Unlock()
+	}
+	l.Inner.Log(level, message, data)
+}
+
+// Count returns the number of warnings logged so far
+func (l *WarningCounter) Count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.count
+}
+
+// RedactingLogger wraps a Logger and masks known secret values in every entry
+type RedactingLogger struct {
+	Inner Logger
+	Mask  Mask
+
+	mu      sync.RWMutex
+	secrets []string
+}
+
+// NewRedactingLogger creates a RedactingLogger that masks the given secret values
+func NewRedactingLogger(inner Logger, secrets []string) *RedactingLogger {
+	l := &RedactingLogger{
+		Inner: inner,
+		Mask:  DefaultMask,
+	}
+	l.AddSecrets(secrets...)
+	return l
+}
+
+// AddSecrets registers more secret values to mask
+func (l *RedactingLogger) AddSecrets(secrets ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, secret := range secrets {
+		if secret != "" {
+			l.secrets = append(l.secrets, secret)
+		}
+	}
+}
+
+// Log masks secret values in the message and data and forwards the entry
+func (l *RedactingLogger) Log(level, message string, data interface{}) {
+	l.mu.RLock()
+	secrets := l.secrets
+	l.mu.RUnlock()
+
+	if len(secrets) == 0 {
+		l.Inner.Log(level, message, data)
+		return
+	}
+	l.Inner.Log(level, scrubString(message, secrets, l.Mask), l.redact(data, secrets))
+}
+
+// redact returns data with secret values masked in every string it contains
+func (l *RedactingLogger) redact(data interface{}, secrets []string) interface{} {
+	switch v := data.(type) {
+	case nil:
+		return nil
+	case string:
+		return scrubString(v, secrets, l.Mask)
+	case []string:
+		out := make([]string, len(v))
+		for i, s := range v {
+			out[i] = scrubString(s, secrets, l.Mask)
+		}
+		return out
+	case map[string]string:
+		out := make(map[string]string, len(v))
+		for k, s := range v {
+			out[scrubString(k, secrets, l.Mask)] = scrubString(s, secrets, l.Mask)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = l.redact(item, secrets)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[scrubString(k, secrets, l.Mask)] = l.redact(item, secrets)
+		}
+		return out
+	}
+
+	// Other types are redacted through their JSON form, as that is what gets logged
+	b, err := json.Marshal(data)
+	if err != nil || scrubString(string(b), secrets, l.Mask) == string(b) {
+		return data
+	}
+	var generic interface{}
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return l.Mask.String()
+	}
+	return l.redact(generic, secrets)
+}
+
+// parseSampleRate parses a sampling rate of the form 1/N
+func parseSampleRate(s string) (int, error) {
+	num, den, ok := strings.Cut(s, "/")
+	if !ok || num != "1" {
+		return 0, fmt.Errorf("invalid sample rate %q: expected 1/N", s)
+	}
+
+	n, err := strconv.Atoi(den)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid sample rate %q: N must be a positive integer", s)
+	}
+	return n, nil
+}
+
+// TextLogger implements Logger with one human-readable line per entry:
+// 2024-01-02T15:04:05Z [INFO] message key=value ...
+type TextLogger struct {
+	Output io.Writer
+	// Level is the minimum level written; entries below it are dropped. Empty means info
+	Level string
+
+	out bufferedOutput
+}
+
+// NewTextLogger creates a new text logger writing to stderr
+func NewTextLogger() *TextLogger {
+	return &TextLogger{
+		Output: os.Stderr,
+	}
+}
+
+// EnableBuffering buffers log output until Flush is called or the buffer fills up
+func (l *TextLogger) EnableBuffering() {
+	l.out.enable(l.Output)
+}
+
+// Flush writes any buffered log entries to the output
+func (l *TextLogger) Flush() error {
+	return l.out.flush()
+}
+
+// Log outputs the entry as a timestamp, bracketed level, message and key=value pairs
+func (l *TextLogger) Log(level, message string, data interface{}) {
+	if !levelEnabled(l.Level, level) {
+		return
+	}
+
+	var line strings.Builder
+	line.WriteString(time.Now().UTC().Format(time.RFC3339))
+	line.WriteString(" [" + strings.ToUpper(level) + "] ")
+	line.WriteString(message)
+	for _, field := range textFields(data) {
+		line.WriteString(" " + field)
+	}
+	l.out.writeLine(l.Output, []byte(line.String()))
+}
+
+// textFields renders data as key=value pairs sorted by key. Data that is not
+// an object, such as a slice, is rendered as a single data=value pair.
+func textFields(data interface{}) []string {
+	if data == nil {
+		return nil
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return []string{"data=" + strconv.Quote(fmt.Sprint(data))}
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &fields); err != nil || fields == nil {
+		return []string{"data=" + textValue(encoded)}
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+textValue(fields[k]))
+	}
+	return pairs
+}
+
+// textValue renders a JSON value, unquoting strings that need no quoting
+func textValue(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		// Numbers, booleans, null, arrays and objects keep their JSON form
+		return string(raw)
+	}
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,543 @@
+package secrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSamplingLogger(t *testing.T) {
+	inner := &MockLogger{}
+	logger := NewSamplingLogger(inner, 10)
+
+	for i := 0; i < 100; i++ {
+		logger.Log("info", "tick", nil)
+	}
+	for i := 0; i < 5; i++ {
+		logger.Log("error", "boom", nil)
+	}
+
+	infos, errors := 0, 0
+	for _, log := range inner.Logs {
+		switch log.Level {
+		case "info":
+			infos++
+		case "error":
+			errors++
+		}
+	}
+
+	// infoは1/10にサンプリングされる
+	if infos != 10 {
+		t.Errorf("Expected 10 sampled info entries, got: %d", infos)
+	}
+	// errorはすべて出力される
+	if errors != 5 {
+		t.Errorf("Expected all 5 error entries, got: %d", errors)
+	}
+}
+
+func TestParseSampleRate(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int
+		wantErr bool
+	}{
+		{input: "1/10", want: 10},
+		{input: "1/1", want: 1},
+		{input: "2/10", wantErr: true},
+		{input: "1/0", wantErr: true},
+		{input: "10", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSampleRate(tt.input)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSampleRate(%q) error = nil, want error", tt.input)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseSampleRate(%q) = %d, %v, want %d", tt.input, got, err, tt.want)
+		}
+	}
+}
+
+func TestApplication_Run_LogSample(t *testing.T) {
+	mockLogger := &MockLogger{}
+	app := &Application{
+		Logger:        mockLogger,
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"a": "1", "b": "2", "c": "3"}},
+		CommandRunner: &MockCommandRunner{},
+		Args:          []string{"program", "/bin/true", "--log-sample", "1/100", "--key", "a", "--key", "b", "--key", "c"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 最初のinfoエントリのみ出力される
+	if len(mockLogger.Logs) != 1 {
+		t.Errorf("Expected 1 sampled log entry, got: %d", len(mockLogger.Logs))
+	}
+}
+
+func TestWarningCounter(t *testing.T) {
+	inner := &MockLogger{}
+	counter := NewWarningCounter(inner)
+
+	counter.Log("info", "a", nil)
+	counter.Log("warn", "b", nil)
+	counter.Log("warn", "c", nil)
+	counter.Log("error", "d", nil)
+
+	if counter.Count() != 2 {
+		t.Errorf("Count() = %d, want 2", counter.Count())
+	}
+	// すべてのエントリが転送される
+	if len(inner.Logs) != 4 {
+		t.Errorf("Expected 4 forwarded entries, got: %d", len(inner.Logs))
+	}
+}
+
+func TestApplication_Run_AbortOnWarning(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "警告なし", args: []string{"--abort-on-warning"}},
+		{name: "衝突の警告", args: []string{"--env-uppercase-replace", "--abort-on-warning"}, wantErr: true},
+		{name: "フラグなしでは警告のみ", args: []string{"--env-uppercase-replace"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockLogger := &MockLogger{}
+			mockRunner := &MockCommandRunner{}
+			app := &Application{
+				Logger:        mockLogger,
+				SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"db-host":"a","db.host":"b"}`}},
+				CommandRunner: mockRunner,
+				Args:          append([]string{"program", "/usr/bin/env", "--key", "db"}, tt.args...),
+			}
+
+			err := app.Run()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && len(mockRunner.ExecutedCommands) > 0 {
+				t.Errorf("Expected no command execution, got: %d", len(mockRunner.ExecutedCommands))
+			}
+
+			// 実行後はラップされたロガーが元に戻る
+			if app.Logger != mockLogger {
+				t.Errorf("Expected logger to be restored after Run, got: %T", app.Logger)
+			}
+		})
+	}
+}
+
+func TestJSONLogger_Buffered(t *testing.T) {
+	var out bytes.Buffer
+	logger := &JSONLogger{Output: &out}
+	logger.EnableBuffering()
+
+	logger.Log("info", "first", nil)
+	logger.Log("error", "second", nil)
+
+	// フラッシュするまでは出力されない
+	if out.Len() != 0 {
+		t.Errorf("Expected no output before Flush, got: %q", out.String())
+	}
+
+	if err := logger.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 log lines after Flush, got: %d", len(lines))
+	}
+	var entry LogEntry
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil || entry.Message != "second" {
+		t.Errorf("Unexpected second entry %q: %v", lines[1], err)
+	}
+}
+
+func TestApplication_Run_BufferedLogsFlushed(t *testing.T) {
+	tests := []struct {
+		name       string
+		runnerErr  error
+		lastLogMsg string
+	}{
+		{name: "成功時", lastLogMsg: "Command executed successfully"},
+		{name: "失敗時", runnerErr: fmt.Errorf("boom"), lastLogMsg: "Command execution failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			app := &Application{
+				Logger:        &JSONLogger{Output: &out},
+				SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}},
+				CommandRunner: &MockCommandRunner{ReturnError: tt.runnerErr},
+				Args:          []string{"program", "/usr/bin/env", "--key", "db", "--json-logs-buffered"},
+			}
+			_ = app.Run()
+
+			// Run完了後にはすべてのエントリが書き出されている
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			var last LogEntry
+			if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil {
+				t.Fatalf("Failed to parse last log line %q: %v", lines[len(lines)-1], err)
+			}
+			if last.Message != tt.lastLogMsg {
+				t.Errorf("Last log message = %q, want %q", last.Message, tt.lastLogMsg)
+			}
+		})
+	}
+}
+
+func TestRedactingLogger(t *testing.T) {
+	inner := &MockLogger{}
+	logger := NewRedactingLogger(inner, []string{"s3cr3t"})
+
+	logger.Log("info", "token is s3cr3t", map[string]interface{}{
+		"args":   []string{"--password", "s3cr3t"},
+		"nested": map[string]interface{}{"value": "prefix-s3cr3t"},
+		"count":  1,
+	})
+
+	if len(inner.Logs) != 1 {
+		t.Fatalf("Expected 1 log entry, got: %d", len(inner.Logs))
+	}
+	entry := inner.Logs[0]
+	if entry.Message != "token is ***" {
+		t.Errorf("Message = %q, want %q", entry.Message, "token is ***")
+	}
+
+	// ネストしたデータ内のシークレットもマスクされる
+	b, err := json.Marshal(entry.Data)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if strings.Contains(string(b), "s3cr3t") {
+		t.Errorf("Expected secret to be redacted, got: %s", b)
+	}
+	if !strings.Contains(string(b), "prefix-***") {
+		t.Errorf("Expected masked nested value, got: %s", b)
+	}
+
+	// 構造体のデータもJSON表現を通してマスクされる
+	logger.AddSecrets("added")
+	logger.Log("info", "struct", struct{ Value string }{Value: "added"})
+	b, _ = json.Marshal(inner.Logs[1].Data)
+	if strings.Contains(string(b), "added") {
+		t.Errorf("Expected struct field to be redacted, got: %s", b)
+	}
+}
+
+func TestApplication_Run_RedactLogs(t *testing.T) {
+	mockLogger := &MockLogger{}
+	app := &Application{
+		Logger:        mockLogger,
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_PASSWORD":"hunter22"}`}},
+		CommandRunner: &MockCommandRunner{},
+		Args:          []string{"program", "/usr/bin/psql", "--key", "db", "--password=hunter22", "--redact-logs", "--mask-label", "[REDACTED]"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// コマンド引数に含まれるシークレットはログに出力されない
+	for _, log := range mockLogger.Logs {
+		b, _ := json.Marshal(log.Data)
+		if strings.Contains(log.Message, "hunter22") || strings.Contains(string(b), "hunter22") {
+			t.Errorf("Secret value leaked in log %q: %s", log.Message, b)
+		}
+	}
+	found := false
+	for _, log := range mockLogger.Logs {
+		if log.Message == "Executing command" {
+			b, _ := json.Marshal(log.Data)
+			found = strings.Contains(string(b), "--password=[REDACTED]")
+		}
+	}
+	if !found {
+		t.Error("Expected masked argument in the Executing command log")
+	}
+}
+
+func TestJSONLogger_DisableHTMLEscape(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &JSONLogger{Output: &buf}
+	data := map[string]string{"url": "https://example.com/?a=1&b=<2>"}
+
+	// デフォルトではHTMLエスケープされる
+	logger.Log("info", "escaped", data)
+	if !strings.Contains(buf.String(), `\u0026`) {
+		t.Errorf("Expected & to be escaped by default, got: %s", buf.String())
+	}
+
+	// 無効化すると&や<>がそのまま出力される
+	buf.Reset()
+	logger.DisableHTMLEscape = true
+	logger.Log("info", "unescaped", data)
+	if !strings.Contains(buf.String(), `https://example.com/?a=1&b=<2>`) {
+		t.Errorf("Expected unescaped URL, got: %s", buf.String())
+	}
+	if strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("Expected exactly one line, got: %q", buf.String())
+	}
+}
+
+func TestParseArgs_JSONLogEscapeHTML(t *testing.T) {
+	opts, err := parseArgs([]string{"program", "/usr/bin/env", "--json-log-escape-html=false"})
+	if err != nil {
+		t.Fatalf("parseArgs() error = %v", err)
+	}
+	if !opts.DisableHTMLEscape {
+		t.Error("Expected DisableHTMLEscape to be set")
+	}
+
+	opts, err = parseArgs([]string{"program", "/usr/bin/env"})
+	if err != nil {
+		t.Fatalf("parseArgs() error = %v", err)
+	}
+	if opts.DisableHTMLEscape {
+		t.Error("Expected HTML escaping by default")
+	}
+}
+
+func TestJSONLogger_Level(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &JSONLogger{Output: &buf}
+
+	// デフォルトのinfoではdebugが抑制される
+	logger.Log("debug", "debug entry", nil)
+	logger.Log("info", "info entry", nil)
+	logger.Log("error", "error entry", nil)
+	if strings.Contains(buf.String(), "debug entry") {
+		t.Errorf("Expected debug entry to be suppressed, got: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "info entry") || !strings.Contains(buf.String(), "error entry") {
+		t.Errorf("Expected info and error entries, got: %s", buf.String())
+	}
+
+	// errorレベルではerrorのみ出力される
+	buf.Reset()
+	logger.Level = "error"
+	logger.Log("info", "info entry", nil)
+	logger.Log("warn", "warn entry", nil)
+	logger.Log("error", "error entry", nil)
+	if got := strings.TrimSpace(buf.String()); strings.Count(got, "\n") != 0 || !strings.Contains(got, "error entry") {
+		t.Errorf("Expected only the error entry, got: %s", got)
+	}
+
+	// debugレベルではすべて出力される
+	buf.Reset()
+	logger.Level = "debug"
+	logger.Log("debug", "debug entry", nil)
+	if !strings.Contains(buf.String(), "debug entry") {
+		t.Errorf("Expected debug entry at debug level, got: %s", buf.String())
+	}
+
+	// 不正なレベルの指定はエラー
+	if _, err := parseArgs([]string{"program", "/bin/true", "--log-level", "verbose"}); err == nil {
+		t.Error("Expected error for an invalid log level, got nil")
+	}
+}
+
+func TestJSONLogger_UpperCaseLevel(t *testing.T) {
+	tests := []struct {
+		args []string
+		want func(string) string
+	}{
+		{args: nil, want: strings.ToLower},
+		{args: []string{"--log-level-case", "lower"}, want: strings.ToLower},
+		{args: []string{"--log-level-case", "upper"}, want: strings.ToUpper},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		app := &Application{
+			Logger:        &JSONLogger{Output: &buf},
+			SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"A":"1"}`}},
+			CommandRunner: &MockCommandRunner{},
+			Args:          append([]string{"program", "/bin/true", "--key", "db"}, tt.args...),
+		}
+		if err := app.Run(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		// 出力されたレベルの大文字・小文字がオプションに従う
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var entry LogEntry
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("Failed to parse log: %v (%q)", err, line)
+			}
+			if entry.Level != tt.want(entry.Level) {
+				t.Errorf("args %v: level = %q, want %q", tt.args, entry.Level, tt.want(entry.Level))
+			}
+		}
+	}
+
+	// 不正な指定はエラー
+	if _, err := parseArgs([]string{"program", "/bin/true", "--log-level-case", "title"}); err == nil {
+		t.Error("Expected error for an invalid level case, got nil")
+	}
+}
+
+func TestApplication_Run_LogOutput(t *testing.T) {
+	// ログの出力先をファイルにすると子プロセスの標準出力には書かれない
+	path := filepath.Join(t.TempDir(), "awsecrun.log")
+	runner, args, _ := helperRunner(t, "argv0")
+	logger := NewJSONLogger()
+	app := &Application{
+		Logger:        logger,
+		SecretManager: &MockSecretManager{},
+		CommandRunner: runner,
+		Args:          append(append([]string{"program", os.Args[0]}, args...), "--log-output", path),
+	}
+	// 子プロセスは環境を引き継ぐのでヘルパーの設定を環境変数で渡す
+	t.Setenv("AWSECRUN_HELPER_PROCESS", "1")
+	t.Setenv("AWSECRUN_HELPER_MODE", "argv0")
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if got := readOutput(t, runner); strings.Contains(got, "{") {
+		t.Errorf("Expected no log lines in the command's stdout, got: %q", got)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !strings.Contains(string(data), "Command executed successfully") {
+		t.Errorf("Expected logs in %s, got: %s", path, data)
+	}
+	info, _ := os.Stat(path)
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("log file mode = %v, want 0600", info.Mode().Perm())
+	}
+	// 実行後は元の出力先に戻る
+	if logger.Output != os.Stderr {
+		t.Error("Expected the logger output to be restored to stderr")
+	}
+}
+
+func TestOpenLogOutput(t *testing.T) {
+	// デフォルトは標準エラー出力
+	if NewJSONLogger().Output != os.Stderr {
+		t.Error("Expected the default log output to be stderr")
+	}
+
+	for dest, want := range map[string]*os.File{"stdout": os.Stdout, "stderr": os.Stderr} {
+		w, closeOutput, err := openLogOutput(dest)
+		if err != nil {
+			t.Fatalf("openLogOutput(%s) error = %v", dest, err)
+		}
+		if w != want {
+			t.Errorf("openLogOutput(%s) = %v, want %v", dest, w, want)
+		}
+		closeOutput()
+	}
+
+	// ファイルは追記される
+	path := filepath.Join(t.TempDir(), "log")
+	os.WriteFile(path, []byte("existing\n"), 0600)
+	w, closeOutput, err := openLogOutput(path)
+	if err != nil {
+		t.Fatalf("openLogOutput(file) error = %v", err)
+	}
+	(&JSONLogger{Output: w}).Log("info", "appended", nil)
+	closeOutput()
+	data, _ := os.ReadFile(path)
+	if !strings.HasPrefix(string(data), "existing\n") || !strings.Contains(string(data), "appended") {
+		t.Errorf("Expected appended log, got: %q", data)
+	}
+}
+
+func TestTextLogger_Log(t *testing.T) {
+	tests := []struct {
+		name string
+		data interface{}
+		want string
+	}{
+		{name: "nilデータ", data: nil, want: "[INFO] Fetching secret"},
+		{
+			name: "マップはキー順のkey=value",
+			data: map[string]interface{}{"secretName": "db", "count": 2, "note": "has space", "keys": []string{"A", "B"}},
+			want: `[INFO] Fetching secret count=2 keys=["A","B"] note="has space" secretName=db`,
+		},
+		{name: "構造体もフィールドごとに出力", data: EnvCounts{Inherited: 3}, want: "[INFO] Fetching secret from_secrets=0 inherited=3 overridden=0"},
+		{name: "オブジェクト以外は1つの値", data: []string{"x"}, want: `[INFO] Fetching secret data=["x"]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			logger := &TextLogger{Output: &out}
+			logger.Log("info", "Fetching secret", tt.data)
+
+			// タイムスタンプ、大文字のレベル、メッセージ、key=valueの順に1行で出力される
+			line := strings.TrimSuffix(out.String(), "\n")
+			timestamp, rest, _ := strings.Cut(line, " ")
+			if _, err := time.Parse(time.RFC3339, timestamp); err != nil || !strings.HasSuffix(timestamp, "Z") {
+				t.Errorf("timestamp %q is not RFC 3339 UTC: %v", timestamp, err)
+			}
+			if rest != tt.want {
+				t.Errorf("line = %q, want %q", rest, tt.want)
+			}
+		})
+	}
+
+	// レベルのしきい値未満は出力しない
+	var out bytes.Buffer
+	(&TextLogger{Output: &out, Level: "warn"}).Log("info", "dropped", nil)
+	if out.Len() != 0 {
+		t.Errorf("Expected info entry to be dropped, got: %q", out.String())
+	}
+}
+
+func TestApplication_Run_LogFormatText(t *testing.T) {
+	var out bytes.Buffer
+	logger := &JSONLogger{Output: &out}
+	app := &Application{
+		Logger:        logger,
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}},
+		CommandRunner: &MockCommandRunner{},
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--log-format", "text"},
+	}
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// すべての行がテキスト形式で出力される
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if strings.HasPrefix(line, "{") || !strings.Contains(line, " [INFO] ") {
+			t.Errorf("Expected a text log line, got: %q", line)
+		}
+	}
+	if !strings.Contains(out.String(), "[INFO] Fetching secret from AWS Secrets Manager secretName=db") {
+		t.Errorf("Expected the fetch entry as text, got: %s", out.String())
+	}
+	// 実行後は元のロガーに戻る
+	if app.Logger != logger {
+		t.Errorf("Expected logger to be restored after Run, got: %T", app.Logger)
+	}
+
+	// 未知の形式はエラー
+	if _, err := parseArgs([]string{"program", "/usr/bin/env", "--log-format", "xml"}); err == nil {
+		t.Error("Expected error for an unknown log format, got nil")
+	}
+}
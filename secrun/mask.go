@@ -0,0 +1,143 @@
+package secrun
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Mask describes the text that replaces a masked secret value
+type Mask struct {
+	// Char is repeated Length times when no Label is set
+	Char   string `json:"char,omitempty"`
+	Length int    `json:"length,omitempty"`
+	// Label, when set, replaces the value verbatim, e.g. [REDACTED]
+	Label string `json:"label,omitempty"`
+}
+
+// DefaultMask renders masked values as ***
+var DefaultMask = Mask{Char: "*", Length: 3}
+
+// String returns the replacement text for a masked value
+func (m Mask) String() string {
+	if m.Label != "" {
+		return m.Label
+	}
+
+	char, length := m.Char, m.Length
+	if char == "" {
+		char = DefaultMask.Char
+	}
+	if length <= 0 {
+		length = DefaultMask.Length
+	}
+	return strings.Repeat(char, length)
+}
+
+// scrubString replaces every occurrence of the given secret values with the mask
+func scrubString(s string, secrets []string, mask Mask) string {
+	// Replace longer values first so a secret containing another is fully masked
+	sorted := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		if secret != "" {
+			sorted = append(sorted, secret)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+
+	replacement := mask.String()
+	for _, secret := range sorted {
+		s = strings.ReplaceAll(s, secret, replacement)
+	}
+	return s
+}
+
+// scrubWriter masks secret values in a byte stream before passing it to w.
+// Output that could still be the start of a secret is held back until the
+// next write or Flush, so values split across writes are masked as well.
+type scrubWriter struct {
+	w           io.Writer
+	secrets     [][]byte
+	replacement []byte
+	pending     []byte
+	// first marks the bytes a secret can start with, so other bytes are copied without matching
+	first [256]bool
+	// maxLen is the length of the longest secret
+	maxLen int
+}
+
+// newScrubWriter creates a scrubWriter that replaces the secrets with mask
+func newScrubWriter(w io.Writer, secrets []string, mask Mask) *scrubWriter {
+	sw := &scrubWriter{w: w, replacement: []byte(mask.String())}
+	for _, secret := range secrets {
+		if secret != "" {
+			sw.secrets = append(sw.secrets, []byte(secret))
+			sw.first[secret[0]] = true
+			sw.maxLen = max(sw.maxLen, len(secret))
+		}
+	}
+	// Match longer values first so a secret containing another is fully masked
+	sort.Slice(sw.secrets, func(i, j int) bool { return len(sw.secrets[i]) > len(sw.secrets[j]) })
+	return sw
+}
+
+// Write masks and forwards everything that can no longer be part of a secret
+func (sw *scrubWriter) Write(p []byte) (int, error) {
+	sw.pending = append(sw.pending, p...)
+	if err := sw.drain(false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush masks and forwards the output held back by Write
+func (sw *scrubWriter) Flush() error {
+	return sw.drain(true)
+}
+
+// drain writes out the masked pending output; unless final, a trailing
+// partial match is kept for the next write
+func (sw *scrubWriter) drain(final bool) error {
+	// Every secret fits before complete, so matches there need no look-ahead;
+	// only the last maxLen-1 bytes can hold the start of a secret cut off by the write
+	complete := len(sw.pending)
+	if !final {
+		complete = max(0, len(sw.pending)-(sw.maxLen-1))
+	}
+
+	out := make([]byte, 0, len(sw.pending))
+	i := 0
+scan:
+	for i < len(sw.pending) {
+		if !sw.first[sw.pending[i]] {
+			out = append(out, sw.pending[i])
+			i++
+			continue
+		}
+		rest := sw.pending[i:]
+		if i >= complete {
+			for _, secret := range sw.secrets {
+				if len(rest) < len(secret) && bytes.HasPrefix(secret, rest) {
+					break scan
+				}
+			}
+		}
+		for _, secret := range sw.secrets {
+			if bytes.HasPrefix(rest, secret) {
+				out = append(out, sw.replacement...)
+				i += len(secret)
+				continue scan
+			}
+		}
+		out = append(out, sw.pending[i])
+		i++
+	}
+	sw.pending = append(sw.pending[:0], sw.pending[i:]...)
+
+	if len(out) == 0 {
+		return nil
+	}
+	_, err := sw.w.Write(out)
+	return err
+}
@@ -0,0 +1,167 @@
+package secrun
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMask_String(t *testing.T) {
+	tests := []struct {
+		name string
+		mask Mask
+		want string
+	}{
+		{name: "デフォルト", mask: DefaultMask, want: "***"},
+		{name: "固定長", mask: Mask{Char: "*", Length: 8}, want: "********"},
+		{name: "別の文字", mask: Mask{Char: "#", Length: 4}, want: "####"},
+		{name: "ラベル", mask: Mask{Char: "*", Length: 8, Label: "[REDACTED]"}, want: "[REDACTED]"},
+		{name: "ゼロ値", mask: Mask{}, want: "***"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.mask.String(); got != tt.want {
+				t.Errorf("Mask.String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScrubString(t *testing.T) {
+	secrets := []string{"secure123", "secure123-extended", ""}
+	input := "password=secure123 token=secure123-extended"
+
+	// 設定したマスクがスクラブ結果に現れることを確認
+	got := scrubString(input, secrets, Mask{Label: "[REDACTED]"})
+	want := "password=[REDACTED] token=[REDACTED]"
+	if got != want {
+		t.Errorf("scrubString() = %q, want %q", got, want)
+	}
+
+	got = scrubString(input, secrets, Mask{Char: "*", Length: 8})
+	want = "password=******** token=********"
+	if got != want {
+		t.Errorf("scrubString() = %q, want %q", got, want)
+	}
+}
+
+func TestParseArgs_Mask(t *testing.T) {
+	opts, err := parseArgs([]string{"program", "/bin/true", "--mask-char", "#", "--mask-length", "5"})
+	if err != nil {
+		t.Fatalf("parseArgs() error = %v", err)
+	}
+	if got := opts.Mask.String(); got != "#####" {
+		t.Errorf("Mask = %q, want %q", got, "#####")
+	}
+
+	opts, err = parseArgs([]string{"program", "/bin/true", "--mask-label", "[REDACTED]"})
+	if err != nil {
+		t.Fatalf("parseArgs() error = %v", err)
+	}
+	if got := opts.Mask.String(); got != "[REDACTED]" {
+		t.Errorf("Mask = %q, want %q", got, "[REDACTED]")
+	}
+
+	// 複数文字のマスク文字はエラー
+	if _, err := parseArgs([]string{"program", "/bin/true", "--mask-char", "**"}); err == nil {
+		t.Error("Expected error for multi-character mask, got nil")
+	}
+}
+
+func TestScrubWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := newScrubWriter(&buf, []string{"secure123", "secure123-extended", ""}, DefaultMask)
+
+	// 書き込みの境界で分割されたシークレットもマスクされる
+	w.Write([]byte("password=secu"))
+	if strings.Contains(buf.String(), "secu") {
+		t.Errorf("Expected the partial secret to be held back, got: %q", buf.String())
+	}
+	w.Write([]byte("re123\ntoken=secure123-ext"))
+	w.Write([]byte("ended\n"))
+	// シークレットの先頭に見える出力も最後には書き出される
+	w.Write([]byte("sec"))
+	w.Flush()
+
+	want := "password=***\ntoken=***\nsec"
+	if got := buf.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+// scrubInput はシークレットを散りばめた約sizeバイトの出力とマスク後の期待値を返す
+func scrubInput(size int) (string, string) {
+	var input, want strings.Builder
+	for i := 0; input.Len() < size; i++ {
+		line := fmt.Sprintf("line %d: status ok, sent 42 bytes\n", i)
+		input.WriteString(line)
+		want.WriteString(line)
+		if i%100 == 0 {
+			input.WriteString("token=secure123\n")
+			want.WriteString("token=***\n")
+		}
+	}
+	return input.String(), want.String()
+}
+
+func TestScrubWriter_LargeWrites(t *testing.T) {
+	input, want := scrubInput(4 << 20)
+	var buf bytes.Buffer
+	w := newScrubWriter(&buf, []string{"secure123", "secure123-extended"}, DefaultMask)
+
+	// パイプと同じ32KiB単位の大きな書き込みでも短時間でマスクされる
+	start := time.Now()
+	for chunk := []byte(input); len(chunk) > 0; {
+		n := min(len(chunk), 32<<10)
+		w.Write(chunk[:n])
+		chunk = chunk[n:]
+	}
+	w.Flush()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("scrubbing %d bytes took %s", len(input), elapsed)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("output differs from the expected masked output (%d bytes, want %d)", len(got), len(want))
+	}
+}
+
+func BenchmarkScrubWriter(b *testing.B) {
+	input, _ := scrubInput(1 << 20)
+	w := newScrubWriter(io.Discard, []string{"secure123", "secure123-extended"}, DefaultMask)
+
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for chunk := []byte(input); len(chunk) > 0; {
+			n := min(len(chunk), 32<<10)
+			w.Write(chunk[:n])
+			chunk = chunk[n:]
+		}
+		w.Flush()
+	}
+}
+
+func TestDefaultCommandRunner_ScrubOutput(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	runner := NewCommandRunner()
+	runner.Stdout = &stdout
+	runner.Stderr = &stderr
+	runner.ScrubSecrets = []string{"secure123"}
+	runner.ScrubMask = Mask{Label: "[REDACTED]"}
+
+	// シークレットを2回に分けて出力しても、stdoutとstderrの両方でマスクされる
+	script := "printf 'pass=secu'; sleep 0.1; printf 're123\\n'; echo secure123 >&2"
+	if err := runner.Run("/bin/sh", []string{"-c", script}, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := stdout.String(); got != "pass=[REDACTED]\n" {
+		t.Errorf("stdout = %q, want %q", got, "pass=[REDACTED]\n")
+	}
+	if got := stderr.String(); got != "[REDACTED]\n" {
+		t.Errorf("stderr = %q, want %q", got, "[REDACTED]\n")
+	}
+}
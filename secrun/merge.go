@@ -0,0 +1,44 @@
+package secrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// deepMergeJSON merges src into dst; nested objects are merged recursively and
+// any other value in src replaces the one in dst
+func deepMergeJSON(dst, src map[string]interface{}) {
+	for k, v := range src {
+		srcObj, srcIsObj := v.(map[string]interface{})
+		dstObj, dstIsObj := dst[k].(map[string]interface{})
+		if srcIsObj && dstIsObj {
+			deepMergeJSON(dstObj, srcObj)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// writeMergedJSON writes the merged secrets as an indented JSON file readable only by the owner
+func writeMergedJSON(filename string, merged map[string]interface{}) error {
+	data, err := json.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode merged JSON: %w", err)
+	}
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write merged JSON: %w", err)
+	}
+	defer f.Close()
+
+	// Tighten the mode of a file that already existed
+	if err := f.Chmod(0600); err != nil {
+		return fmt.Errorf("failed to write merged JSON: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write merged JSON: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,64 @@
+package secrun
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestApplication_Run_MergeJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger: &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: map[string]string{
+			"base":     `{"db":{"host":"db.internal","port":5432},"features":["a"],"name":"app"}`,
+			"override": `{"db":{"host":"db.prod"},"features":["b","c"],"debug":false}`,
+		}},
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "base", "--key", "override", "--merge-json", path},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("merged file is not valid JSON: %v", err)
+	}
+
+	// ネストしたオブジェクトは再帰的にマージされ、衝突時は後のシークレットが優先される
+	want := map[string]interface{}{
+		"db":       map[string]interface{}{"host": "db.prod", "port": float64(5432)},
+		"features": []interface{}{"b", "c"},
+		"name":     "app",
+		"debug":    false,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("merged JSON = %v, want %v", got, want)
+	}
+	if len(mockRunner.ExecutedCommands) != 1 {
+		t.Errorf("Expected the command to run, got: %d executions", len(mockRunner.ExecutedCommands))
+	}
+}
+
+func TestApplication_Run_MergeJSONRejectsNonObject(t *testing.T) {
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"token": "plain-text"}},
+		CommandRunner: &MockCommandRunner{},
+		Args:          []string{"program", "/usr/bin/env", "--key", "token", "--merge-json", filepath.Join(t.TempDir(), "config.json")},
+	}
+
+	// JSONオブジェクトでないシークレットはマージできない
+	if err := app.Run(); err == nil {
+		t.Error("Expected error for a non-object secret, got nil")
+	}
+}
@@ -0,0 +1,65 @@
+package secrun
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RetryCounter is implemented by secret managers that count the requests they retried
+type RetryCounter interface {
+	Retries() int
+}
+
+// FetchMetrics counts the secret fetches of a run for --metrics-file
+type FetchMetrics struct {
+	Fetched int
+	Errors  int
+	// Latency is the total time spent fetching secrets
+	Latency time.Duration
+	Retries int
+}
+
+// Retries returns how many GetSecretValue requests were retried after transient errors
+func (sm *AWSSecretManager) Retries() int {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.retries
+}
+
+// writeMetrics writes m to path in the Prometheus text exposition format. The file is
+// replaced atomically so a collector never reads a partial file.
+func writeMetrics(path string, m FetchMetrics) error {
+	var b bytes.Buffer
+	metric := func(name, help, value string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n%s %s\n", name, help, name, name, value)
+	}
+	metric("awsecrun_secrets_fetched_total", "Secrets fetched successfully.", fmt.Sprint(m.Fetched))
+	metric("awsecrun_secret_fetch_errors_total", "Secret fetches that failed.", fmt.Sprint(m.Errors))
+	metric("awsecrun_secret_fetch_seconds_total", "Total time spent fetching secrets.", fmt.Sprint(m.Latency.Seconds()))
+	metric("awsecrun_secret_fetch_retries_total", "Secret fetch requests retried after transient errors.", fmt.Sprint(m.Retries))
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".metrics-*")
+	if err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	// Collectors such as the node exporter textfile collector run as another user
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	return nil
+}
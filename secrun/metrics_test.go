@@ -0,0 +1,116 @@
+package secrun
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// parseMetrics はPrometheusテキスト形式のサンプル行を名前と値に分解する
+func parseMetrics(t *testing.T, path string) map[string]float64 {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open metrics file: %v", err)
+	}
+	defer f.Close()
+
+	metrics := map[string]float64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			t.Fatalf("Malformed sample line: %q", line)
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			t.Fatalf("Malformed sample value in %q: %v", line, err)
+		}
+		metrics[fields[0]] = v
+	}
+	return metrics
+}
+
+func TestApplication_Run_MetricsFile(t *testing.T) {
+	client := &FlakySecretsManagerClient{
+		MockSecretsManagerClient: MockSecretsManagerClient{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}},
+		Errors: []error{
+			&smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"},
+		},
+	}
+	calls := 0
+	sm := newTestAWSSecretManager(client, &calls)
+	sm.sleep = func(time.Duration) {}
+	WithRetries(3, 100*time.Millisecond)(sm)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "awsecrun.prom")
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: sm,
+		CommandRunner: &MockCommandRunner{},
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--metrics-file", path},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 1回のリトライを経て1件取得できたことが記録される
+	metrics := parseMetrics(t, path)
+	want := map[string]float64{
+		"awsecrun_secrets_fetched_total":      1,
+		"awsecrun_secret_fetch_errors_total":  0,
+		"awsecrun_secret_fetch_retries_total": 1,
+	}
+	for name, v := range want {
+		got, ok := metrics[name]
+		if !ok {
+			t.Errorf("Expected %s in metrics file", name)
+		} else if got != v {
+			t.Errorf("%s = %v, want %v", name, got, v)
+		}
+	}
+	if got, ok := metrics["awsecrun_secret_fetch_seconds_total"]; !ok || got < 0 {
+		t.Errorf("awsecrun_secret_fetch_seconds_total = %v, %v", got, ok)
+	}
+
+	// 一時ファイルは残らない
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read dir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the metrics file in %s, got %d entries", dir, len(entries))
+	}
+}
+
+func TestApplication_Run_MetricsFileOnFetchError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "awsecrun.prom")
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: map[string]string{}},
+		CommandRunner: &MockCommandRunner{},
+		Args:          []string{"program", "/usr/bin/env", "--key", "missing", "--metrics-file", path},
+	}
+
+	if err := app.Run(); err == nil {
+		t.Fatal("Expected error for missing secret")
+	}
+
+	// 失敗した実行でもメトリクスは書き出される
+	metrics := parseMetrics(t, path)
+	if metrics["awsecrun_secret_fetch_errors_total"] != 1 || metrics["awsecrun_secrets_fetched_total"] != 0 {
+		t.Errorf("metrics = %v, want 1 error and 0 fetched", metrics)
+	}
+}
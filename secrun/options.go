@@ -0,0 +1,466 @@
+package secrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Extraction describes a sub-tree of a JSON secret to inject under a prefix
+type Extraction struct {
+	Pointer string `json:"pointer"`
+	Prefix  string `json:"prefix"`
+}
+
+// Join builds an env var from the values of other keys joined by a separator
+type Join struct {
+	Keys   []string `json:"keys"`
+	Target string   `json:"target"`
+	Sep    string   `json:"sep"`
+}
+
+// parseJoin parses a join of the form KEY1,KEY2=TARGET[:SEP]; SEP may be double-quoted
+func parseJoin(v string) (Join, error) {
+	keys, rest, ok := strings.Cut(v, "=")
+	if !ok || keys == "" || rest == "" {
+		return Join{}, fmt.Errorf("invalid --join %q: expected KEY1,KEY2=TARGET[:SEP]", v)
+	}
+	target, sep, _ := strings.Cut(rest, ":")
+	if target == "" {
+		return Join{}, fmt.Errorf("invalid --join %q: missing TARGET", v)
+	}
+	if len(sep) >= 2 && strings.HasPrefix(sep, `"`) && strings.HasSuffix(sep, `"`) {
+		sep = sep[1 : len(sep)-1]
+	}
+
+	join := Join{Target: target, Sep: sep}
+	for _, key := range strings.Split(keys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			join.Keys = append(join.Keys, key)
+		}
+	}
+	if len(join.Keys) == 0 {
+		return Join{}, fmt.Errorf("invalid --join %q: no keys to join", v)
+	}
+	return join, nil
+}
+
+// EnvTemplate sets an env var to a text/template rendered against the assembled environment
+type EnvTemplate struct {
+	Name     string `json:"name"`
+	Template string `json:"template"`
+}
+
+// SecretSpec describes a secret requested on the command line
+type SecretSpec struct {
+	Name string `json:"name"`
+	// NameTemplate, when set, renders Name from the environment before fetching
+	NameTemplate string `json:"nameTemplate,omitempty"`
+	// NameEnv, when set, names the env var whose value is the secret name, from --key @ENVVAR
+	NameEnv string `json:"nameEnv,omitempty"`
+	// Source selects the backend serving the secret; empty means Secrets Manager
+	Source string `json:"source,omitempty"`
+	// Index selects an element of a secret holding a JSON array
+	Index    *ArrayIndex  `json:"index,omitempty"`
+	Extracts []Extraction `json:"extracts,omitempty"`
+	// Select, when set, injects only the field at this dotted path, named As or its last segment
+	Select string `json:"select,omitempty"`
+	As     string `json:"as,omitempty"`
+	// Renames maps a key of the secret to the env var name it is injected as
+	Renames map[string]string `json:"renames,omitempty"`
+	// Include and Exclude filter the keys of the secret by glob; Exclude takes precedence
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// Prefix is prepended to every env var name expanded from the secret
+	Prefix string `json:"prefix,omitempty"`
+	// Version pins the fetched version by id or staging label
+	Version SecretVersion `json:"version,omitempty"`
+	// InjectDate names an env var set to the creation date of the fetched version
+	InjectDate string `json:"injectDate,omitempty"`
+}
+
+// Options configures a run; the CLI parses it from argv, library callers build it from DefaultOptions
+type Options struct {
+	CommandPath string        `json:"commandPath"`
+	Args        []string      `json:"args"`
+	Secrets     []*SecretSpec `json:"secrets"`
+
+	// Region overrides the AWS region from the default configuration chain
+	Region string `json:"region,omitempty"`
+	// AWSConfigFile and AWSCredentialsFile replace the default shared config file paths
+	AWSConfigFile      string `json:"awsConfigFile,omitempty"`
+	AWSCredentialsFile string `json:"awsCredentialsFile,omitempty"`
+	// NoSharedConfig ignores the shared config and credentials files entirely
+	NoSharedConfig bool `json:"noSharedConfig,omitempty"`
+	// Profile selects a named profile from the shared files instead of AWS_PROFILE
+	Profile string `json:"profile,omitempty"`
+	// EndpointURL sends AWS requests to this endpoint instead of the service default, e.g. LocalStack
+	EndpointURL string `json:"endpointUrl,omitempty"`
+
+	// FallbackStage is fetched when the current version of a secret is not found, e.g. AWSPREVIOUS during rotation
+	FallbackStage string `json:"fallbackStage,omitempty"`
+
+	// AssumeRoleARN is a role assumed through STS before reading secrets, e.g. in another account
+	AssumeRoleARN string `json:"assumeRoleArn,omitempty"`
+	ExternalID    string `json:"externalId,omitempty"`
+
+	// AzureVaultURL overrides AZURE_KEYVAULT_URL for --azure-secret
+	AzureVaultURL string `json:"azureVaultUrl,omitempty"`
+
+	// Timeout bounds the time spent fetching all secrets; zero disables it
+	Timeout time.Duration `json:"timeout,omitempty"`
+
+	// MaxRetries and RetryBaseDelay control retries of transient Secrets Manager errors
+	MaxRetries     int           `json:"maxRetries"`
+	RetryBaseDelay time.Duration `json:"retryBaseDelay"`
+
+	// Flatten expands nested JSON objects into keys such as DB_HOST joined by FlattenSep
+	Flatten    bool   `json:"flatten,omitempty"`
+	FlattenSep string `json:"flattenSep"`
+	// FileThreshold, when positive, moves values longer than this many bytes to temp files,
+	// injecting the path as the key with FileSuffix appended
+	FileThreshold int64  `json:"fileThreshold,omitempty"`
+	FileSuffix    string `json:"fileSuffix"`
+	// FailOnEmpty fails instead of warning when a secret expands to no env vars
+	FailOnEmpty bool `json:"failOnEmpty,omitempty"`
+	// OnConflict selects what happens when two secrets define the same env var: last, first or error
+	OnConflict string `json:"onConflict"`
+	// OnNulByte selects how secret values containing NUL bytes are injected: error or file
+	OnNulByte string `json:"onNulByte"`
+	// ArrayRoot selects how secrets whose JSON root is an array are injected: blob, index or join
+	ArrayRoot string `json:"arrayRoot"`
+	// JoinSep separates the elements of an array secret injected with --on-json-array-root join
+	JoinSep string `json:"joinSep"`
+	// BinaryMode selects how binary secrets are injected: base64 or file
+	BinaryMode string `json:"binaryMode"`
+
+	// EncryptionContext is passed to the backends that decrypt with a KMS encryption context
+	EncryptionContext map[string]string `json:"encryptionContext,omitempty"`
+	// ExpectedHashes pins the SHA-256 of a secret's raw string by secret name
+	ExpectedHashes map[string]string `json:"expectedHashes,omitempty"`
+	// AuditFile is where the names of the injected secrets and env vars are written after a successful run
+	AuditFile string `json:"auditFile,omitempty"`
+	// CacheDir, when set, keeps fetched secrets encrypted on disk and reuses them for CacheTTL
+	CacheDir string        `json:"cacheDir,omitempty"`
+	CacheTTL time.Duration `json:"cacheTTL"`
+	// FetchReport is where a summary of each fetched secret's key count and size is written
+	FetchReport string `json:"fetchReport,omitempty"`
+	// LockFile, when set, is locked exclusively from before the secrets are fetched until the command exits
+	LockFile string `json:"lockFile,omitempty"`
+	// LockNonblocking fails instead of waiting when another run holds LockFile
+	LockNonblocking bool `json:"lockNonblocking,omitempty"`
+	// MetricsFile is where fetch counters are written in the Prometheus text format
+	MetricsFile string `json:"metricsFile,omitempty"`
+	// RequireSecretCount is the exact number of secrets that must be fetched; -1 disables the check
+	RequireSecretCount int `json:"requireSecretCount"`
+	// Schemas maps a secret name to the JSON Schema file its payload must conform to
+	Schemas map[string]string `json:"schemas,omitempty"`
+
+	// NormalizeKeys uppercases secret keys and replaces invalid characters with _
+	NormalizeKeys bool `json:"normalizeKeys,omitempty"`
+
+	// Joins build compound env vars from secret keys after all secrets are fetched
+	Joins []Join `json:"joins,omitempty"`
+	// Sets build env vars from templates after the joins, in the order given
+	Sets []EnvTemplate `json:"sets,omitempty"`
+	// CleanEnv starts the command's environment empty instead of inheriting ours,
+	// apart from the variables listed in PassEnv
+	CleanEnv bool     `json:"cleanEnv,omitempty"`
+	PassEnv  []string `json:"passEnv,omitempty"`
+	// NoInheritPath resolves a bare command name only with the PATH of the command's environment
+	NoInheritPath bool `json:"noInheritPath,omitempty"`
+	// RequiredKeys lists env vars that must be present before the command runs
+	RequiredKeys []string `json:"requiredKeys,omitempty"`
+	// EnvAllow lists glob patterns of env var names passed to the command
+	EnvAllow []string `json:"envAllow,omitempty"`
+
+	// Chroot is the root directory the command runs in
+	Chroot string `json:"chroot,omitempty"`
+	// Workdir is the command's working directory
+	Workdir string `json:"workdir,omitempty"`
+	// Argv0 overrides the argv[0] passed to the command
+	Argv0 string `json:"argv0,omitempty"`
+	// Detach starts the command in the background and returns immediately
+	Detach       bool   `json:"detach,omitempty"`
+	DetachOutput string `json:"detachOutput,omitempty"`
+	PIDFile      string `json:"pidFile,omitempty"`
+	// CaptureOutput logs the command's output as structured entries
+	CaptureOutput bool  `json:"captureOutput,omitempty"`
+	OutputLimit   int64 `json:"outputLimit,omitempty"`
+	// OutputJSON is where the command's captured output and exit code are written as JSON
+	OutputJSON string `json:"outputJSON,omitempty"`
+	// FailIfEmptyStdout fails the run when the command succeeds without writing to stdout
+	FailIfEmptyStdout bool `json:"failIfEmptyStdout,omitempty"`
+	// SigtermExit is the exit code used when the command is terminated by SIGTERM; -1 keeps 143
+	SigtermExit int `json:"sigtermExit"`
+	// StdinFile, when set, is opened and fed to the command's stdin
+	StdinFile string `json:"stdinFile,omitempty"`
+	// CommandRetries is how often a command exiting with an error is run again
+	CommandRetries    int           `json:"commandRetries,omitempty"`
+	CommandRetryDelay time.Duration `json:"commandRetryDelay"`
+	// CommandRetryJitter spreads each retry delay randomly by up to this fraction of it
+	CommandRetryJitter float64 `json:"commandRetryJitter,omitempty"`
+	// RefetchOnRestart fetches the secrets again before each command retry
+	RefetchOnRestart bool `json:"refetchOnRestart,omitempty"`
+	// MaxTotalRuntime bounds the time spent across all command attempts; zero means unlimited
+	MaxTotalRuntime time.Duration `json:"maxTotalRuntime,omitempty"`
+	// KillTimeout is how long the command may run after a forwarded signal before it is killed
+	KillTimeout time.Duration `json:"killTimeout,omitempty"`
+
+	// EnvFile, when set, receives the secret-derived env vars in dotenv format instead of running the command
+	EnvFile string `json:"envFile,omitempty"`
+	// MergeJSON, when set, receives all secrets deep-merged into a single JSON file
+	MergeJSON string `json:"mergeJson,omitempty"`
+	// SystemdCreds also writes each secret key as a file in $CREDENTIALS_DIRECTORY
+	SystemdCreds bool `json:"systemdCreds,omitempty"`
+	// PrintCommand logs the resolved command, its args and the names of its env vars before running it
+	PrintCommand bool `json:"printCommand,omitempty"`
+	// DryRun fetches secrets and logs the resolved environment without running the command
+	DryRun bool `json:"dryRun,omitempty"`
+	// ShowValues includes env var values in the dry-run output
+	ShowValues bool `json:"showValues,omitempty"`
+
+	// AssumeYes skips the confirmation asked before running with production secrets
+	AssumeYes bool `json:"assumeYes,omitempty"`
+
+	// WarnUnusedSecrets warns about fetched secrets none of whose keys reach the command
+	WarnUnusedSecrets bool `json:"warnUnusedSecrets,omitempty"`
+
+	// AbortOnWarning turns any warning emitted during the run into a failure
+	AbortOnWarning bool `json:"abortOnWarning,omitempty"`
+
+	// BufferedLogs buffers log output and flushes it when the run ends
+	BufferedLogs bool `json:"bufferedLogs,omitempty"`
+	// LogFormat selects the log entry format: json or text
+	LogFormat string `json:"logFormat"`
+	// LogOutput is where logs are written: stdout, stderr (the default) or a file path
+	LogOutput string `json:"logOutput,omitempty"`
+	// LogLevel is the minimum level of log entries written
+	LogLevel string `json:"logLevel"`
+	// Verbose logs at debug level and records where each env var passed to the command came from
+	Verbose bool `json:"verbose,omitempty"`
+	// LogLevelCase is the casing of the level field: lower or upper
+	LogLevelCase string `json:"logLevelCase"`
+	// DisableHTMLEscape keeps <, > and & unescaped in JSON log output
+	DisableHTMLEscape bool `json:"disableHTMLEscape,omitempty"`
+	// LogSample keeps one in every LogSample info log entries
+	LogSample int `json:"logSample,omitempty"`
+	// RedactLogs masks fetched secret values wherever they appear in log output
+	RedactLogs bool `json:"redactLogs,omitempty"`
+	// ScrubOutput masks fetched secret values in the command's stdout and stderr
+	ScrubOutput bool `json:"scrubOutput,omitempty"`
+	// Check verifies that the secrets are accessible and reports the result instead of running a command
+	Check bool `json:"check,omitempty"`
+	// CommandFromSecret names the Secrets Manager secret holding the command and its arguments
+	CommandFromSecret string `json:"commandFromSecret,omitempty"`
+	// Export prints the secret-derived env vars as shell export statements instead of running a command
+	Export bool `json:"export,omitempty"`
+	// PrintKeys prints the sorted key names of the fetched secrets instead of running a command
+	PrintKeys bool `json:"printKeys,omitempty"`
+	// DumpArgs prints the parsed options as JSON and exits without fetching secrets
+	DumpArgs bool `json:"-"`
+	// Mask controls how secret values are rendered wherever they are masked
+	Mask Mask `json:"mask"`
+}
+
+// printKeysCommand is the subcommand that lists a secret's keys without their values
+const printKeysCommand = "print-keys"
+
+// exportFlag prints export statements for eval instead of running a command when given as the first argument
+const exportFlag = "--export"
+
+// Formats of log entries
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// Casings of the level field in log output
+const (
+	LogLevelCaseLower = "lower"
+	LogLevelCaseUpper = "upper"
+)
+
+// DefaultFetchTimeout bounds the secret fetch phase unless --timeout is given
+const DefaultFetchTimeout = 30 * time.Second
+
+// requested reports whether a secret with the given name is fetched
+func (opts *Options) requested(name string) bool {
+	for _, spec := range opts.Secrets {
+		if spec.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// dumpArgs writes the parsed options as indented JSON
+func dumpArgs(w io.Writer, opts *Options) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(opts); err != nil {
+		return fmt.Errorf("failed to encode arguments: %w", err)
+	}
+	return nil
+}
+
+// DefaultOptions returns the options used when no flags are given
+func DefaultOptions() *Options {
+	return &Options{
+		Args: []string{},
+		Mask: DefaultMask,
+
+		RequireSecretCount: -1,
+		Timeout:            DefaultFetchTimeout,
+		MaxRetries:         DefaultMaxRetries,
+		RetryBaseDelay:     DefaultRetryBaseDelay,
+		CommandRetryDelay:  DefaultCommandRetryDelay,
+		CacheTTL:           DefaultCacheTTL,
+		BinaryMode:         BinaryModeBase64,
+		ArrayRoot:          ArrayRootBlob,
+		JoinSep:            DefaultJoinSep,
+		OnNulByte:          NulByteError,
+		OnConflict:         ConflictLast,
+		FlattenSep:         DefaultFlattenSep,
+		FileSuffix:         DefaultFileSuffix,
+		LogLevel:           DefaultLogLevel,
+		LogLevelCase:       LogLevelCaseLower,
+		LogFormat:          LogFormatJSON,
+		SigtermExit:        -1,
+	}
+}
+
+// Validate reports options that cannot be combined
+func (opts *Options) Validate() error {
+	if opts.PrintKeys && len(opts.Args) > 0 {
+		return fmt.Errorf("%s takes a single secret name, got extra arguments %v", printKeysCommand, opts.Args)
+	}
+	if opts.Check && len(opts.Secrets) == 0 {
+		return fmt.Errorf("%s requires at least one secret", checkFlag)
+	}
+	if opts.Check && len(opts.Args) > 0 {
+		return fmt.Errorf("%s does not run a command, got extra arguments %v", checkFlag, opts.Args)
+	}
+	if opts.Export && len(opts.Secrets) == 0 {
+		return fmt.Errorf("%s requires at least one secret", exportFlag)
+	}
+	if opts.Export && len(opts.Args) > 0 {
+		return fmt.Errorf("%s does not run a command, got extra arguments %v", exportFlag, opts.Args)
+	}
+	if opts.CommandFromSecret != "" && (opts.CommandPath != "" || len(opts.Args) > 0) {
+		return fmt.Errorf("%s cannot be combined with a command on the command line; put the command and its arguments in the secret", commandFromSecretFlag)
+	}
+	if opts.LockNonblocking && opts.LockFile == "" {
+		return fmt.Errorf("--lock-nonblocking requires --lock-file")
+	}
+	if opts.LockFile != "" && opts.Detach {
+		// The lock would be released as soon as the detached command started
+		return fmt.Errorf("--lock-file cannot be combined with --detach")
+	}
+	if opts.NoSharedConfig && (opts.AWSConfigFile != "" || opts.AWSCredentialsFile != "") {
+		return fmt.Errorf("--aws-shared-config-disable cannot be combined with --aws-config-file or --aws-credentials-file")
+	}
+	for _, spec := range opts.Secrets {
+		if spec.As != "" && spec.Select == "" && opts.ArrayRoot == ArrayRootBlob {
+			return fmt.Errorf("--as requires --select, or --on-json-array-root index or join, for secret %s", spec.Name)
+		}
+		if spec.Select != "" && len(spec.Extracts) > 0 {
+			return fmt.Errorf("--select cannot be combined with --extract for secret %s", spec.Name)
+		}
+	}
+	if opts.NoSharedConfig && opts.Profile != "" {
+		return fmt.Errorf("--aws-shared-config-disable cannot be combined with --profile")
+	}
+	if opts.FileThreshold > 0 && opts.FileSuffix == "" {
+		return fmt.Errorf("--file-threshold requires a non-empty --file-suffix")
+	}
+	if len(opts.PassEnv) > 0 && !opts.CleanEnv {
+		return fmt.Errorf("--pass-env requires --clean-env")
+	}
+	if opts.CacheDir != "" && opts.BinaryMode == BinaryModeFile {
+		return fmt.Errorf("--cache-dir cannot be combined with --binary-mode file")
+	}
+	if opts.ExternalID != "" && opts.AssumeRoleARN == "" {
+		return fmt.Errorf("--external-id requires --assume-role-arn")
+	}
+	if opts.ScrubOutput && opts.Detach {
+		return fmt.Errorf("--scrub-output cannot be combined with --detach")
+	}
+	if opts.CommandRetries > 0 && opts.Detach {
+		return fmt.Errorf("--command-retries cannot be combined with --detach")
+	}
+	if opts.MaxTotalRuntime > 0 && opts.CommandRetries == 0 {
+		return fmt.Errorf("--max-total-runtime-across-retries requires --command-retries")
+	}
+	if opts.RefetchOnRestart && opts.CommandRetries == 0 {
+		return fmt.Errorf("--refetch-on-restart requires --command-retries")
+	}
+	if opts.OutputJSON != "" && (opts.CaptureOutput || opts.Detach) {
+		return fmt.Errorf("--command-output-json cannot be combined with --capture-output or --detach")
+	}
+
+	return nil
+}
+
+// parseArgs separates AWSecRun options from the command and its arguments
+func parseArgs(argv []string) (*Options, error) {
+	if len(argv) < 2 {
+		return nil, fmt.Errorf("Usage: go run main.go <command_path> [args...] [--key SECRET_NAME]")
+	}
+
+	opts := DefaultOptions()
+	opts.CommandPath = argv[1]
+
+	var last *SecretSpec
+	start := 2
+	if argv[1] == printKeysCommand {
+		// print-keys SECRET_NAME [options] lists the keys of a secret instead of running a command
+		if len(argv) < 3 || strings.HasPrefix(argv[2], "-") {
+			return nil, fmt.Errorf("Usage: awsecrun %s SECRET_NAME [options]", printKeysCommand)
+		}
+		opts.CommandPath = ""
+		opts.PrintKeys = true
+		last = &SecretSpec{Name: argv[2]}
+		opts.Secrets = append(opts.Secrets, last)
+		start = 3
+	}
+	if argv[1] == checkFlag {
+		// --check [options] checks access to the secrets instead of running a command
+		opts.CommandPath = ""
+		opts.Check = true
+	}
+	if argv[1] == commandFromSecretFlag || strings.HasPrefix(argv[1], commandFromSecretFlag+"=") {
+		// The command comes from a secret, so every argument is an option
+		opts.CommandPath = ""
+		start = 1
+	}
+	if argv[1] == exportFlag {
+		// --export [options] prints export statements for the secrets instead of running a command
+		opts.CommandPath = ""
+		opts.Export = true
+	}
+	p := &argParser{argv: argv, pos: start, opts: opts, last: last}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+
+	if p.cacheTTLSet && opts.CacheDir == "" {
+		return nil, fmt.Errorf("--cache-ttl requires --cache-dir")
+	}
+	if p.flattenSepSet && !opts.Flatten {
+		return nil, fmt.Errorf("--flatten-sep requires --flatten")
+	}
+	if p.joinSepSet && opts.ArrayRoot != ArrayRootJoin {
+		return nil, fmt.Errorf("--join-sep requires --on-json-array-root join")
+	}
+	if p.fileSuffixSet && opts.FileThreshold == 0 {
+		return nil, fmt.Errorf("--file-suffix requires --file-threshold")
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,319 @@
+package secrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDumpArgs(t *testing.T) {
+	opts, err := parseArgs([]string{"program", "/usr/bin/psql", "-h", "db.local", "--key", "db", "--prefix", "DB_", "--param", "/app/flag", "--region", "eu-west-1", "--dump-args-json"})
+	if err != nil {
+		t.Fatalf("parseArgs() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := dumpArgs(&buf, opts); err != nil {
+		t.Fatalf("dumpArgs() error = %v", err)
+	}
+
+	var dumped struct {
+		CommandPath string       `json:"commandPath"`
+		Args        []string     `json:"args"`
+		Secrets     []SecretSpec `json:"secrets"`
+		Region      string       `json:"region"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &dumped); err != nil {
+		t.Fatalf("Failed to parse dumped JSON: %v\n%s", err, buf.String())
+	}
+
+	// コマンド・引数・シークレット・オプションの分類を確認
+	if dumped.CommandPath != "/usr/bin/psql" {
+		t.Errorf("commandPath = %q, want /usr/bin/psql", dumped.CommandPath)
+	}
+	if len(dumped.Args) != 2 || dumped.Args[0] != "-h" || dumped.Args[1] != "db.local" {
+		t.Errorf("args = %v, want [-h db.local]", dumped.Args)
+	}
+	if len(dumped.Secrets) != 2 {
+		t.Fatalf("secrets = %+v, want 2 specs", dumped.Secrets)
+	}
+	if dumped.Secrets[0].Name != "db" || dumped.Secrets[0].Prefix != "DB_" || dumped.Secrets[0].Source != "" {
+		t.Errorf("secrets[0] = %+v, want db with prefix DB_", dumped.Secrets[0])
+	}
+	if dumped.Secrets[1].Name != "/app/flag" || dumped.Secrets[1].Source != SourceSSM {
+		t.Errorf("secrets[1] = %+v, want /app/flag from ssm", dumped.Secrets[1])
+	}
+	if dumped.Region != "eu-west-1" {
+		t.Errorf("region = %q, want eu-west-1", dumped.Region)
+	}
+}
+
+func TestApplication_Run_DumpArgs(t *testing.T) {
+	// 標準出力をキャプチャする
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	defer func() {
+		os.Stdout = oldStdout
+	}()
+
+	mockSecretManager := &MockSecretManager{}
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: mockSecretManager,
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--dump-args-json"},
+	}
+
+	err := app.Run()
+	w.Close()
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// シークレットの取得もコマンドの実行も行わない
+	if len(mockSecretManager.Calls) != 0 || len(mockRunner.ExecutedCommands) != 0 {
+		t.Errorf("Expected no secret fetches or commands, got: %v %v", mockSecretManager.Calls, mockRunner.ExecutedCommands)
+	}
+	if !json.Valid(buf.Bytes()) {
+		t.Errorf("Expected JSON on stdout, got: %s", buf.String())
+	}
+}
+
+func TestParseArgs_Defaults(t *testing.T) {
+	opts, err := parseArgs([]string{"program", "/bin/true"})
+	if err != nil {
+		t.Fatalf("parseArgs() error = %v", err)
+	}
+
+	// フラグを指定しない場合のデフォルト値
+	if opts.Timeout != DefaultFetchTimeout {
+		t.Errorf("Timeout = %v, want %v", opts.Timeout, DefaultFetchTimeout)
+	}
+	if opts.MaxRetries != DefaultMaxRetries || opts.RetryBaseDelay != DefaultRetryBaseDelay {
+		t.Errorf("MaxRetries = %d, RetryBaseDelay = %v; want %d, %v", opts.MaxRetries, opts.RetryBaseDelay, DefaultMaxRetries, DefaultRetryBaseDelay)
+	}
+	if opts.BinaryMode != BinaryModeBase64 {
+		t.Errorf("BinaryMode = %q, want %q", opts.BinaryMode, BinaryModeBase64)
+	}
+	if opts.ArrayRoot != ArrayRootBlob {
+		t.Errorf("ArrayRoot = %q, want %q", opts.ArrayRoot, ArrayRootBlob)
+	}
+	if opts.LogLevel != DefaultLogLevel || opts.LogLevelCase != LogLevelCaseLower {
+		t.Errorf("LogLevel = %q, LogLevelCase = %q; want %q, %q", opts.LogLevel, opts.LogLevelCase, DefaultLogLevel, LogLevelCaseLower)
+	}
+	if opts.RequireSecretCount != -1 {
+		t.Errorf("RequireSecretCount = %d, want -1", opts.RequireSecretCount)
+	}
+	if opts.SigtermExit != -1 {
+		t.Errorf("SigtermExit = %d, want -1", opts.SigtermExit)
+	}
+}
+
+func TestApplication_Run_ArgsSeparator(t *testing.T) {
+	mockSecretManager := &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}}
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: mockSecretManager,
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/gpg", "--key", "db", "--", "--key", "ABCDEF", "--region", "--"},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// -- より前のフラグだけを解釈する
+	if len(mockSecretManager.Calls) != 1 || mockSecretManager.Calls[0] != "db" {
+		t.Errorf("Expected only 'db' to be fetched, got: %v", mockSecretManager.Calls)
+	}
+	// -- より後ろはそのままコマンドに渡す
+	want := []string{"--key", "ABCDEF", "--region", "--"}
+	got := mockRunner.ExecutedCommands[0].Args
+	if len(got) != len(want) {
+		t.Fatalf("Args = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Args[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseJoin_Invalid(t *testing.T) {
+	for _, v := range []string{"CREDS", "=CREDS:,", "A,B=", "A,B=:sep", " , =T"} {
+		if _, err := parseJoin(v); err == nil {
+			t.Errorf("parseJoin(%q) expected error, got nil", v)
+		}
+	}
+}
+
+func TestApplication_Run_PrintKeys(t *testing.T) {
+	// 標準出力をキャプチャする
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	defer func() {
+		os.Stdout = oldStdout
+	}()
+
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin","DB_PASSWORD":"secure123","API_KEY":"xyz"}`}},
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "print-keys", "db", "--prefix", "APP_"},
+	}
+
+	err := app.Run()
+	w.Close()
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// キー名だけがソートされて出力され、値は出力されない
+	if got, want := buf.String(), "APP_API_KEY\nAPP_DB_PASSWORD\nAPP_DB_USER\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	for _, value := range []string{"admin", "secure123", "xyz"} {
+		if strings.Contains(buf.String(), value) {
+			t.Errorf("Expected no secret values in output, found %q", value)
+		}
+	}
+	if len(mockRunner.ExecutedCommands) != 0 {
+		t.Errorf("Expected no command to run, got: %v", mockRunner.ExecutedCommands)
+	}
+
+	// シークレット名の指定がない、または余分な引数はエラー
+	for _, args := range [][]string{{"program", "print-keys"}, {"program", "print-keys", "--key", "db"}, {"program", "print-keys", "db", "extra"}} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("parseArgs(%v) expected error, got nil", args)
+		}
+	}
+}
+
+func TestParseArgs_InlineValues(t *testing.T) {
+	opts, err := parseArgs([]string{"program", "/usr/bin/psql",
+		"--key=db", "-h", "db.local", "--prefix=DB_", "--region", "eu-west-1",
+		"--timeout=5s", "--output=json", "mydb", "--key", "api", "--rename=TOKEN=API_TOKEN",
+		"--json-log-escape-html=false", "--dry-run", "--", "--region=ignored"})
+	if err != nil {
+		t.Fatalf("parseArgs() error = %v", err)
+	}
+
+	// --flag=value と --flag value のどちらでも同じように解釈される
+	if len(opts.Secrets) != 2 || opts.Secrets[0].Name != "db" || opts.Secrets[1].Name != "api" {
+		t.Fatalf("Secrets = %+v, want db and api", opts.Secrets)
+	}
+	if opts.Secrets[0].Prefix != "DB_" {
+		t.Errorf("Prefix = %q, want DB_", opts.Secrets[0].Prefix)
+	}
+	// 値の中の=はそのまま残る
+	if opts.Secrets[1].Renames["TOKEN"] != "API_TOKEN" {
+		t.Errorf("Renames = %v, want TOKEN=API_TOKEN", opts.Secrets[1].Renames)
+	}
+	if opts.Region != "eu-west-1" || opts.Timeout != 5*time.Second {
+		t.Errorf("Region = %q, Timeout = %v", opts.Region, opts.Timeout)
+	}
+	if !opts.DisableHTMLEscape || !opts.DryRun {
+		t.Errorf("DisableHTMLEscape = %v, DryRun = %v; want both true", opts.DisableHTMLEscape, opts.DryRun)
+	}
+
+	// 未知のオプションと位置引数は順序を保ってコマンドに渡る
+	want := []string{"-h", "db.local", "--output=json", "mydb", "--region=ignored"}
+	if strings.Join(opts.Args, " ") != strings.Join(want, " ") {
+		t.Errorf("Args = %v, want %v", opts.Args, want)
+	}
+}
+
+func TestParseArgs_InlineValueErrors(t *testing.T) {
+	tests := [][]string{
+		// 値を取らないオプションに値を付けるとエラー
+		{"program", "/bin/true", "--dry-run=yes"},
+		// 値の検証は通常の形式と同じ
+		{"program", "/bin/true", "--timeout=soon"},
+		{"program", "/bin/true", "--json-log-escape-html=maybe"},
+		// 直前の--keyが必要なオプションも同様
+		{"program", "/bin/true", "--prefix=DB_"},
+	}
+	for _, args := range tests {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("parseArgs(%v) expected error, got nil", args[2:])
+		}
+	}
+}
+
+func TestParseArgs_MixedOrder(t *testing.T) {
+	opts, err := parseArgs([]string{"program", "/usr/bin/tar",
+		"-xzf", "--key", "db", "archive.tgz", "--pass-env=HOME", "-C", "/tmp",
+		"--clean-env", "--key=api", "--pass-env", "PATH", "--no-same-owner"})
+	if err != nil {
+		t.Fatalf("parseArgs() error = %v", err)
+	}
+
+	// コマンドの引数の前後どこにあってもオプションとして解釈され、繰り返しは順に集まる
+	if len(opts.Secrets) != 2 || opts.Secrets[0].Name != "db" || opts.Secrets[1].Name != "api" {
+		t.Errorf("Secrets = %+v, want db and api", opts.Secrets)
+	}
+	if strings.Join(opts.PassEnv, ",") != "HOME,PATH" || !opts.CleanEnv {
+		t.Errorf("PassEnv = %v, CleanEnv = %v; want HOME,PATH and true", opts.PassEnv, opts.CleanEnv)
+	}
+	// 1文字のハイフンで始まる引数はまとめて書かれていてもコマンドのもの
+	want := []string{"-xzf", "archive.tgz", "-C", "/tmp", "--no-same-owner"}
+	if strings.Join(opts.Args, " ") != strings.Join(want, " ") {
+		t.Errorf("Args = %v, want %v", opts.Args, want)
+	}
+}
+
+func TestParseArgs_InlinePassthrough(t *testing.T) {
+	// --option=value の形のコマンド引数もAWSecRunのオプションとして解釈される
+	opts, err := parseArgs([]string{"program", "/usr/bin/gpg", "--key=foo"})
+	if err != nil {
+		t.Fatalf("parseArgs() error = %v", err)
+	}
+	if len(opts.Secrets) != 1 || opts.Secrets[0].Name != "foo" || len(opts.Args) != 0 {
+		t.Errorf("Secrets = %+v, Args = %v; want secret foo and no args", opts.Secrets, opts.Args)
+	}
+	if _, err := parseArgs([]string{"program", "/usr/bin/gpg", "--dry-run=yes"}); err == nil || !strings.Contains(err.Error(), "does not take a value") {
+		t.Errorf("Expected --dry-run=yes to be rejected, got: %v", err)
+	}
+
+	// コマンドに渡すには -- の後ろに置く
+	opts, err = parseArgs([]string{"program", "/usr/bin/gpg", "--", "--key=foo", "--dry-run=yes"})
+	if err != nil {
+		t.Fatalf("parseArgs() error = %v", err)
+	}
+	want := []string{"--key=foo", "--dry-run=yes"}
+	if len(opts.Secrets) != 0 || strings.Join(opts.Args, " ") != strings.Join(want, " ") {
+		t.Errorf("Secrets = %+v, Args = %v; want no secrets and %v", opts.Secrets, opts.Args, want)
+	}
+}
+
+func TestOptionTable(t *testing.T) {
+	// すべてのオプションは--で始まり、重複しない
+	seen := map[string]bool{}
+	for _, o := range optionTable {
+		for _, name := range o.names {
+			if !strings.HasPrefix(name, "--") || strings.Contains(name, "=") {
+				t.Errorf("invalid option name %q", name)
+			}
+			if seen[name] {
+				t.Errorf("option %s is listed twice", name)
+			}
+			seen[name] = true
+		}
+	}
+	if len(seen) != len(optionHandlers) {
+		t.Errorf("optionHandlers has %d entries, want %d", len(optionHandlers), len(seen))
+	}
+}
@@ -0,0 +1,90 @@
+package secrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FetchReportEntry summarizes one fetched secret without its values
+type FetchReportEntry struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Keys   int    `json:"keys"`
+	Bytes  int    `json:"bytes"`
+}
+
+// writeFetchReport writes the per-secret summary as JSON to path
+func writeFetchReport(path string, entries []FetchReportEntry) error {
+	if entries == nil {
+		entries = []FetchReportEntry{}
+	}
+	data, err := json.MarshalIndent(struct {
+		Secrets []FetchReportEntry `json:"secrets"`
+	}{entries}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode fetch report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write fetch report: %w", err)
+	}
+	return nil
+}
+
+// AuditEntry records the env var names a secret was injected as, never its values
+type AuditEntry struct {
+	Name   string   `json:"name"`
+	Source string   `json:"source"`
+	Keys   []string `json:"keys"`
+}
+
+// auditKeys fills in the keys of each audit entry from the final command environment,
+// so keys dropped by --on-conflict, --set or --child-env-allow are left out and keys
+// moved to files by --file-threshold are listed under their renamed key
+func auditKeys(entries []AuditEntry, owners map[string]string, env []string, sets []EnvTemplate) []AuditEntry {
+	setKeys := make(map[string]bool, len(sets))
+	for _, set := range sets {
+		setKeys[set.Name] = true
+	}
+	keys := make(map[string][]string, len(entries))
+	for _, entry := range env {
+		key, _, _ := strings.Cut(entry, "=")
+		if owner, ok := owners[key]; ok && !setKeys[key] {
+			keys[owner] = append(keys[owner], key)
+		}
+	}
+
+	for i := range entries {
+		entries[i].Keys = keys[entries[i].Name]
+		if entries[i].Keys == nil {
+			entries[i].Keys = []string{}
+		}
+		sort.Strings(entries[i].Keys)
+	}
+	return entries
+}
+
+// AuditRecord is the summary of the secrets a run injected into its command
+type AuditRecord struct {
+	Timestamp time.Time    `json:"timestamp"`
+	Command   string       `json:"command"`
+	Secrets   []AuditEntry `json:"secrets"`
+}
+
+// writeAuditRecord writes the audit record as JSON to path
+func writeAuditRecord(path string, record AuditRecord) error {
+	if record.Secrets == nil {
+		record.Secrets = []AuditEntry{}
+	}
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package secrun
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplication_Run_FetchReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	secrets := map[string]string{
+		"api-keys":  `{"API_KEY":"xyz123","API_SECRET":"abc456"}`,
+		"db-config": `{"DB_HOST":"localhost","DB_PORT":"5432","DB_USER":"admin"}`,
+	}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: secrets},
+		CommandRunner: &MockCommandRunner{},
+		Args:          []string{"program", "/usr/bin/env", "--key", "api-keys", "--key", "db-config", "--fetch-report", path},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	var report struct {
+		Secrets []FetchReportEntry `json:"secrets"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("Failed to parse report: %v", err)
+	}
+
+	// シークレットごとのキー数とサイズが記録される
+	want := []FetchReportEntry{
+		{Name: "api-keys", Source: "unknown", Keys: 2, Bytes: len(secrets["api-keys"])},
+		{Name: "db-config", Source: "unknown", Keys: 3, Bytes: len(secrets["db-config"])},
+	}
+	if len(report.Secrets) != len(want) {
+		t.Fatalf("report = %+v, want %+v", report.Secrets, want)
+	}
+	for i := range want {
+		if report.Secrets[i] != want[i] {
+			t.Errorf("report[%d] = %+v, want %+v", i, report.Secrets[i], want[i])
+		}
+	}
+
+	// 値はレポートに含まれない
+	for _, value := range []string{"xyz123", "abc456", "admin"} {
+		if strings.Contains(string(data), value) {
+			t.Errorf("Report leaked value %q: %s", value, data)
+		}
+	}
+}
+
+func TestApplication_Run_AuditFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.json")
+	server := newGCPServer(t, map[string]string{
+		"projects/p1/secrets/api/versions/latest": `{"API_KEY":"from-gcp"}`,
+	})
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"db-config": `{"DB_USER":"admin","DB_PASSWORD":"secure123"}`}},
+		CommandRunner: &MockCommandRunner{},
+		Args:          []string{"program", "/usr/bin/env", "--key", "db-config", "--prefix", "APP_", "--gcp-secret", "projects/p1/secrets/api", "--audit-file", path},
+		Backends:      map[string]SecretManager{SourceGCP: newTestGCPSecretManager(server)},
+		Now:           func() time.Time { return now },
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	var record AuditRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("Failed to parse audit record: %v", err)
+	}
+
+	// 取得元ごとにシークレット名と注入した環境変数名が記録される
+	if !record.Timestamp.Equal(now) || record.Command != "/usr/bin/env" {
+		t.Errorf("timestamp = %v, command = %q", record.Timestamp, record.Command)
+	}
+	if len(record.Secrets) != 2 {
+		t.Fatalf("secrets = %+v, want 2 entries", record.Secrets)
+	}
+	if got := record.Secrets[0]; got.Name != "db-config" || strings.Join(got.Keys, ",") != "APP_DB_PASSWORD,APP_DB_USER" {
+		t.Errorf("secrets[0] = %+v, want db-config with APP_DB_PASSWORD,APP_DB_USER", got)
+	}
+	if got := record.Secrets[1]; got.Name != "projects/p1/secrets/api" || got.Source != SourceGCP || strings.Join(got.Keys, ",") != "API_KEY" {
+		t.Errorf("secrets[1] = %+v, want GCP secret with API_KEY", got)
+	}
+
+	// 値は記録されない
+	for _, value := range []string{"admin", "secure123", "from-gcp"} {
+		if strings.Contains(string(data), value) {
+			t.Errorf("Audit record leaked value %q: %s", value, data)
+		}
+	}
+}
+
+func TestApplication_Run_AuditFileFinalKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.json")
+	app := &Application{
+		Logger: &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: map[string]string{
+			"db":      `{"DB_USER":"admin","DB_CERT":"` + strings.Repeat("x", 64) + `","LOG_LEVEL":"debug"}`,
+			"db-next": `{"DB_USER":"next","DB_HOST":"localhost"}`,
+		}},
+		CommandRunner: &MockCommandRunner{},
+		Args: []string{"program", "/usr/bin/env", "--key", "db", "--key", "db-next", "--on-conflict", "first",
+			"--child-env-allow", "DB_*", "--file-threshold", "32", "--audit-file", path},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	var record AuditRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("Failed to parse audit record: %v", err)
+	}
+
+	// 競合で採用されなかったキーや許可リストで除外したキーは含まれず、ファイル化したキーは変更後の名前で記録される
+	want := map[string]string{
+		"db":      "DB_CERT_FILE,DB_USER",
+		"db-next": "DB_HOST",
+	}
+	if len(record.Secrets) != len(want) {
+		t.Fatalf("secrets = %+v, want %d entries", record.Secrets, len(want))
+	}
+	for _, entry := range record.Secrets {
+		if got := strings.Join(entry.Keys, ","); got != want[entry.Name] {
+			t.Errorf("keys of %s = %s, want %s", entry.Name, got, want[entry.Name])
+		}
+	}
+}
+
+func TestApplication_Run_AuditFileNotWrittenOnFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.json")
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}},
+		CommandRunner: &MockCommandRunner{ReturnError: errors.New("exit status 1")},
+		Args:          []string{"program", "/bin/false", "--key", "db", "--audit-file", path},
+	}
+
+	if err := app.Run(); err == nil {
+		t.Fatal("Expected error, got nil")
+	}
+	// コマンドが失敗した場合は書き出さない
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected no audit record, stat error = %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package secrun
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// Defaults for retrying transient Secrets Manager errors
+const (
+	DefaultMaxRetries     = 3
+	DefaultRetryBaseDelay = 200 * time.Millisecond
+)
+
+// DefaultCommandRetryDelay is the pause between attempts of a failed command
+const DefaultCommandRetryDelay = time.Second
+
+// retryableErrorCodes are API error codes that indicate a transient failure
+var retryableErrorCodes = map[string]bool{
+	"ThrottlingException":      true,
+	"Throttling":               true,
+	"TooManyRequestsException": true,
+	"RequestTimeout":           true,
+	"RequestTimeoutException":  true,
+	"InternalServiceError":     true,
+	"ServiceUnavailable":       true,
+}
+
+// httpStatusError is implemented by SDK errors that carry an HTTP response status
+type httpStatusError interface {
+	HTTPStatusCode() int
+}
+
+// isRetryable reports whether err is a throttling or transient server error
+func isRetryable(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && retryableErrorCodes[apiErr.ErrorCode()] {
+		return true
+	}
+	var statusErr httpStatusError
+	if errors.As(err, &statusErr) && statusErr.HTTPStatusCode() >= 500 {
+		return true
+	}
+	return false
+}
+
+// isNotFound reports whether err is a ResourceNotFoundException, as returned when a
+// secret or the requested version of it does not exist
+func isNotFound(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "ResourceNotFoundException"
+}
+
+// backoffDelay returns the delay before the given retry attempt, doubling from base
+func backoffDelay(base time.Duration, attempt int) time.Duration {
+	return base << (attempt - 1)
+}
+
+// commandRetry controls how often a failed command is run again
+type commandRetry struct {
+	// retries is the number of additional attempts after the first
+	retries int
+	delay   time.Duration
+	// jitter spreads each delay uniformly over delay*(1±jitter)
+	jitter float64
+	// random returns a number in [0, 1) used for the jitter
+	random func() float64
+	// maxTotal bounds the time spent across all attempts; zero means unlimited
+	maxTotal time.Duration
+	now      func() time.Time
+	sleep    func(time.Duration)
+	// before is called before every attempt after the first
+	before func() error
+}
+
+// run calls attempt until it succeeds, the retries are used up, the command is
+// killed by a signal or cancelled, or the next attempt would start past the total
+// runtime limit
+func (r commandRetry) run(logger Logger, attempt func() error) error {
+	start := r.now()
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil || n > r.retries {
+			return err
+		}
+		if _, ok := terminatingSignal(err); ok {
+			return err
+		}
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+
+		elapsed := r.now().Sub(start)
+		if r.maxTotal > 0 && elapsed+r.delay >= r.maxTotal {
+			logger.Log("warn", "Not retrying command: total runtime limit reached", map[string]interface{}{
+				"attempts":  n,
+				"elapsed":   elapsed.String(),
+				"limit":     r.maxTotal.String(),
+				"lastError": err.Error(),
+			})
+			return err
+		}
+
+		delay := r.jitteredDelay()
+		logger.Log("warn", "Retrying failed command", map[string]interface{}{
+			"attempt": n,
+			"delay":   delay.String(),
+			"error":   err.Error(),
+		})
+		r.sleep(delay)
+		if r.before != nil {
+			if err := r.before(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// jitteredDelay returns the delay moved randomly by up to jitter times itself in either direction
+func (r commandRetry) jitteredDelay() time.Duration {
+	if r.jitter <= 0 || r.random == nil {
+		return r.delay
+	}
+	factor := 1 + r.jitter*(2*r.random()-1)
+	return time.Duration(float64(r.delay) * factor)
+}
@@ -0,0 +1,49 @@
+//go:build !unix
+
+package secrun
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// configureProcAttr applies the platform-specific process attributes
+func (cr *DefaultCommandRunner) configureProcAttr(cmd *exec.Cmd) error {
+	if cr.Chroot != "" {
+		return fmt.Errorf("--chroot is not supported on this platform")
+	}
+	return nil
+}
+
+// signalTarget returns where signals for a started command are delivered
+func (cr *DefaultCommandRunner) signalTarget(cmd *exec.Cmd) signalTarget {
+	return cmd.Process
+}
+
+// findExecutable returns the file in dir named file, trying the extensions in
+// PATHEXT when file has none, as Windows does
+func findExecutable(dir, file string) (string, bool) {
+	exts := []string{""}
+	if filepath.Ext(file) == "" {
+		pathext := os.Getenv("PATHEXT")
+		if pathext == "" {
+			pathext = ".com;.exe;.bat;.cmd"
+		}
+		exts = nil
+		for _, ext := range strings.Split(strings.ToLower(pathext), ";") {
+			if ext != "" {
+				exts = append(exts, ext)
+			}
+		}
+	}
+	for _, ext := range exts {
+		path := filepath.Join(dir, file+ext)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, true
+		}
+	}
+	return "", false
+}
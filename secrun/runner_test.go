@@ -0,0 +1,425 @@
+package secrun
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestHelperProcess はテストから子プロセスとして起動されるヘルパー
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("AWSECRUN_HELPER_PROCESS") != "1" {
+		return
+	}
+	defer os.Exit(0)
+
+	switch os.Getenv("AWSECRUN_HELPER_MODE") {
+	case "argv0":
+		fmt.Print(os.Args[0])
+	case "sigterm":
+		p, _ := os.FindProcess(os.Getpid())
+		p.Signal(syscall.SIGTERM)
+		time.Sleep(10 * time.Second)
+	case "pwd":
+		dir, _ := os.Getwd()
+		fmt.Print(dir)
+	case "silent":
+	case "stdin":
+		io.Copy(os.Stdout, os.Stdin)
+	case "output":
+		fmt.Print("to stdout")
+		fmt.Fprint(os.Stderr, "to stderr")
+		os.Exit(3)
+	case "trap-signals":
+		// SIGTERMとSIGHUPを捕捉して既知の終了コードで終了する
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGHUP)
+		fmt.Println("ready")
+		select {
+		case sig := <-sigCh:
+			if sig == syscall.SIGHUP {
+				os.Exit(8)
+			}
+			os.Exit(7)
+		case <-time.After(30 * time.Second):
+		}
+	case "ignore-signals":
+		signal.Ignore(os.Interrupt, syscall.SIGTERM)
+		fmt.Println("ready")
+		time.Sleep(30 * time.Second)
+	}
+}
+
+// helperRunner はヘルパープロセスを起動するためのランナーと引数を返す
+func helperRunner(t *testing.T, mode string) (*DefaultCommandRunner, []string, []string) {
+	t.Helper()
+
+	runner := NewCommandRunner()
+	runner.Stdout = &bytes.Buffer{}
+	args := []string{"-test.run=TestHelperProcess"}
+	env := append(os.Environ(), "AWSECRUN_HELPER_PROCESS=1", "AWSECRUN_HELPER_MODE="+mode)
+	return runner, args, env
+}
+
+// readOutput はランナーの標準出力に書かれた内容を返す
+func readOutput(t *testing.T, runner *DefaultCommandRunner) string {
+	t.Helper()
+
+	return runner.Stdout.(*bytes.Buffer).String()
+}
+
+func TestDefaultCommandRunner_Argv0(t *testing.T) {
+	runner := NewCommandRunner()
+	runner.Argv0 = "custom-name"
+
+	cmd, err := runner.command("/bin/echo", []string{"hello"}, nil)
+	if err != nil {
+		t.Fatalf("command() error = %v", err)
+	}
+
+	// 実行ファイルのパスは変わらず、argv[0]だけが置き換わる
+	if cmd.Path != "/bin/echo" {
+		t.Errorf("Path = %q, want %q", cmd.Path, "/bin/echo")
+	}
+	if cmd.Args[0] != "custom-name" || cmd.Args[1] != "hello" {
+		t.Errorf("Args = %v, want [custom-name hello]", cmd.Args)
+	}
+}
+
+func TestDefaultCommandRunner_Argv0Run(t *testing.T) {
+	runner, args, env := helperRunner(t, "argv0")
+	runner.Argv0 = "my-service"
+
+	if err := runner.Run(os.Args[0], args, env); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// 子プロセスから見たargv[0]が設定値になっていることを確認
+	if got := readOutput(t, runner); strings.TrimSpace(got) != "my-service" {
+		t.Errorf("child argv[0] = %q, want %q", got, "my-service")
+	}
+}
+
+func TestDefaultCommandRunner_FailIfEmptyStdout(t *testing.T) {
+	// 出力があれば成功
+	runner, args, env := helperRunner(t, "argv0")
+	runner.FailIfEmptyStdout = true
+	if err := runner.Run(os.Args[0], args, env); err != nil {
+		t.Errorf("Run() error = %v, want nil for a command with output", err)
+	}
+	// 出力はそのまま転送される
+	if got := readOutput(t, runner); got == "" {
+		t.Error("Expected stdout to be passed through")
+	}
+
+	// 出力がなければ失敗
+	runner, args, env = helperRunner(t, "silent")
+	runner.FailIfEmptyStdout = true
+	err := runner.Run(os.Args[0], args, env)
+	if err == nil || !strings.Contains(err.Error(), "nothing to stdout") {
+		t.Errorf("Run() error = %v, want empty stdout error", err)
+	}
+	if ExitCode(err) == 0 {
+		t.Error("Expected a non-zero exit code")
+	}
+}
+
+func TestApplication_Run_StdinFromFile(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(input, []byte("line1\nline2\n"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	runner, helperArgs, _ := helperRunner(t, "stdin")
+	t.Setenv("AWSECRUN_HELPER_PROCESS", "1")
+	t.Setenv("AWSECRUN_HELPER_MODE", "stdin")
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{},
+		CommandRunner: runner,
+		Args:          append(append([]string{"program", os.Args[0]}, helperArgs...), "--stdin-from-file", input),
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 子プロセスの標準入力にファイルの内容が渡される
+	if got := readOutput(t, runner); got != "line1\nline2\n" {
+		t.Errorf("stdin = %q, want %q", got, "line1\nline2\n")
+	}
+	if runner.Stdin != os.Stdin {
+		t.Error("Expected runner stdin to be restored after the run")
+	}
+
+	// 存在しないファイルはエラー
+	app.Args = []string{"program", os.Args[0], "--stdin-from-file", filepath.Join(t.TempDir(), "missing.txt")}
+	if err := app.Run(); err == nil {
+		t.Error("Expected error for a missing stdin file, got nil")
+	}
+}
+
+func TestApplication_Run_Workdir(t *testing.T) {
+	dir := t.TempDir()
+	runner, helperArgs, _ := helperRunner(t, "pwd")
+	t.Setenv("AWSECRUN_HELPER_PROCESS", "1")
+	t.Setenv("AWSECRUN_HELPER_MODE", "pwd")
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{},
+		CommandRunner: runner,
+		Args:          append(append([]string{"program", os.Args[0]}, helperArgs...), "--workdir", dir),
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 子プロセスは指定したディレクトリで実行される
+	got, err := filepath.EvalSymlinks(readOutput(t, runner))
+	if err != nil {
+		t.Fatalf("EvalSymlinks() error = %v", err)
+	}
+	want, _ := filepath.EvalSymlinks(dir)
+	if got != want {
+		t.Errorf("working directory = %q, want %q", got, want)
+	}
+
+	// 存在しないパスやファイルはコマンドを起動せずにエラー
+	file := filepath.Join(dir, "file")
+	os.WriteFile(file, nil, 0600)
+	for _, workdir := range []string{filepath.Join(dir, "missing"), file} {
+		mockRunner := &MockCommandRunner{}
+		app := &Application{
+			Logger:        &MockLogger{},
+			SecretManager: &MockSecretManager{},
+			CommandRunner: mockRunner,
+			Args:          []string{"program", "/usr/bin/env", "--workdir", workdir},
+		}
+		if err := app.Run(); err == nil || !strings.Contains(err.Error(), "--workdir") {
+			t.Errorf("Run() with --workdir %s error = %v, want --workdir error", workdir, err)
+		}
+		if len(mockRunner.ExecutedCommands) != 0 {
+			t.Errorf("Expected the command not to run for --workdir %s", workdir)
+		}
+	}
+}
+
+func TestApplication_Run_CommandRetries(t *testing.T) {
+	// 各試行に40秒かかるコマンドを、経過時間を進めるクロックで模倣する
+	current := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var delays []time.Duration
+	mockRunner := &MockCommandRunner{RunFunc: func(string, []string, []string) error {
+		current = current.Add(40 * time.Second)
+		return fmt.Errorf("exit status 1")
+	}}
+	logger := &MockLogger{}
+	app := &Application{
+		Logger:        logger,
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}},
+		CommandRunner: mockRunner,
+		Args: []string{"program", "/bin/false", "--key", "db",
+			"--command-retries", "10", "--command-retry-delay", "10s", "--max-total-runtime-across-retries", "2m"},
+		Now: func() time.Time { return current },
+		Sleep: func(d time.Duration) {
+			delays = append(delays, d)
+			current = current.Add(d)
+		},
+	}
+
+	if err := app.Run(); err == nil {
+		t.Fatal("Expected error, got nil")
+	}
+
+	// 3回目の試行が終わった時点(140秒)で合計時間の上限を超えるので、それ以上再試行しない
+	if len(mockRunner.ExecutedCommands) != 3 {
+		t.Errorf("Expected 3 attempts, got: %d", len(mockRunner.ExecutedCommands))
+	}
+	if len(delays) != 2 || delays[0] != 10*time.Second {
+		t.Errorf("delays = %v, want two 10s delays", delays)
+	}
+	stopped := false
+	for _, log := range logger.Logs {
+		if log.Level == "warn" && strings.Contains(log.Message, "total runtime limit") {
+			stopped = true
+		}
+	}
+	if !stopped {
+		t.Error("Expected warning when the total runtime limit stops retries")
+	}
+}
+
+func TestApplication_Run_RefetchOnRestart(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		wantFetches int
+		wantSecond  string
+	}{
+		{name: "再取得する", args: []string{"--refetch-on-restart"}, wantFetches: 2, wantSecond: "DB_PASSWORD=rotated"},
+		{name: "再取得しない", wantFetches: 1, wantSecond: "DB_PASSWORD=old"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := &MockSecretManager{Secrets: map[string]string{"db": `{"DB_PASSWORD":"old"}`}}
+			var fetchesAtAttempt []int
+			mockRunner := &MockCommandRunner{RunFunc: func(string, []string, []string) error {
+				fetchesAtAttempt = append(fetchesAtAttempt, len(sm.Calls))
+				if len(fetchesAtAttempt) == 1 {
+					// 最初の試行中に資格情報がローテーションされ、古い値では失敗する
+					sm.Secrets["db"] = `{"DB_PASSWORD":"rotated"}`
+					return fmt.Errorf("exit status 1")
+				}
+				return nil
+			}}
+			app := &Application{
+				Logger:        &MockLogger{},
+				SecretManager: sm,
+				CommandRunner: mockRunner,
+				Args:          append([]string{"program", "/usr/bin/env", "--key", "db", "--command-retries", "1"}, tt.args...),
+				Sleep:         func(time.Duration) {},
+			}
+
+			if err := app.Run(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			// 再起動の前にシークレットを取り直し、新しい値で実行する
+			if len(mockRunner.ExecutedCommands) != 2 {
+				t.Fatalf("Expected 2 attempts, got: %d", len(mockRunner.ExecutedCommands))
+			}
+			if len(sm.Calls) != tt.wantFetches || fetchesAtAttempt[1] != tt.wantFetches {
+				t.Errorf("fetches = %d, fetches before the restart = %d, want %d", len(sm.Calls), fetchesAtAttempt[1], tt.wantFetches)
+			}
+			if env := strings.Join(mockRunner.ExecutedCommands[1].Env, "\n"); !strings.Contains(env, tt.wantSecond) {
+				t.Errorf("Expected %s in the restarted command's env, got: %s", tt.wantSecond, env)
+			}
+		})
+	}
+
+	// 再取得に失敗したら再起動しない
+	sm := &MockSecretManager{Secrets: map[string]string{"db": `{"DB_PASSWORD":"old"}`}}
+	mockRunner := &MockCommandRunner{RunFunc: func(string, []string, []string) error {
+		delete(sm.Secrets, "db")
+		return fmt.Errorf("exit status 1")
+	}}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: sm,
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "db", "--command-retries", "3", "--refetch-on-restart"},
+		Sleep:         func(time.Duration) {},
+	}
+	if err := app.Run(); err == nil || !strings.Contains(err.Error(), "fetch secrets again") {
+		t.Errorf("Expected refetch error, got: %v", err)
+	}
+	if len(mockRunner.ExecutedCommands) != 1 {
+		t.Errorf("Expected 1 attempt, got: %d", len(mockRunner.ExecutedCommands))
+	}
+
+	// --command-retriesなしではエラー
+	if _, err := parseArgs([]string{"program", "/bin/true", "--refetch-on-restart"}); err == nil {
+		t.Error("Expected error for --refetch-on-restart without --command-retries")
+	}
+}
+
+func TestApplication_Run_CommandRetryJitter(t *testing.T) {
+	runWithSeed := func(seed int64) []time.Duration {
+		var delays []time.Duration
+		app := &Application{
+			Logger:        &MockLogger{},
+			SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}},
+			CommandRunner: &MockCommandRunner{ReturnError: fmt.Errorf("exit status 1")},
+			Args:          []string{"program", "/bin/false", "--key", "db", "--command-retries", "20", "--command-retry-delay", "10s", "--command-retry-jitter", "0.3"},
+			Sleep:         func(d time.Duration) { delays = append(delays, d) },
+			Rand:          rand.New(rand.NewSource(seed)),
+		}
+		if err := app.Run(); err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+		return delays
+	}
+
+	// 待ち時間は10s±30%の範囲に収まり、毎回同じではない
+	delays := runWithSeed(1)
+	if len(delays) != 20 {
+		t.Fatalf("Expected 20 delays, got: %v", delays)
+	}
+	distinct := map[time.Duration]bool{}
+	for _, d := range delays {
+		if d < 7*time.Second || d > 13*time.Second {
+			t.Errorf("delay %v outside [7s, 13s]", d)
+		}
+		distinct[d] = true
+	}
+	if len(distinct) < 2 {
+		t.Errorf("Expected jittered delays to vary, got: %v", delays)
+	}
+
+	// 同じシードなら同じ待ち時間になる
+	again := runWithSeed(1)
+	for i := range delays {
+		if again[i] != delays[i] {
+			t.Errorf("delay[%d] = %v with the same seed, want %v", i, again[i], delays[i])
+		}
+	}
+
+	// 範囲の両端
+	retry := commandRetry{delay: 10 * time.Second, jitter: 0.3, random: func() float64 { return 0 }}
+	if got := retry.jitteredDelay(); got != 7*time.Second {
+		t.Errorf("jitteredDelay() = %v, want 7s", got)
+	}
+	retry.random = func() float64 { return 0.5 }
+	if got := retry.jitteredDelay(); got != 10*time.Second {
+		t.Errorf("jitteredDelay() = %v, want 10s", got)
+	}
+
+	if _, err := parseArgs([]string{"program", "/bin/true", "--command-retry-jitter", "1.5"}); err == nil {
+		t.Error("Expected error for jitter above 1, got nil")
+	}
+}
+
+func TestApplication_Run_CommandRetriesStdinFromFile(t *testing.T) {
+	stdinPath := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(stdinPath, []byte("payload\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	runner := NewCommandRunner()
+	runner.Stdout = &stdout
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}},
+		CommandRunner: runner,
+		Args:          []string{"program", "/bin/sh", "-c", "cat; exit 1", "--key", "db", "--command-retries", "2", "--stdin-from-file", stdinPath},
+		Sleep:         func(time.Duration) {},
+	}
+
+	if err := app.Run(); err == nil {
+		t.Fatal("Expected error, got nil")
+	}
+	// 再試行のたびにファイルの先頭から読み直す
+	if got := stdout.String(); got != "payload\npayload\npayload\n" {
+		t.Errorf("stdout = %q, want the file contents once per attempt", got)
+	}
+
+	// --detachとは併用できず、合計時間の上限には--command-retriesが必要
+	for _, args := range [][]string{
+		{"program", "/bin/true", "--command-retries", "1", "--detach"},
+		{"program", "/bin/true", "--max-total-runtime-across-retries", "1m"},
+	} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("parseArgs(%v) expected error, got nil", args[2:])
+		}
+	}
+}
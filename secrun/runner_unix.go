@@ -0,0 +1,96 @@
+//go:build unix
+
+package secrun
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// configureProcAttr applies the platform-specific process attributes
+func (cr *DefaultCommandRunner) configureProcAttr(cmd *exec.Cmd) error {
+	if cr.Chroot != "" {
+		procAttr(cmd).Chroot = cr.Chroot
+	}
+	if cr.Detach {
+		// Start a new session so the command outlives our terminal
+		procAttr(cmd).Setsid = true
+	} else if f, ok := cr.Stdin.(*os.File); !ok || !isTerminal(f) {
+		// Run in its own process group so forwarded signals reach the whole tree.
+		// A command reading from our terminal stays in the foreground group,
+		// where the terminal already signals every process.
+		procAttr(cmd).Setpgid = true
+	}
+	return nil
+}
+
+// processGroup delivers signals to every process in a process group
+type processGroup struct {
+	pgid int
+}
+
+func (g processGroup) Signal(sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return os.ErrInvalid
+	}
+	return syscall.Kill(-g.pgid, s)
+}
+
+func (g processGroup) Kill() error {
+	return syscall.Kill(-g.pgid, syscall.SIGKILL)
+}
+
+// foregroundProcess delivers signals to a command sharing our terminal's foreground
+// process group. The terminal already sends the signals typed at it to every process
+// in the group, so relaying them again would deliver each one twice.
+type foregroundProcess struct {
+	*os.Process
+}
+
+func (p foregroundProcess) Signal(sig os.Signal) error {
+	if sig == os.Interrupt || sig == syscall.SIGQUIT {
+		return nil
+	}
+	return p.Process.Signal(sig)
+}
+
+// signalTarget returns where signals for a started command are delivered
+func (cr *DefaultCommandRunner) signalTarget(cmd *exec.Cmd) signalTarget {
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.Setpgid {
+		return processGroup{pgid: cmd.Process.Pid}
+	}
+	if f, ok := cr.Stdin.(*os.File); ok && !cr.Detach && inForegroundGroup(f) {
+		return foregroundProcess{cmd.Process}
+	}
+	return cmd.Process
+}
+
+// inForegroundGroup reports whether f is a terminal whose foreground process group
+// is ours, so that a command left in our group receives what is typed at it
+func inForegroundGroup(f *os.File) bool {
+	pgrp, err := unix.IoctlGetInt(int(f.Fd()), unix.TIOCGPGRP)
+	return err == nil && pgrp == unix.Getpgrp()
+}
+
+// procAttr returns the command's SysProcAttr, allocating it if needed
+func procAttr(cmd *exec.Cmd) *syscall.SysProcAttr {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	return cmd.SysProcAttr
+}
+
+// findExecutable returns dir/file if it is an executable regular file
+func findExecutable(dir, file string) (string, bool) {
+	path := filepath.Join(dir, file)
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+		return "", false
+	}
+	return path, true
+}
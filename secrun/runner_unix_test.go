@@ -0,0 +1,492 @@
+//go:build unix
+
+package secrun
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDefaultCommandRunner_Chroot(t *testing.T) {
+	runner := NewCommandRunner()
+	runner.Chroot = "/sandbox"
+
+	cmd, err := runner.command("/bin/true", nil, nil)
+	if err != nil {
+		t.Fatalf("command() error = %v", err)
+	}
+
+	// プロセス属性にchrootが設定されていることを確認
+	if cmd.SysProcAttr == nil || cmd.SysProcAttr.Chroot != "/sandbox" {
+		t.Errorf("Expected SysProcAttr.Chroot = /sandbox, got: %+v", cmd.SysProcAttr)
+	}
+
+	// chrootなしではプロセス属性を設定しない
+	runner.Chroot = ""
+	cmd, err = runner.command("/bin/true", nil, nil)
+	if err != nil {
+		t.Fatalf("command() error = %v", err)
+	}
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.Chroot != "" {
+		t.Errorf("Expected no chroot, got: %q", cmd.SysProcAttr.Chroot)
+	}
+}
+
+func TestDefaultCommandRunner_ChrootRun(t *testing.T) {
+	// chrootの実行にはroot権限が必要
+	if os.Geteuid() != 0 {
+		t.Skip("chroot requires root")
+	}
+
+	runner := NewCommandRunner()
+	runner.Chroot = "/"
+	if err := runner.Run("/bin/sh", []string{"-c", "exit 0"}, nil); err != nil {
+		t.Errorf("Run() with chroot error = %v", err)
+	}
+}
+
+func TestApplication_Run_ChrootConfiguresRunner(t *testing.T) {
+	runner := NewCommandRunner()
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{},
+		CommandRunner: runner,
+		Args:          []string{"program", "/nonexistent/command", "--root-dir", "/sandbox"},
+	}
+
+	// 実行は失敗するが、ランナーにはchrootが設定される
+	_ = app.Run()
+	if runner.Chroot != "/sandbox" {
+		t.Errorf("Expected runner chroot /sandbox, got: %q", runner.Chroot)
+	}
+}
+
+func TestDefaultCommandRunner_Detach(t *testing.T) {
+	dir := t.TempDir()
+	runner := NewCommandRunner()
+	runner.Detach = true
+	runner.DetachOutput = filepath.Join(dir, "output.log")
+	runner.PIDFile = filepath.Join(dir, "command.pid")
+
+	cmd, err := runner.command("/bin/sh", nil, nil)
+	if err != nil {
+		t.Fatalf("command() error = %v", err)
+	}
+	// デタッチ時は新しいセッションで起動する
+	if cmd.SysProcAttr == nil || !cmd.SysProcAttr.Setsid {
+		t.Errorf("Expected Setsid for detached command, got: %+v", cmd.SysProcAttr)
+	}
+
+	// 子プロセスの終了を待たずに戻ることを確認
+	start := time.Now()
+	if err := runner.Run("/bin/sh", []string{"-c", "echo started; sleep 5"}, nil); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Run() waited %v for a detached command", elapsed)
+	}
+
+	if runner.PID <= 0 {
+		t.Fatalf("Expected PID to be recorded, got: %d", runner.PID)
+	}
+	defer syscall.Kill(runner.PID, syscall.SIGKILL)
+
+	// PIDファイルに子プロセスのPIDが書き込まれている
+	data, err := os.ReadFile(runner.PIDFile)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if strings.TrimSpace(string(data)) != strconv.Itoa(runner.PID) {
+		t.Errorf("PID file = %q, want %d", data, runner.PID)
+	}
+
+	// 出力はファイルにリダイレクトされる
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		out, _ := os.ReadFile(runner.DetachOutput)
+		if strings.Contains(string(out), "started") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected detached output in %s, got: %q", runner.DetachOutput, out)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExitCode_ChildExitStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   int
+	}{
+		{name: "非ゼロで終了", script: "exit 42", want: 42},
+		{name: "シグナルで終了", script: "kill -KILL $$", want: 128 + int(syscall.SIGKILL)},
+		{name: "SIGTERMで終了", script: "kill -TERM $$", want: 128 + int(syscall.SIGTERM)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &Application{
+				Logger:        &MockLogger{},
+				SecretManager: &MockSecretManager{},
+				CommandRunner: NewCommandRunner(),
+				Args:          []string{"program", "/bin/sh", "-c", tt.script},
+			}
+
+			err := app.Run()
+			if err == nil {
+				t.Fatal("Expected error from the command, got nil")
+			}
+			// 子プロセスの終了コードがそのまま伝播する
+			if got := ExitCode(err); got != tt.want {
+				t.Errorf("exitCode() = %d, want %d (err: %v)", got, tt.want, err)
+			}
+		})
+	}
+}
+
+// readyWriter は最初の書き込みでreadyを閉じるio.Writer
+type readyWriter struct {
+	once  sync.Once
+	ready chan struct{}
+}
+
+func (w *readyWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.ready) })
+	return len(p), nil
+}
+
+func TestDefaultCommandRunner_RepeatedSignalKills(t *testing.T) {
+	runner, args, env := helperRunner(t, "ignore-signals")
+	stdout := &readyWriter{ready: make(chan struct{})}
+	runner.Stdout = stdout
+	sigCh := make(chan os.Signal)
+	runner.Signals = sigCh
+	runner.KillTimeout = time.Hour
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- runner.Run(os.Args[0], args, env) }()
+
+	select {
+	case <-stdout.ready:
+	case <-time.After(10 * time.Second):
+		t.Fatal("helper process did not become ready")
+	}
+
+	// シグナルを無視する子プロセスでも、2回目のSIGINTでSIGKILLされる
+	sigCh <- os.Interrupt
+	sigCh <- os.Interrupt
+
+	select {
+	case err := <-errCh:
+		if got := ExitCode(err); got != 128+int(syscall.SIGKILL) {
+			t.Errorf("exitCode() = %d, want %d (err: %v)", got, 128+int(syscall.SIGKILL), err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run() did not return after the second signal")
+	}
+}
+
+func TestApplication_Run_SigtermExit(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    int
+		wantErr bool
+	}{
+		{name: "デフォルトは143", want: 143, wantErr: true},
+		{name: "0に変換すると成功扱い", args: []string{"--sigterm-exit", "0"}, want: 0},
+		{name: "任意のコードに変換", args: []string{"--sigterm-exit", "42"}, want: 42, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner, helperArgs, _ := helperRunner(t, "sigterm")
+			t.Setenv("AWSECRUN_HELPER_PROCESS", "1")
+			t.Setenv("AWSECRUN_HELPER_MODE", "sigterm")
+
+			args := append([]string{"program", os.Args[0]}, helperArgs...)
+			app := &Application{
+				Logger:        &MockLogger{},
+				SecretManager: &MockSecretManager{},
+				CommandRunner: runner,
+				Args:          append(args, tt.args...),
+			}
+
+			// SIGTERMで終了した子プロセスの終了コードが変換される
+			err := app.Run()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && ExitCode(err) != tt.want {
+				t.Errorf("exitCode() = %d, want %d", ExitCode(err), tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultCommandRunner_ForwardsSignals(t *testing.T) {
+	tests := []struct {
+		name string
+		sig  os.Signal
+		want int
+	}{
+		{name: "SIGTERM", sig: syscall.SIGTERM, want: 7},
+		{name: "SIGHUP", sig: syscall.SIGHUP, want: 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner, args, env := helperRunner(t, "trap-signals")
+			stdout := &readyWriter{ready: make(chan struct{})}
+			runner.Stdout = stdout
+			sigCh := make(chan os.Signal, 1)
+			runner.Signals = sigCh
+
+			errCh := make(chan error, 1)
+			go func() { errCh <- runner.Run(os.Args[0], args, env) }()
+
+			select {
+			case <-stdout.ready:
+			case <-time.After(10 * time.Second):
+				t.Fatal("helper process did not become ready")
+			}
+
+			// 中継したシグナルを子プロセスが捕捉し、自身の終了コードで終了する
+			sigCh <- tt.sig
+			select {
+			case err := <-errCh:
+				if got := ExitCode(err); got != tt.want {
+					t.Errorf("exitCode() = %d, want %d (err: %v)", got, tt.want, err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("Run() did not return after the forwarded signal")
+			}
+		})
+	}
+}
+
+func TestDefaultCommandRunner_ProcessGroup(t *testing.T) {
+	runner := NewCommandRunner()
+	runner.Stdin = nil
+
+	cmd, err := runner.command("/bin/true", nil, nil)
+	if err != nil {
+		t.Fatalf("command() error = %v", err)
+	}
+	// 端末以外の標準入力では独自のプロセスグループで起動する
+	if cmd.SysProcAttr == nil || !cmd.SysProcAttr.Setpgid {
+		t.Errorf("Expected Setpgid, got: %+v", cmd.SysProcAttr)
+	}
+
+	// 孫プロセスを含むプロセスグループ全体にシグナルが届く
+	output := &readyBuffer{ready: make(chan struct{})}
+	runner.Stdout = output
+	sigCh := make(chan os.Signal, 1)
+	runner.Signals = sigCh
+	script := `(trap 'echo grandchild-term; exit 0' TERM; echo ready; while :; do sleep 0.05; done) & wait`
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- runner.Run("/bin/sh", []string{"-c", script}, nil) }()
+
+	select {
+	case <-output.ready:
+	case <-time.After(10 * time.Second):
+		t.Fatal("command did not become ready")
+	}
+	sigCh <- syscall.SIGTERM
+
+	select {
+	case <-errCh:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run() did not return after the forwarded signal")
+	}
+	if !strings.Contains(output.String(), "grandchild-term") {
+		t.Errorf("Expected the grandchild to receive SIGTERM, got output: %q", output.String())
+	}
+}
+
+func TestDefaultCommandRunner_ForegroundSignals(t *testing.T) {
+	cmd := exec.Command("/bin/sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	proc := foregroundProcess{cmd.Process}
+
+	// 端末のフォアグラウンドグループにいる子プロセスには、端末が送るシグナルを重ねて送らない
+	for _, sig := range []os.Signal{os.Interrupt, syscall.SIGQUIT} {
+		if err := proc.Signal(sig); err != nil {
+			t.Fatalf("Signal(%v) error = %v", sig, err)
+		}
+	}
+	select {
+	case err := <-exited:
+		t.Fatalf("Expected terminal signals not to be relayed, command exited: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// それ以外のシグナルは中継する
+	if err := proc.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("Signal(SIGTERM) error = %v", err)
+	}
+	select {
+	case err := <-exited:
+		if got := ExitCode(err); got != 128+int(syscall.SIGTERM) {
+			t.Errorf("exitCode() = %d, want %d (err: %v)", got, 128+int(syscall.SIGTERM), err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("command did not exit after SIGTERM")
+	}
+
+	// 割り込みを送らなくても、2回目の割り込みでSIGKILLに切り替える
+	cmd = exec.Command("/bin/sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	go func() { exited <- cmd.Wait() }()
+	sigCh := make(chan os.Signal)
+	done := make(chan struct{})
+	defer close(done)
+	go forwardSignals(foregroundProcess{cmd.Process}, sigCh, time.Hour, done, nil)
+	sigCh <- os.Interrupt
+	sigCh <- os.Interrupt
+	select {
+	case err := <-exited:
+		if got := ExitCode(err); got != 128+int(syscall.SIGKILL) {
+			t.Errorf("exitCode() = %d, want %d (err: %v)", got, 128+int(syscall.SIGKILL), err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("command was not killed after the second interrupt")
+	}
+
+	// 端末でない標準入力では割り込みも中継する
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer devNull.Close()
+	runner := NewCommandRunner()
+	runner.Stdin = devNull
+	if inForegroundGroup(devNull) {
+		t.Error("Expected /dev/null not to be a foreground terminal")
+	}
+	if _, ok := runner.signalTarget(&exec.Cmd{Process: cmd.Process}).(foregroundProcess); ok {
+		t.Error("Expected signals to be relayed to a command not reading from a terminal")
+	}
+}
+
+// readyBuffer は出力を記録し、"ready"の行が書かれたらreadyを閉じるio.Writer
+type readyBuffer struct {
+	mu    sync.Mutex
+	buf   strings.Builder
+	once  sync.Once
+	ready chan struct{}
+}
+
+func (b *readyBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Write(p)
+	if strings.Contains(b.buf.String(), "ready\n") {
+		b.once.Do(func() { close(b.ready) })
+	}
+	return len(p), nil
+}
+
+func (b *readyBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestApplication_RunContext_CancelTerminatesCommand(t *testing.T) {
+	runner, helperArgs, _ := helperRunner(t, "trap-signals")
+	t.Setenv("AWSECRUN_HELPER_PROCESS", "1")
+	t.Setenv("AWSECRUN_HELPER_MODE", "trap-signals")
+	stdout := &readyWriter{ready: make(chan struct{})}
+	runner.Stdout = stdout
+	runner.Signals = make(chan os.Signal)
+
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}},
+		CommandRunner: runner,
+		Args:          append([]string{"program", os.Args[0]}, append(helperArgs, "--key", "db")...),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- app.RunContext(ctx) }()
+
+	select {
+	case <-stdout.ready:
+	case <-time.After(10 * time.Second):
+		t.Fatal("helper process did not become ready")
+	}
+
+	// キャンセルすると子プロセスにSIGTERMが届き、キャンセルエラーが返る
+	cancel()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got: %v", err)
+		}
+		if got := ExitCode(err); got != 7 {
+			t.Errorf("exitCode() = %d, want 7 (err: %v)", got, err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("RunContext() did not return after cancellation")
+	}
+}
+
+func TestDefaultCommandRunner_CancelKillsAfterTimeout(t *testing.T) {
+	runner, args, env := helperRunner(t, "ignore-signals")
+	stdout := &readyWriter{ready: make(chan struct{})}
+	runner.Stdout = stdout
+	runner.Signals = make(chan os.Signal)
+	runner.KillTimeout = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runner.Context = ctx
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- runner.Run(os.Args[0], args, env) }()
+
+	select {
+	case <-stdout.ready:
+	case <-time.After(10 * time.Second):
+		t.Fatal("helper process did not become ready")
+	}
+
+	// SIGTERMを無視する子プロセスは猶予期間の後にSIGKILLされる
+	cancel()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got: %v", err)
+		}
+		if got := ExitCode(err); got != 128+int(syscall.SIGKILL) {
+			t.Errorf("exitCode() = %d, want %d (err: %v)", got, 128+int(syscall.SIGKILL), err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run() did not return after the kill timeout")
+	}
+
+	// キャンセル済みのコンテキストではコマンドを起動しない
+	if err := runner.Run("/bin/true", nil, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled for a cancelled context, got: %v", err)
+	}
+}
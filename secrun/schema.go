@@ -0,0 +1,25 @@
+package secrun
+
+import (
+	"fmt"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// validateSecretSchema validates a JSON secret against the JSON Schema in schemaPath
+func validateSecretSchema(secretString, schemaPath string) error {
+	schema, err := jsonschema.Compile(schemaPath)
+	if err != nil {
+		return fmt.Errorf("failed to load schema %s: %w", schemaPath, err)
+	}
+
+	doc, err := decodeJSON(secretString)
+	if err != nil {
+		return fmt.Errorf("secret is not valid JSON: %w", err)
+	}
+
+	if err := schema.Validate(doc); err != nil {
+		return fmt.Errorf("secret does not conform to schema %s: %w", schemaPath, err)
+	}
+	return nil
+}
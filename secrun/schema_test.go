@@ -0,0 +1,92 @@
+package secrun
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSchema = `{
+  "type": "object",
+  "required": ["DB_USER", "DB_PORT"],
+  "properties": {
+    "DB_USER": {"type": "string"},
+    "DB_PORT": {"type": "string", "pattern": "^[0-9]+$"}
+  }
+}`
+
+func writeSchema(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(path, []byte(testSchema), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func TestValidateSecretSchema(t *testing.T) {
+	path := writeSchema(t)
+
+	tests := []struct {
+		name    string
+		secret  string
+		wantErr bool
+	}{
+		{name: "スキーマに適合", secret: `{"DB_USER":"admin","DB_PORT":"5432"}`},
+		{name: "必須キーの欠落", secret: `{"DB_USER":"admin"}`, wantErr: true},
+		{name: "パターン不一致", secret: `{"DB_USER":"admin","DB_PORT":"abc"}`, wantErr: true},
+		{name: "JSONでない", secret: "plain", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSecretSchema(tt.secret, path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateSecretSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplication_Run_Schema(t *testing.T) {
+	path := writeSchema(t)
+
+	tests := []struct {
+		name    string
+		secret  string
+		wantErr bool
+	}{
+		{name: "適合するシークレット", secret: `{"DB_USER":"admin","DB_PORT":"5432"}`},
+		{name: "適合しないシークレット", secret: `{"DB_USER":"admin","DB_PORT":5432}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRunner := &MockCommandRunner{}
+			app := &Application{
+				Logger:        &MockLogger{},
+				SecretManager: &MockSecretManager{Secrets: map[string]string{"db": tt.secret}},
+				CommandRunner: mockRunner,
+				Args:          []string{"program", "/usr/bin/env", "--key", "db", "--schema", "db=" + path},
+			}
+
+			err := app.Run()
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), "schema validation") {
+				t.Fatalf("Expected schema validation error, got: %v", err)
+			}
+			// スキーマ違反の場合はコマンドを実行しない
+			if len(mockRunner.ExecutedCommands) > 0 {
+				t.Errorf("Expected no command execution, got: %d", len(mockRunner.ExecutedCommands))
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package secrun
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// forwardedSignals are relayed from AWSecRun to the running command
+var forwardedSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP}
+
+// signalTarget is the subset of *os.Process used to deliver signals
+type signalTarget interface {
+	Signal(sig os.Signal) error
+	Kill() error
+}
+
+// forwardSignals relays signals from sigCh to the process until done is closed.
+// A second signal escalates to SIGKILL immediately; otherwise the process is killed
+// once grace has elapsed after the first signal. A zero grace waits indefinitely.
+func forwardSignals(proc signalTarget, sigCh <-chan os.Signal, grace time.Duration, done <-chan struct{}, logger Logger) {
+	log := func(level, message string, data interface{}) {
+		if logger != nil {
+			logger.Log(level, message, data)
+		}
+	}
+
+	var timeout <-chan time.Time
+	received := 0
+	for {
+		select {
+		case <-done:
+			return
+		case sig := <-sigCh:
+			received++
+			if received > 1 {
+				log("warn", "Received repeated signal; killing command", map[string]string{"signal": sig.String()})
+				proc.Kill()
+				continue
+			}
+			log("info", "Forwarding signal to command", map[string]string{"signal": sig.String()})
+			proc.Signal(sig)
+			if grace > 0 {
+				timeout = time.After(grace)
+			}
+		case <-timeout:
+			log("warn", "Command did not exit within the grace period; killing command", map[string]string{"gracePeriod": grace.String()})
+			proc.Kill()
+			timeout = nil
+		}
+	}
+}
+
+// contextSignals relays sigCh and adds a SIGTERM once ctx is cancelled, so that
+// cancellation follows the forwarded-signal path, grace period included. It stops
+// when done is closed.
+func contextSignals(ctx context.Context, sigCh <-chan os.Signal, done <-chan struct{}, logger Logger) <-chan os.Signal {
+	out := make(chan os.Signal)
+	go func() {
+		cancelled := ctx.Done()
+		for {
+			var sig os.Signal
+			select {
+			case <-done:
+				return
+			case sig = <-sigCh:
+			case <-cancelled:
+				cancelled = nil
+				if logger != nil {
+					logger.Log("info", "Run cancelled; terminating command", map[string]string{"cause": context.Cause(ctx).Error()})
+				}
+				sig = syscall.SIGTERM
+			}
+			select {
+			case out <- sig:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// notifySignals subscribes to the forwarded signals, returning the channel and a stop function
+func notifySignals() (<-chan os.Signal, func()) {
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, forwardedSignals...)
+	return sigCh, func() { signal.Stop(sigCh) }
+}
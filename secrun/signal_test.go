@@ -0,0 +1,112 @@
+package secrun
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeProcess は受け取ったシグナルを記録するsignalTargetのモック実装
+type fakeProcess struct {
+	mu      sync.Mutex
+	signals []os.Signal
+	killed  chan struct{}
+}
+
+func newFakeProcess() *fakeProcess {
+	return &fakeProcess{killed: make(chan struct{}, 1)}
+}
+
+func (p *fakeProcess) Signal(sig os.Signal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.signals = append(p.signals, sig)
+	return nil
+}
+
+func (p *fakeProcess) Kill() error {
+	p.Signal(syscall.SIGKILL)
+	p.killed <- struct{}{}
+	return nil
+}
+
+func (p *fakeProcess) received() []os.Signal {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]os.Signal(nil), p.signals...)
+}
+
+func TestForwardSignals_SecondSignalKills(t *testing.T) {
+	proc := newFakeProcess()
+	sigCh := make(chan os.Signal)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	// 猶予期間が長くても2回目のシグナルで即座にSIGKILLする
+	go func() {
+		forwardSignals(proc, sigCh, time.Hour, done, &MockLogger{})
+		close(finished)
+	}()
+
+	sigCh <- os.Interrupt
+	sigCh <- os.Interrupt
+
+	select {
+	case <-proc.killed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the process to be killed after the second signal")
+	}
+	close(done)
+	<-finished
+
+	got := proc.received()
+	if len(got) != 2 || got[0] != os.Interrupt || got[1] != syscall.SIGKILL {
+		t.Errorf("Expected [interrupt killed], got: %v", got)
+	}
+}
+
+func TestForwardSignals_GracePeriod(t *testing.T) {
+	proc := newFakeProcess()
+	sigCh := make(chan os.Signal)
+	done := make(chan struct{})
+	defer close(done)
+
+	go forwardSignals(proc, sigCh, 10*time.Millisecond, done, nil)
+
+	sigCh <- syscall.SIGTERM
+
+	// 猶予期間が過ぎたらSIGKILLする
+	select {
+	case <-proc.killed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the process to be killed after the grace period")
+	}
+
+	got := proc.received()
+	if len(got) != 2 || got[0] != syscall.SIGTERM || got[1] != syscall.SIGKILL {
+		t.Errorf("Expected [terminated killed], got: %v", got)
+	}
+}
+
+func TestForwardSignals_StopsWhenDone(t *testing.T) {
+	proc := newFakeProcess()
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		forwardSignals(proc, make(chan os.Signal), 0, done, nil)
+		close(finished)
+	}()
+	close(done)
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected forwardSignals to return once done is closed")
+	}
+	if got := proc.received(); len(got) != 0 {
+		t.Errorf("Expected no signals, got: %v", got)
+	}
+}
@@ -0,0 +1,89 @@
+package secrun
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// ssmAPI is the subset of the SSM client used by SSMSecretManager
+type ssmAPI interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+}
+
+// SSMSecretManager implements SecretManager using AWS Systems Manager Parameter Store
+type SSMSecretManager struct {
+	ctx context.Context
+	// loadConfig loads the AWS configuration the client is built from
+	loadConfig func() (aws.Config, error)
+
+	once   sync.Once
+	client ssmAPI
+	err    error
+}
+
+// NewSSMSecretManager creates a new SSMSecretManager
+func NewSSMSecretManager() *SSMSecretManager {
+	m := &SSMSecretManager{
+		ctx: context.Background(),
+	}
+	m.loadConfig = func() (aws.Config, error) {
+		cfg, err := config.LoadDefaultConfig(m.ctx)
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("failed to load AWS config: %w", err)
+		}
+		return cfg, nil
+	}
+	return m
+}
+
+// SetContext sets the context used for subsequent requests
+func (m *SSMSecretManager) SetContext(ctx context.Context) {
+	m.ctx = ctx
+}
+
+// Source reports the backend label of SSMSecretManager
+func (m *SSMSecretManager) Source() string {
+	return SourceSSM
+}
+
+// getClient lazily creates the SSM client from the default AWS config
+func (m *SSMSecretManager) getClient() (ssmAPI, error) {
+	m.once.Do(func() {
+		if m.client != nil {
+			return
+		}
+		cfg, err := m.loadConfig()
+		if err != nil {
+			m.err = err
+			return
+		}
+		m.client = ssm.NewFromConfig(cfg)
+	})
+	return m.client, m.err
+}
+
+// GetSecret retrieves a parameter, decrypting SecureString values
+func (m *SSMSecretManager) GetSecret(secretName string) (string, error) {
+	client, err := m.getClient()
+	if err != nil {
+		return "", err
+	}
+
+	result, err := client.GetParameter(m.ctx, &ssm.GetParameterInput{
+		Name:           aws.String(secretName),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get parameter: %w", err)
+	}
+
+	if result.Parameter == nil {
+		return "", nil
+	}
+	return aws.ToString(result.Parameter.Value), nil
+}
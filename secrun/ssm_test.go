@@ -0,0 +1,113 @@
+package secrun
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// MockSSMClient はSSMクライアントのモック実装
+type MockSSMClient struct {
+	Parameters map[string]types.Parameter
+	Inputs     []ssm.GetParameterInput
+}
+
+// GetParameter はモックされたパラメータを返す
+func (c *MockSSMClient) GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	c.Inputs = append(c.Inputs, *params)
+	param, ok := c.Parameters[aws.ToString(params.Name)]
+	if !ok {
+		return nil, fmt.Errorf("ParameterNotFound: %s", aws.ToString(params.Name))
+	}
+	// SecureStringは復号が要求された場合のみ平文を返す
+	if param.Type == types.ParameterTypeSecureString && !aws.ToBool(params.WithDecryption) {
+		param.Value = aws.String("AQICAHh-encrypted")
+	}
+	return &ssm.GetParameterOutput{Parameter: &param}, nil
+}
+
+func newMockSSMClient() *MockSSMClient {
+	return &MockSSMClient{
+		Parameters: map[string]types.Parameter{
+			"/app/log-level": {Type: types.ParameterTypeString, Value: aws.String("debug")},
+			"/app/db-pass":   {Type: types.ParameterTypeSecureString, Value: aws.String("secure123")},
+			"/app/db":        {Type: types.ParameterTypeSecureString, Value: aws.String(`{"DB_HOST":"db.local","DB_PORT":"5432"}`)},
+		},
+	}
+}
+
+func TestSSMSecretManager_GetSecret(t *testing.T) {
+	client := newMockSSMClient()
+	manager := NewSSMSecretManager()
+	manager.client = client
+
+	tests := []struct {
+		name  string
+		param string
+		want  string
+	}{
+		{name: "String", param: "/app/log-level", want: "debug"},
+		{name: "SecureString", param: "/app/db-pass", want: "secure123"},
+		{name: "JSONの値", param: "/app/db", want: `{"DB_HOST":"db.local","DB_PORT":"5432"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := manager.GetSecret(tt.param)
+			if err != nil {
+				t.Fatalf("GetSecret() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("GetSecret() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// 常に復号を要求する
+	for _, input := range client.Inputs {
+		if !aws.ToBool(input.WithDecryption) {
+			t.Errorf("Expected WithDecryption for %s", aws.ToString(input.Name))
+		}
+	}
+
+	if _, err := manager.GetSecret("/app/missing"); err == nil {
+		t.Error("Expected error for missing parameter, got nil")
+	}
+}
+
+func TestApplication_Run_SSMParams(t *testing.T) {
+	manager := NewSSMSecretManager()
+	manager.client = newMockSSMClient()
+	mockSecretManager := &MockSecretManager{Secrets: map[string]string{"api": `{"API_KEY":"xyz"}`}}
+	mockRunner := &MockCommandRunner{}
+
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: mockSecretManager,
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--param", "/app/db", "--key", "api", "--param", "/app/db-pass"},
+		Backends:      map[string]SecretManager{SourceSSM: manager},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// JSONのパラメータは複数の環境変数に展開され、単一値はsecretキーになる
+	env := strings.Join(mockRunner.ExecutedCommands[0].Env, "\n")
+	for _, want := range []string{"DB_HOST=db.local", "DB_PORT=5432", "API_KEY=xyz", "secret=secure123"} {
+		if !strings.Contains(env, want) {
+			t.Errorf("Expected environment variable %s", want)
+		}
+	}
+
+	// SSMのパラメータはSecrets Managerに問い合わせない
+	if len(mockSecretManager.Calls) != 1 {
+		t.Errorf("Expected 1 Secrets Manager call, got: %v", mockSecretManager.Calls)
+	}
+}
@@ -0,0 +1,32 @@
+package secrun
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// renderTemplate evaluates a text/template against vars, failing on undefined references
+func renderTemplate(text string, vars map[string]string) (string, error) {
+	tmpl, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template %q: %w", text, err)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return "", fmt.Errorf("failed to render template %q: %w", text, err)
+	}
+	return b.String(), nil
+}
+
+// environMap converts KEY=VALUE entries into a map
+func environMap(env []string) map[string]string {
+	vars := make(map[string]string, len(env))
+	for _, entry := range env {
+		if key, value, ok := strings.Cut(entry, "="); ok {
+			vars[key] = value
+		}
+	}
+	return vars
+}
@@ -0,0 +1,51 @@
+package secrun
+
+import "time"
+
+// Run phases reported in the final log entry, in the order they happen
+const (
+	// PhaseSetup covers option handling before the AWS config is loaded
+	PhaseSetup = "setup"
+	// PhaseLockWait is the time spent waiting for --lock-file, reported only when it is set
+	PhaseLockWait = "lockWait"
+	// PhaseConfigLoad covers loading the AWS config and resolving its credentials
+	PhaseConfigLoad  = "configLoad"
+	PhaseFetch       = "fetch"
+	PhaseEnvAssembly = "envAssembly"
+	PhaseCommand     = "command"
+)
+
+// phaseTimer measures consecutive phases of a run against a clock
+type phaseTimer struct {
+	now   func() time.Time
+	start time.Time
+	last  time.Time
+	// durations holds the time spent in each completed phase
+	durations map[string]time.Duration
+}
+
+// newPhaseTimer starts timing at the current time of now, defaulting to time.Now
+func newPhaseTimer(now func() time.Time) *phaseTimer {
+	if now == nil {
+		now = time.Now
+	}
+	start := now()
+	return &phaseTimer{now: now, start: start, last: start, durations: map[string]time.Duration{}}
+}
+
+// End records the time since the previous phase ended as the duration of phase
+func (t *phaseTimer) End(phase string) {
+	current := t.now()
+	t.durations[phase] += current.Sub(t.last)
+	t.last = current
+}
+
+// Milliseconds returns each completed phase and the total elapsed time in milliseconds
+func (t *phaseTimer) Milliseconds() map[string]float64 {
+	ms := make(map[string]float64, len(t.durations)+1)
+	for phase, d := range t.durations {
+		ms[phase] = float64(d) / float64(time.Millisecond)
+	}
+	ms["total"] = float64(t.last.Sub(t.start)) / float64(time.Millisecond)
+	return ms
+}
@@ -0,0 +1,131 @@
+package secrun
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// fakeClock は呼ばれるたびにstepsの時間だけ進む時計を返す
+func fakeClock(steps ...time.Duration) func() time.Time {
+	current := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	calls := 0
+	return func() time.Time {
+		if calls < len(steps) {
+			current = current.Add(steps[calls])
+		}
+		calls++
+		return current
+	}
+}
+
+// finalDurations は最後のログエントリのフェーズ別所要時間を返す
+func finalDurations(t *testing.T, logger *MockLogger) map[string]float64 {
+	t.Helper()
+
+	last := logger.Logs[len(logger.Logs)-1]
+	data, ok := last.Data.(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected map data in %q, got: %T", last.Message, last.Data)
+	}
+	durations, ok := data["durationsMs"].(map[string]float64)
+	if !ok {
+		t.Fatalf("Expected durationsMs in %q, got: %v", last.Message, data)
+	}
+	return durations
+}
+
+func TestApplication_Run_PhaseDurations(t *testing.T) {
+	tests := []struct {
+		name      string
+		runErr    error
+		wantLevel string
+	}{
+		{name: "成功", wantLevel: "info"},
+		{name: "コマンド失敗", runErr: errors.New("boom"), wantLevel: "error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &MockLogger{}
+			app := &Application{
+				Logger:        logger,
+				SecretManager: &MockSecretManager{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}},
+				CommandRunner: &MockCommandRunner{ReturnError: tt.runErr},
+				Args:          []string{"program", "/usr/bin/env", "--key", "db"},
+				Now:           fakeClock(0, 5*time.Millisecond, 7*time.Millisecond, 20*time.Millisecond, 3*time.Millisecond, 100*time.Millisecond),
+			}
+			_ = app.Run()
+
+			if last := logger.Logs[len(logger.Logs)-1]; last.Level != tt.wantLevel {
+				t.Errorf("final log level = %s, want %s", last.Level, tt.wantLevel)
+			}
+
+			// 各フェーズの所要時間が記録される
+			durations := finalDurations(t, logger)
+			want := map[string]float64{
+				PhaseSetup:       5,
+				PhaseConfigLoad:  7,
+				PhaseFetch:       20,
+				PhaseEnvAssembly: 3,
+				PhaseCommand:     100,
+			}
+			sum := 0.0
+			for phase, ms := range want {
+				if durations[phase] != ms {
+					t.Errorf("%s = %vms, want %vms", phase, durations[phase], ms)
+				}
+				sum += durations[phase]
+			}
+
+			// --lock-fileなしではロック待ちを報告しない
+			if _, ok := durations[PhaseLockWait]; ok {
+				t.Errorf("Expected no %s without --lock-file, got: %v", PhaseLockWait, durations)
+			}
+
+			// フェーズの合計が全体の所要時間と一致する
+			if math.Abs(sum-durations["total"]) > 0.001 {
+				t.Errorf("sum of phases = %vms, total = %vms", sum, durations["total"])
+			}
+		})
+	}
+}
+
+func TestApplication_Run_ConfigLoadPhase(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	client := &MockSecretsManagerClient{Secrets: map[string]string{"db": `{"DB_USER":"admin"}`}}
+	calls := 0
+	sm := newTestAWSSecretManager(client, &calls)
+	load := sm.loadConfig
+	// 設定の読み込みに7msかかるローダー
+	sm.loadConfig = func(ctx context.Context, optFns ...func(*config.LoadOptions) error) (aws.Config, error) {
+		now = now.Add(7 * time.Millisecond)
+		return load(ctx, optFns...)
+	}
+
+	logger := &MockLogger{}
+	app := &Application{
+		Logger:        logger,
+		SecretManager: sm,
+		CommandRunner: &MockCommandRunner{},
+		Args:          []string{"program", "/usr/bin/env", "--key", "db"},
+		Now:           func() time.Time { return now },
+	}
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// AWS設定の読み込みは取得とは別のconfigLoadフェーズに計上される
+	durations := finalDurations(t, logger)
+	if durations[PhaseConfigLoad] != 7 || durations[PhaseFetch] != 0 {
+		t.Errorf("configLoad = %vms, fetch = %vms, want 7ms and 0ms", durations[PhaseConfigLoad], durations[PhaseFetch])
+	}
+	if calls != 1 {
+		t.Errorf("Expected config loader to be called once, got: %d", calls)
+	}
+}
@@ -0,0 +1,107 @@
+package secrun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// VaultSecretManager implements SecretManager using the HashiCorp Vault KV v2 engine
+type VaultSecretManager struct {
+	ctx context.Context
+	// Addr and Token default to VAULT_ADDR and VAULT_TOKEN
+	Addr   string
+	Token  string
+	Client *http.Client
+}
+
+// NewVaultSecretManager creates a VaultSecretManager configured from the environment
+func NewVaultSecretManager() *VaultSecretManager {
+	return &VaultSecretManager{
+		ctx:    context.Background(),
+		Addr:   os.Getenv("VAULT_ADDR"),
+		Token:  os.Getenv("VAULT_TOKEN"),
+		Client: http.DefaultClient,
+	}
+}
+
+// SetContext sets the context used for subsequent requests
+func (m *VaultSecretManager) SetContext(ctx context.Context) {
+	m.ctx = ctx
+}
+
+// Source reports the backend label of VaultSecretManager
+func (m *VaultSecretManager) Source() string {
+	return SourceVault
+}
+
+// kvV2Response is the shape of a Vault KV v2 read response
+type kvV2Response struct {
+	Data struct {
+		Data map[string]interface{} `json:"data"`
+	} `json:"data"`
+}
+
+// kvV2URL maps "mount/path" to the KV v2 data endpoint of the mount
+func kvV2URL(addr, secretPath string) (string, error) {
+	mount, path, ok := strings.Cut(strings.Trim(secretPath, "/"), "/")
+	if !ok || mount == "" || path == "" {
+		return "", fmt.Errorf("invalid Vault path %q: expected MOUNT/PATH", secretPath)
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(addr, "/"))
+	if err != nil {
+		return "", fmt.Errorf("invalid VAULT_ADDR %q: %w", addr, err)
+	}
+	return base.JoinPath("v1", mount, "data", path).String(), nil
+}
+
+// GetSecret reads a KV v2 secret and returns its data map encoded as JSON
+func (m *VaultSecretManager) GetSecret(secretName string) (string, error) {
+	if m.Addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+	if m.Token == "" {
+		return "", fmt.Errorf("VAULT_TOKEN is not set")
+	}
+
+	endpoint, err := kvV2URL(m.Addr, secretName)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(m.ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", m.Token)
+
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to read from Vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read Vault response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Vault returned %s for %s", resp.Status, secretName)
+	}
+
+	var result kvV2Response
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("failed to decode Vault response: %w", err)
+	}
+
+	data, err := json.Marshal(result.Data.Data)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,105 @@
+package secrun
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newVaultServer はVault KV v2のレスポンスを模倣するテストサーバーを返す
+func newVaultServer(t *testing.T, secrets map[string]string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "test-token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		data, ok := secrets[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		w.Write([]byte(`{"data":{"data":` + data + `,"metadata":{"version":3}}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestVaultSecretManager_GetSecret(t *testing.T) {
+	server := newVaultServer(t, map[string]string{
+		"/v1/secret/data/myapp/db": `{"DB_USER":"admin","DB_PASSWORD":"secure123"}`,
+	})
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "test-token")
+	m := NewVaultSecretManager()
+
+	got, err := m.GetSecret("secret/myapp/db")
+	if err != nil {
+		t.Fatalf("GetSecret() error = %v", err)
+	}
+
+	// dataの中身がJSONとして返され、既存のパーサーで展開できる
+	values, err := parseSecretJSON(got)
+	if err != nil {
+		t.Fatalf("parseSecretJSON() error = %v", err)
+	}
+	if values["DB_USER"] != "admin" || values["DB_PASSWORD"] != "secure123" {
+		t.Errorf("values = %v, want DB_USER and DB_PASSWORD", values)
+	}
+
+	// 存在しないパスはエラー
+	if _, err := m.GetSecret("secret/missing"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Expected 404 error, got: %v", err)
+	}
+
+	// マウントのみのパスはエラー
+	if _, err := m.GetSecret("secret"); err == nil {
+		t.Error("Expected error for path without mount, got nil")
+	}
+
+	// トークンが違えば拒否される
+	m.Token = "wrong"
+	if _, err := m.GetSecret("secret/myapp/db"); err == nil {
+		t.Error("Expected error for invalid token, got nil")
+	}
+
+	// 環境変数が未設定ならエラー
+	m.Addr = ""
+	if _, err := m.GetSecret("secret/myapp/db"); err == nil || !strings.Contains(err.Error(), "VAULT_ADDR") {
+		t.Errorf("Expected VAULT_ADDR error, got: %v", err)
+	}
+}
+
+func TestApplication_Run_MixedVaultAndAWS(t *testing.T) {
+	server := newVaultServer(t, map[string]string{
+		"/v1/kv/data/app": `{"VAULT_KEY":"from-vault"}`,
+	})
+	vault := NewVaultSecretManager()
+	vault.Addr = server.URL
+	vault.Token = "test-token"
+
+	mockRunner := &MockCommandRunner{}
+	app := &Application{
+		Logger:        &MockLogger{},
+		SecretManager: &MockSecretManager{Secrets: map[string]string{"aws-secret": `{"AWS_KEY":"from-aws"}`}},
+		CommandRunner: mockRunner,
+		Args:          []string{"program", "/usr/bin/env", "--key", "aws-secret", "--vault", "kv/app"},
+		Backends:      map[string]SecretManager{SourceVault: vault},
+	}
+
+	if err := app.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 1回の実行でAWSとVaultのシークレットを併用できる
+	env := strings.Join(mockRunner.ExecutedCommands[0].Env, "\n")
+	for _, want := range []string{"AWS_KEY=from-aws", "VAULT_KEY=from-vault"} {
+		if !strings.Contains(env, want) {
+			t.Errorf("Expected environment variable %s", want)
+		}
+	}
+}